@@ -1,20 +1,49 @@
 import {
   genKey,
+  computePublic,
   Client,
   Params,
 } from './srp.js';
 
 import { encodeBase64, decodeBase64 } from './utils.js';
 
-const params = Params['3072'];
+// The handshake names the group, hash and KDF of the user's verifier. A is
+// sent before that, so it is computed in the group of the last handshake
+// and the handshake is repeated if the server names another one.
+const DEFAULT_GROUP = '3072';
+// A from the smallest group fits every group, used when A from the guessed
+// group is too long for the user's
+const PROBE_GROUP = '1024';
+// Server hash names and their WebCrypto equivalent
+const HASHES = {
+  'SHA-1': 'SHA-1',
+  'SHA-256': 'SHA-256',
+  'SHA-384': 'SHA-384',
+  'SHA-512': 'SHA-512',
+};
+const KDFS = ['', 'rfc5054'];
 const HANDSHAKE_ROUTE = '/api/auth/handshake';
 const VERIFY_ROUTE = '/api/auth/verify';
 const WHOAMI_ROUTE = '/api/auth/whoami';
+const REAUTH_ROUTE = '/api/auth/reauth';
 
 export const AUTH_OK = 'ok';
-export const AUTH_WRONG_USERNAME = 'wrong username';
-export const AUTH_WRONG_PASSWORD = 'wrong password';
+// The server does not tell unknown usernames from wrong passwords.
+export const AUTH_INVALID_CREDENTIALS = 'invalid username or password';
 export const AUTH_INVALID_SERVER = 'wrong server';
+export const AUTH_UNAVAILABLE = 'unavailable';
+// The user's verifier uses a group, hash or KDF the browser cannot compute.
+export const AUTH_UNSUPPORTED = 'unsupported parameters';
+export const AUTH_ERROR = 'error';
+
+class AuthError extends Error {
+  constructor(body) {
+    super(body.message);
+    this.code = body.code;
+    this.retryable = body.retryable;
+    this.retryAfter = body.retryAfter;
+  }
+}
 
 async function request(url, body) {
   const resp = await fetch(url, {
@@ -24,47 +53,96 @@ async function request(url, body) {
     },
     body: JSON.stringify(body),
   });
+  if (!resp.ok) {
+    let errBody;
+    try {
+      errBody = await resp.json();
+    } catch (err) {
+      errBody = { code: 'internal_error', message: resp.statusText, retryable: false };
+    }
+    throw new AuthError(errBody);
+  }
   return await resp.json();
 }
 
-export async function launchHandshake(username, password) {
-  const clientRandomPK = genKey();
-  const client = await Client.new(params, clientRandomPK);
-  let resp, resp2;
-  try {
-    resp = await request(HANDSHAKE_ROUTE, {
-      username,
-      clientpublic: encodeBase64(client.computeA()),
-    });
-  } catch (err) {
-    return {
-      status: AUTH_WRONG_USERNAME,
-    };
+function failure(err) {
+  let status = AUTH_ERROR;
+  if (err.code === 'invalid_credentials') {
+    status = AUTH_INVALID_CREDENTIALS;
+  } else if (err.code === 'unavailable') {
+    status = AUTH_UNAVAILABLE;
   }
+  return {
+    status,
+    code: err.code,
+    retryable: !!err.retryable,
+    retryAfter: err.retryAfter,
+  };
+}
+
+let lastGroup = DEFAULT_GROUP;
+
+// handshake starts a handshake in the group the server names for username,
+// and returns the response with the secret a, or an unsupported status.
+async function handshake(username) {
+  let group = lastGroup;
+  // At most a probe and a retry in the group it names
+  for (let attempt = 0; attempt < 3; attempt++) {
+    const secret = genKey();
+    let resp;
+    try {
+      resp = await request(HANDSHAKE_ROUTE, {
+        username,
+        clientpublic: encodeBase64(computePublic(Params[group], secret)),
+      });
+    } catch (err) {
+      if (err.code === 'invalid_client_public' && group !== PROBE_GROUP) {
+        group = PROBE_GROUP;
+        continue;
+      }
+      return { failure: failure(err) };
+    }
+
+    if (resp.group === group) {
+      lastGroup = group;
+      return { resp, secret };
+    }
+    if (!Params[resp.group]) {
+      break;
+    }
+    group = resp.group;
+  }
+  return { failure: { status: AUTH_UNSUPPORTED } };
+}
+
+// authenticate runs a handshake for username and sends the proof to route
+// in the body made by proofBody.
+async function authenticate(username, password, route, proofBody) {
+  const { resp, secret, failure: handshakeFailure } = await handshake(username);
+  if (handshakeFailure) {
+    return handshakeFailure;
+  }
+
+  const hash = HASHES[resp.hash];
+  const kdf = resp.kdf || '';
+  if (!hash || !KDFS.includes(kdf)) {
+    return { status: AUTH_UNSUPPORTED };
+  }
+  const params = { ...Params[resp.group], hash, kdf };
+  const client = await Client.new(params, secret);
 
   const hid = resp.hid;
   const salt = decodeBase64(resp.salt);
   const publicKey = decodeBase64(resp.publickey);
+  let resp2;
 
   await client.setCredentials(username, password, salt);
   await client.setB(publicKey);
 
   try {
-    resp2 = await request(VERIFY_ROUTE, {
-      hid,
-      username,
-      clientproof: encodeBase64(client.computeM1()),
-    });
+    resp2 = await request(route, proofBody(hid, encodeBase64(client.computeM1())));
   } catch (err) {
-    return {
-      status: AUTH_WRONG_PASSWORD,
-    };
-  }
-
-  if (!resp2.result) {
-    return {
-      status: AUTH_WRONG_PASSWORD,
-    };
+    return failure(err);
   }
 
   if (!client.checkM2(decodeBase64(resp2.serverproof))) {
@@ -80,6 +158,25 @@ export async function launchHandshake(username, password) {
   };
 }
 
+export async function launchHandshake(username, password) {
+  return authenticate(username, password, VERIFY_ROUTE, (hid, clientproof) => ({
+    hid,
+    username,
+    clientproof,
+  }));
+}
+
+// launchReauth proves the password again for an existing session, before
+// calling routes that require recent authentication. On success, use the
+// returned sessionId and secret from then on, the server replaces both.
+export async function launchReauth(username, password, sessionId) {
+  return authenticate(username, password, REAUTH_ROUTE, (hid, clientproof) => ({
+    sessionid: sessionId,
+    hid,
+    clientproof,
+  }));
+}
+
 export async function launchWhoami(sessionId) {
   const resp = await request(WHOAMI_ROUTE, {
     sessionid: sessionId,
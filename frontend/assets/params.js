@@ -97,8 +97,8 @@ export const Params = {
     g: hex('05'),
     hash: 'SHA-512'},
 
-  6244: {
-    N_length_bits: 6244,
+  6144: {
+    N_length_bits: 6144,
     N: hex(' FFFFFFFF FFFFFFFF C90FDAA2 2168C234 C4C6628B 80DC1CD1 29024E08'
            +'8A67CC74 020BBEA6 3B139B22 514A0879 8E3404DD EF9519B3 CD3A431B'
            +'302B0A6D F25F1437 4FE1356D 6D51C245 E485B576 625E7EC6 F44C42E9'
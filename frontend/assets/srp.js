@@ -39,6 +39,11 @@ function padToN(number, params) {
  * compute the intermediate value x as a hash of three buffers:
  * salt, identity, and password.  And a colon.  FOUR buffers.
  *
+ *      x = H(s | I | ":" | P)
+ *
+ * or, when params.kdf is 'rfc5054' as for verifiers made by GnuTLS and
+ * OpenSSL,
+ *
  *      x = H(s | H(I | ":" | P))
  *
  * params:
@@ -49,9 +54,13 @@ function padToN(number, params) {
  * returns: x (bignum)      user secret
  */
 async function getX(params, salt, I, P) {
+  let creds = encodeString(`${I}:${P}`);
+  if (params.kdf === 'rfc5054') {
+    creds = await new Hasher(params.hash).update(creds).digest();
+  }
   const xBuf = await new Hasher(params.hash)
     .update(salt)
-    .update(encodeString(`${I}:${P}`))
+    .update(creds)
     .digest();
 
   return bufToBn(xBuf);
@@ -64,7 +73,7 @@ async function getX(params, salt, I, P) {
  * The verifier (v) is computed based on the salt (s), user name (I),
  * password (P), and group parameters (N, g).
  *
- *         x = H(s | H(I | ":" | P))
+ *         x as computed by getX
  *         v = g^x % N
  *
  * params:
@@ -129,6 +138,19 @@ function getA(params, a_num) {
   return padToN(modPow(params.g, a_num, params.N), params);
 };
 
+/*
+ * The public value A for the secret a, which only depends on the group.
+ *
+ * params:
+ *         params (obj)      group parameters, with .N, .g
+ *         secret1Buf (buf)  client secret exponent, as from genKey
+ *
+ * returns: buffer
+ */
+function computePublic(params, secret1Buf) {
+  return getA(params, bufToBn(secret1Buf));
+};
+
 /*
  * getU() hashes the two public messages together, to obtain a scrambling
  * parameter "u" which cannot be predicted by either party ahead of time.
@@ -296,6 +318,7 @@ class Client {
 
 export {
   genKey,
+  computePublic,
   computeVerifier,
   Client,
   Params,
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sharpstorm/srp-auth/config"
+	"sharpstorm/srp-auth/metrics"
+	"sharpstorm/srp-auth/srpauth"
+	"sharpstorm/srp-auth/tlsutil"
+)
+
+// Set at build time with -ldflags "-X main.version=...", see the makefile.
+var version = "dev"
+
+type statusOutput struct {
+	Status string `json:"status"`
+	Reason string `json:"reason,omitempty"`
+}
+
+type versionOutput struct {
+	Version   string `json:"version"`
+	GoVersion string `json:"goVersion"`
+	Revision  string `json:"revision,omitempty"`
+	Time      string `json:"time,omitempty"`
+	Modified  bool   `json:"modified,omitempty"`
+}
+
+// newAdminServer returns nil when admin.listenAddr is not set. It stays up
+// until the main listener has shut down, so draining can be watched.
+func newAdminServer(cfg *config.Config, metricsRegistry *metrics.Registry, authServer *srpauth.Server, certSource tlsutil.CertSource) (*http.Server, error) {
+	if cfg.Admin.ListenAddr == "" {
+		return nil, nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", metricsRegistry.Handler())
+	mux.HandleFunc("GET /healthz", getHealth)
+	mux.HandleFunc("GET /readyz", getReadiness(authServer))
+	mux.HandleFunc("GET /version", getVersion)
+	adminServer := &http.Server{
+		Addr:              cfg.Admin.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader.Duration(),
+		WriteTimeout:      cfg.Timeouts.Write.Duration(),
+		IdleTimeout:       cfg.Timeouts.Idle.Duration(),
+	}
+
+	switch {
+	case !cfg.Admin.TLS:
+	case cfg.Admin.ClientCAFile != "":
+		clientCAs, err := tlsutil.LoadCertPool(cfg.Admin.ClientCAFile)
+		if err != nil {
+			return nil, err
+		}
+		adminServer.TLSConfig = tlsutil.MutualConfig(certSource, clientCAs)
+	default:
+		adminServer.TLSConfig = tlsutil.ServerConfig(certSource)
+	}
+	return adminServer, nil
+}
+
+// getHealth only shows the process is serving, restart it if this fails.
+func getHealth(w http.ResponseWriter, r *http.Request) {
+	writeAdminJSON(w, http.StatusOK, statusOutput{Status: "ok"})
+}
+
+// getReadiness turns unready as soon as shutdown starts draining, so that
+// load balancers stop sending new logins while verifies still complete.
+func getReadiness(authServer *srpauth.Server) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := authServer.Ready(); err != nil {
+			writeAdminJSON(w, http.StatusServiceUnavailable, statusOutput{Status: "unready", Reason: err.Error()})
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, statusOutput{Status: "ready"})
+	}
+}
+
+func getVersion(w http.ResponseWriter, r *http.Request) {
+	out := versionOutput{Version: version}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		out.GoVersion = info.GoVersion
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				out.Revision = setting.Value
+			case "vcs.time":
+				out.Time = setting.Value
+			case "vcs.modified":
+				out.Modified = setting.Value == "true"
+			}
+		}
+	}
+	writeAdminJSON(w, http.StatusOK, out)
+}
+
+func writeAdminJSON(w http.ResponseWriter, status int, body any) {
+	out, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
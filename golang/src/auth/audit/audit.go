@@ -0,0 +1,67 @@
+// Package audit records security relevant events as an HMAC chained stream.
+//
+// Events never carry passwords, verifiers, proofs or session secrets.
+// Session ids are bearer credentials, so only SessionRef fingerprints of
+// them are recorded.
+package audit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+const (
+	HandshakeStart = "handshake.start"
+	LoginSuccess   = "login.success"
+	LoginFailure   = "login.failure"
+	LegacyLogin    = "login.legacy"
+	SessionIssue   = "session.issue"
+	SessionRevoke  = "session.revoke"
+	SessionReauth  = "session.reauth"
+	UserAdd        = "user.add"
+	UserUpdate     = "user.update"
+	UserDelete     = "user.delete"
+)
+
+type Event struct {
+	Time     time.Time `json:"time"`
+	Type     string    `json:"type"`
+	Username string    `json:"user,omitempty"`
+	Session  string    `json:"session,omitempty"` // SessionRef, never the id itself
+	Remote   string    `json:"remote,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+
+	RequestId string `json:"request,omitempty"`
+
+	Prev string `json:"prev"`
+	Hash string `json:"hash,omitempty"`
+}
+
+type Logger interface {
+	// Record appends an event. Failures are logged rather than returned so
+	// that auditing never changes the outcome of a request.
+	Record(event Event)
+	// Close writes out events that are still queued. Record must not be
+	// called afterwards.
+	Close() error
+}
+
+type discardLogger struct{}
+
+// Discard drops every event, for when no audit file is configured.
+var Discard Logger = discardLogger{}
+
+func (discardLogger) Record(Event) {}
+
+func (discardLogger) Close() error { return nil }
+
+// SessionRef is a short fingerprint of a session id, stable across issue and
+// revoke so the two can be matched up without logging the id.
+func SessionRef(session string) string {
+	if session == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(session))
+	return hex.EncodeToString(sum[:8])
+}
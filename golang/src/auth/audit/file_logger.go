@@ -0,0 +1,208 @@
+package audit
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/logging"
+	"sync"
+	"time"
+)
+
+const (
+	// Events are a few hundred bytes, a last line longer than this means the
+	// file is not an audit log.
+	maxTailRead = 64 * 1024
+	// Record blocks once this many events wait for the writer, so a stuck
+	// disk slows requests down rather than losing events.
+	queueSize = 1024
+)
+
+type fileLogger struct {
+	filePath string
+	key      []byte
+	events   chan Event
+	done     chan struct{}
+	logger   *slog.Logger
+	lock     sync.RWMutex
+	closed   bool
+
+	// Only touched by the writer goroutine
+	file     *os.File
+	lastHash string
+	size     int64 // File size after the last write, it differs if another process appended
+}
+
+// NewFileLogger appends events as JSON lines to filePath. Every line holds
+// an HMAC under key of the line without it, and the HMAC of the line before
+// it, so editing, inserting or removing lines breaks the chain from that
+// point on, and only holders of the key can rebuild it. Truncating the end
+// of the file does not, keep the last hash reported by Verify somewhere
+// else to detect that.
+//
+// Events are written by a single goroutine, in batches that are locked
+// against other processes and synced once, so the server and the admin CLI
+// can share one file. Close writes out what is still queued.
+func NewFileLogger(filePath string, key []byte) (Logger, error) {
+	if len(key) != masterkey.KeySize {
+		return nil, masterkey.ErrWrongKeySize
+	}
+	file, err := os.OpenFile(filePath, os.O_CREATE|os.O_APPEND|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	logger := &fileLogger{
+		filePath: filePath,
+		key:      key,
+		events:   make(chan Event, queueSize),
+		done:     make(chan struct{}),
+		logger:   logging.For(nil, "audit"),
+		file:     file,
+		size:     -1,
+	}
+	go logger.run()
+	return logger, nil
+}
+
+func (logger *fileLogger) Record(event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+
+	logger.lock.RLock()
+	defer logger.lock.RUnlock()
+
+	if logger.closed {
+		logger.logger.Error("Dropped event recorded after close", "type", event.Type)
+		return
+	}
+	logger.events <- event
+}
+
+func (logger *fileLogger) Close() error {
+	logger.lock.Lock()
+	if !logger.closed {
+		logger.closed = true
+		close(logger.events)
+	}
+	logger.lock.Unlock()
+
+	<-logger.done
+	return nil
+}
+
+func (logger *fileLogger) run() {
+	defer close(logger.done)
+	defer logger.file.Close()
+
+	for event := range logger.events {
+		batch := []Event{event}
+	drain:
+		for len(batch) < queueSize {
+			select {
+			case event, ok := <-logger.events:
+				if !ok {
+					break drain
+				}
+				batch = append(batch, event)
+			default:
+				break drain
+			}
+		}
+
+		if err := logger.write(batch); err != nil {
+			logger.logger.Error("Failed to record events", "count", len(batch), "err", err)
+		}
+	}
+}
+
+func (logger *fileLogger) write(batch []Event) error {
+	unlock, err := fileutil.Lock(logger.filePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	info, err := logger.file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.Size() != logger.size {
+		if logger.lastHash, err = lastHash(logger.file, info.Size()); err != nil {
+			return err
+		}
+	}
+
+	lines := []byte{}
+	prev := logger.lastHash
+	for _, event := range batch {
+		line, hash, err := chain(logger.key, event, prev)
+		if err != nil {
+			return err
+		}
+		lines = append(append(lines, line...), '\n')
+		prev = hash
+	}
+
+	if _, err = logger.file.Write(lines); err != nil {
+		logger.size = -1
+		return err
+	}
+	if err = logger.file.Sync(); err != nil {
+		return err
+	}
+	logger.lastHash = prev
+	logger.size = info.Size() + int64(len(lines))
+	return nil
+}
+
+// chain links event to the previous line and returns its JSON line and
+// hash. The HMAC covers the line as it would be written without the hash
+// field.
+func chain(key []byte, event Event, prev string) ([]byte, string, error) {
+	event.Prev = prev
+	event.Hash = ""
+	unhashed, err := json.Marshal(event)
+	if err != nil {
+		return nil, "", err
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write(unhashed)
+	event.Hash = hex.EncodeToString(mac.Sum(nil))
+	line, err := json.Marshal(event)
+	return line, event.Hash, err
+}
+
+func lastHash(file *os.File, size int64) (string, error) {
+	if size == 0 {
+		return "", nil
+	}
+
+	readSize := min(size, maxTailRead)
+	tail := make([]byte, readSize)
+	if _, err := file.ReadAt(tail, size-readSize); err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+
+	tail = bytes.TrimRight(tail, "\n")
+	start := bytes.LastIndexByte(tail, '\n')
+	if start < 0 && readSize < size {
+		return "", errors.New("last audit line is too long")
+	}
+
+	var last Event
+	if err := json.Unmarshal(tail[start+1:], &last); err != nil || last.Hash == "" {
+		return "", errors.New("last audit line is not an event, run `srp-auth audit verify`")
+	}
+	return last.Hash, nil
+}
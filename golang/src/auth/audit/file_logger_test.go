@@ -0,0 +1,171 @@
+package audit
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{7}, 32)
+
+func writeEvents(t *testing.T, filePath string, count int) {
+	t.Helper()
+	logger, err := NewFileLogger(filePath, testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range count {
+		logger.Record(Event{Type: UserAdd, Username: fmt.Sprintf("user%d", i)})
+	}
+	logger.Close()
+}
+
+func readLines(t *testing.T, filePath string) []string {
+	t.Helper()
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+}
+
+func verifyFile(t *testing.T, filePath string) (*VerifyResult, error) {
+	t.Helper()
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	return Verify(file, testKey)
+}
+
+func TestVerifyClean(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	writeEvents(t, filePath, 5)
+	// A later logger picks the chain up where the file ends
+	writeEvents(t, filePath, 3)
+
+	result, err := verifyFile(t, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := readLines(t, filePath)
+	if result.Events != 8 || len(lines) != 8 {
+		t.Fatalf("verified %d events in %d lines, want 8", result.Events, len(lines))
+	}
+	if !strings.Contains(lines[7], `"hash":"`+result.LastHash+`"`) {
+		t.Errorf("last hash %s is not that of the last line", result.LastHash)
+	}
+
+	file, _ := os.Open(filePath)
+	defer file.Close()
+	if _, err = Verify(file, bytes.Repeat([]byte{8}, 32)); err == nil || !strings.HasPrefix(err.Error(), "line 1:") {
+		t.Errorf("wrong key: got %v", err)
+	}
+}
+
+// Two loggers on one file, such as the server and the admin CLI, append
+// to a single chain.
+func TestTwoLoggersShareFile(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	const perLogger = 500
+
+	var wait sync.WaitGroup
+	for range 2 {
+		logger, err := NewFileLogger(filePath, testKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for i := range perLogger {
+				logger.Record(Event{Type: UserAdd, Username: fmt.Sprintf("user%d", i)})
+			}
+			logger.Close()
+		}()
+	}
+	wait.Wait()
+
+	result, err := verifyFile(t, filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Events != 2*perLogger {
+		t.Errorf("verified %d events, want %d", result.Events, 2*perLogger)
+	}
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	tests := map[string]struct {
+		tamper func(lines []string) []string
+		want   string
+		events int
+	}{
+		"edited line": {func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], "user2", "mallory", 1)
+			return lines
+		}, "line 3: contents do not match its hash", 2},
+		"edited whitespace": {func(lines []string) []string {
+			lines[2] = strings.Replace(lines[2], `","`, `", "`, 1)
+			return lines
+		}, "line 3: contents do not match its hash", 2},
+		"removed line": {func(lines []string) []string {
+			return append(lines[:2:2], lines[3:]...)
+		}, "line 3: chain broken", 2},
+		"inserted line": {func(lines []string) []string {
+			return append(lines[:2:2], append([]string{lines[1]}, lines[2:]...)...)
+		}, "line 3: chain broken", 2},
+		"corrupt last line": {func(lines []string) []string {
+			lines[4] = lines[4][:len(lines[4])/2]
+			return lines
+		}, "line 5: not an audit event", 4},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "audit.log")
+			writeEvents(t, filePath, 5)
+			lines := test.tamper(readLines(t, filePath))
+			if err := os.WriteFile(filePath, []byte(strings.Join(lines, "")), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := verifyFile(t, filePath)
+			if err == nil || !strings.HasPrefix(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+			if result.Events != test.events {
+				t.Errorf("verified %d events before the break, want %d", result.Events, test.events)
+			}
+		})
+	}
+}
+
+// A logger cannot continue a chain whose last line it cannot read. The
+// events are not written, rather than starting a chain Verify would reject
+// at the corrupt line anyway.
+func TestCorruptLastLineStopsAppends(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "audit.log")
+	writeEvents(t, filePath, 2)
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"time":"2026-01-01T00:00:00Z","type":"us`)
+	file.Close()
+
+	before, _ := os.ReadFile(filePath)
+	writeEvents(t, filePath, 1)
+	after, _ := os.ReadFile(filePath)
+	if !bytes.Equal(before, after) {
+		t.Errorf("appended after a corrupt last line:\n%s", after[len(before):])
+	}
+
+	if result, err := verifyFile(t, filePath); err == nil || !strings.HasPrefix(err.Error(), "line 3: not an audit event") || result.Events != 2 {
+		t.Errorf("got %d events, %v", result.Events, err)
+	}
+}
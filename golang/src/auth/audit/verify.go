@@ -0,0 +1,52 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+type VerifyResult struct {
+	Events   int
+	LastHash string
+}
+
+// Verify walks the chain written by a file logger under key and reports
+// the first line that does not follow from the one before it.
+func Verify(r io.Reader, key []byte) (*VerifyResult, error) {
+	result := &VerifyResult{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxTailRead)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := scanner.Bytes()
+
+		var event Event
+		if err := json.Unmarshal(line, &event); err != nil {
+			return result, fmt.Errorf("line %d: not an audit event", lineNo)
+		}
+		if event.Prev != result.LastHash {
+			return result, fmt.Errorf("line %d: chain broken, a line before it was changed or removed", lineNo)
+		}
+
+		// Rebuilding the line byte for byte also catches edits to fields
+		// the hash would not see, such as added keys or whitespace.
+		expected, _, err := chain(key, event, event.Prev)
+		if err != nil {
+			return result, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if !bytes.Equal(expected, line) {
+			return result, fmt.Errorf("line %d: contents do not match its hash", lineNo)
+		}
+
+		result.Events++
+		result.LastHash = event.Hash
+	}
+	if err := scanner.Err(); err != nil {
+		return result, fmt.Errorf("line %d: %w", lineNo+1, err)
+	}
+	return result, nil
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/json"
 	"errors"
 	"os"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"time"
 )
 
 const (
@@ -13,47 +15,128 @@ const (
 type CredentialSerializer interface {
 	Load() (UserCredList, error)
 	Save(UserCredList) error
+	Lock() (func(), error)
+	ModTime() (time.Time, error)
 }
 
 type credentialSerializer struct {
 	filePath string
+
+	// Only set for encrypted files
+	masterKey    []byte
+	previousKeys [][]byte
+	envelope     *dataKey
 }
 
+// GetCredentialSerializer reads and writes plain credential files.
 func GetCredentialSerializer(filePath string) CredentialSerializer {
 	return &credentialSerializer{
 		filePath: filePath,
 	}
 }
 
+// GetEncryptedCredentialSerializer seals every record under a data key that
+// is itself wrapped by masterKey, see credential_envelope.go. Plain files are
+// still read, and encrypted on the next save. A file still wrapped under one
+// of previousKeys is read and saved under that key, so servers keep working
+// while the file is rewrapped onto masterKey.
+func GetEncryptedCredentialSerializer(filePath string, masterKey []byte, previousKeys ...[]byte) CredentialSerializer {
+	return &credentialSerializer{
+		filePath:     filePath,
+		masterKey:    masterKey,
+		previousKeys: previousKeys,
+	}
+}
+
 func (db *credentialSerializer) Load() (UserCredList, error) {
-	dat, err := os.ReadFile(db.filePath)
+	dbContainer, err := readCredentialFile(db.filePath)
 	if err != nil {
 		return nil, err
 	}
 
-	var dbContainer UserCredDB
-	err = json.Unmarshal(dat, &dbContainer)
+	if dbContainer.Envelope == nil {
+		if dbContainer.Users == nil {
+			return make(UserCredList), nil
+		}
+		return dbContainer.Users, nil
+	}
+
+	if db.masterKey == nil {
+		return nil, ErrCredentialsEncrypted
+	}
+	envelope, err := unwrapDataKey(dbContainer.Envelope, append([][]byte{db.masterKey}, db.previousKeys...)...)
 	if err != nil {
 		return nil, err
 	}
-
-	if dbContainer.Version != credDataVersion {
-		return nil, errors.New("[Credentials] Credential file has the wrong version")
+	users, err := envelope.openUsers(dbContainer.SealedUsers)
+	if err != nil {
+		return nil, err
 	}
 
-	return dbContainer.Users, nil
+	db.envelope = envelope
+	return users, nil
 }
 
 func (db *credentialSerializer) Save(users UserCredList) error {
 	dbData := &UserCredDB{
 		Version: credDataVersion,
-		Users:   users,
 	}
+
+	if db.masterKey == nil {
+		dbData.Users = users
+	} else {
+		if db.envelope == nil {
+			envelope, err := newDataKey(db.masterKey)
+			if err != nil {
+				return err
+			}
+			db.envelope = envelope
+		}
+
+		sealed, err := db.envelope.sealUsers(users)
+		if err != nil {
+			return err
+		}
+		dbData.Envelope = db.envelope.wrapped
+		dbData.SealedUsers = sealed
+	}
+
+	return writeCredentialFile(db.filePath, dbData)
+}
+
+func (db *credentialSerializer) Lock() (func(), error) {
+	return fileutil.Lock(db.filePath + ".lock")
+}
+
+// ModTime returns the zero time if the file does not exist yet.
+func (db *credentialSerializer) ModTime() (time.Time, error) {
+	return fileutil.ModTime(db.filePath)
+}
+
+func readCredentialFile(filePath string) (*UserCredDB, error) {
+	dat, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var dbContainer UserCredDB
+	err = json.Unmarshal(dat, &dbContainer)
+	if err != nil {
+		return nil, err
+	}
+
+	if dbContainer.Version != credDataVersion {
+		return nil, errors.New("credential file has the wrong version")
+	}
+
+	return &dbContainer, nil
+}
+
+func writeCredentialFile(filePath string, dbData *UserCredDB) error {
 	dat, err := json.Marshal(dbData)
 	if err != nil {
 		return err
 	}
 
-	err = os.WriteFile(db.filePath, dat, 0644)
-	return err
+	return fileutil.WriteAtomic(filePath, dat, 0600)
 }
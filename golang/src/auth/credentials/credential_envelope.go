@@ -0,0 +1,187 @@
+package credentials
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"strings"
+)
+
+const (
+	envelopeAlgorithm  = "AES-256-GCM"
+	dataKeyWrapAAD     = "srp-credentials:data-key"
+	recordAADPrefix    = "srp-credentials:user:"
+	masterKeyIdByteLen = 8
+)
+
+var ErrCredentialsEncrypted = errors.New("credential file is encrypted, configure credentialsMasterKey or credentialsMasterKeyFile")
+
+// CredEnvelope is stored in the clear next to the sealed records. The data
+// key is wrapped under the master key, so rotating the master key only
+// rewrites this header.
+type CredEnvelope struct {
+	Algorithm  string `json:"alg"`
+	KeyId      string `json:"kid"` // Fingerprint of the master key, to tell a wrong key from a corrupt file
+	WrappedKey []byte `json:"wrappedKey"`
+}
+
+type dataKey struct {
+	key     []byte
+	wrapped *CredEnvelope
+}
+
+func newDataKey(masterKey []byte) (*dataKey, error) {
+	key := make([]byte, masterkey.KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return wrapDataKey(key, masterKey)
+}
+
+func wrapDataKey(key []byte, masterKey []byte) (*dataKey, error) {
+	wrapped, err := masterkey.Seal(masterKey, key, []byte(dataKeyWrapAAD))
+	if err != nil {
+		return nil, err
+	}
+
+	return &dataKey{
+		key: key,
+		wrapped: &CredEnvelope{
+			Algorithm:  envelopeAlgorithm,
+			KeyId:      MasterKeyId(masterKey),
+			WrappedKey: wrapped,
+		},
+	}, nil
+}
+
+// unwrapDataKey opens the data key with whichever of masterKeys it is
+// wrapped under, so a file rewrapped under a new key and one still under the
+// previous key can both be read while the key is rotated.
+func unwrapDataKey(envelope *CredEnvelope, masterKeys ...[]byte) (*dataKey, error) {
+	if envelope.Algorithm != envelopeAlgorithm {
+		return nil, fmt.Errorf("unsupported credential encryption %q", envelope.Algorithm)
+	}
+	var masterKey []byte
+	kids := make([]string, 0, len(masterKeys))
+	for _, candidate := range masterKeys {
+		kid := MasterKeyId(candidate)
+		if kid == envelope.KeyId {
+			masterKey = candidate
+			break
+		}
+		kids = append(kids, kid)
+	}
+	if masterKey == nil {
+		return nil, fmt.Errorf("credential file is wrapped under master key %s, the configured keys are %s", envelope.KeyId, strings.Join(kids, ", "))
+	}
+
+	key, err := masterkey.Open(masterKey, envelope.WrappedKey, []byte(dataKeyWrapAAD))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap the credential data key: %w", err)
+	}
+
+	return &dataKey{
+		key:     key,
+		wrapped: envelope,
+	}, nil
+}
+
+// Records are bound to their username so they cannot be swapped around.
+func (dk *dataKey) sealUsers(users UserCredList) (map[string][]byte, error) {
+	sealed := make(map[string][]byte, len(users))
+	for username, creds := range users {
+		plaintext, err := json.Marshal(creds)
+		if err != nil {
+			return nil, err
+		}
+		if sealed[username], err = masterkey.Seal(dk.key, plaintext, []byte(recordAADPrefix+username)); err != nil {
+			return nil, err
+		}
+	}
+	return sealed, nil
+}
+
+func (dk *dataKey) openUsers(sealed map[string][]byte) (UserCredList, error) {
+	users := make(UserCredList, len(sealed))
+	for username, record := range sealed {
+		plaintext, err := masterkey.Open(dk.key, record, []byte(recordAADPrefix+username))
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt credentials of %q: %w", username, err)
+		}
+
+		var creds UserCreds
+		if err = json.Unmarshal(plaintext, &creds); err != nil {
+			return nil, err
+		}
+		users[username] = &creds
+	}
+	return users, nil
+}
+
+// MasterKeyId is a short fingerprint of a master key, safe to store and log.
+func MasterKeyId(masterKey []byte) string {
+	sum := sha256.Sum256(append([]byte(dataKeyWrapAAD+":kid:"), masterKey...))
+	return hex.EncodeToString(sum[:masterKeyIdByteLen])
+}
+
+// EncryptCredentialFile converts a plain credential file to an encrypted
+// one, or re-seals an encrypted one under a fresh data key.
+func EncryptCredentialFile(filePath string, masterKey []byte) error {
+	return convertCredentialFile(filePath, GetEncryptedCredentialSerializer(filePath, masterKey), GetEncryptedCredentialSerializer(filePath, masterKey))
+}
+
+// DecryptCredentialFile writes the records of an encrypted credential file
+// back in the clear.
+func DecryptCredentialFile(filePath string, masterKey []byte) error {
+	return convertCredentialFile(filePath, GetEncryptedCredentialSerializer(filePath, masterKey), GetCredentialSerializer(filePath))
+}
+
+func convertCredentialFile(filePath string, from CredentialSerializer, to CredentialSerializer) error {
+	unlock, err := from.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	users, err := from.Load()
+	if err != nil {
+		return err
+	}
+	return to.Save(users)
+}
+
+// RewrapCredentialFile re-wraps the data key of an encrypted credential
+// file, wrapped under any of oldMasterKeys, under newMasterKey. The sealed
+// records are left untouched.
+func RewrapCredentialFile(filePath string, newMasterKey []byte, oldMasterKeys ...[]byte) error {
+	unlock, err := fileutil.Lock(filePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	dbContainer, err := readCredentialFile(filePath)
+	if err != nil {
+		return err
+	}
+	if dbContainer.Envelope == nil {
+		return errors.New("credential file is not encrypted")
+	}
+
+	envelope, err := unwrapDataKey(dbContainer.Envelope, append(oldMasterKeys, newMasterKey)...)
+	if err != nil {
+		return err
+	}
+	rewrapped, err := wrapDataKey(envelope.key, newMasterKey)
+	if err != nil {
+		return err
+	}
+
+	dbContainer.Envelope = rewrapped.wrapped
+	return writeCredentialFile(filePath, dbContainer)
+}
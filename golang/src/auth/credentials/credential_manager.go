@@ -1,108 +1,605 @@
 package credentials
 
 import (
+	"crypto/subtle"
 	"errors"
-	"log"
+	"fmt"
+	"log/slog"
+	"os"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/auth/fileutil"
 	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/logging"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Other processes, such as the admin CLI, may change the credential file
+// while the server runs. Reads check for that at most this often.
+const reloadCheckInterval = time.Second
+
+var (
+	ErrUserExists   = errors.New("user already exists")
+	ErrUserNotFound = errors.New("user does not exist")
 )
 
 type CredentialManager interface {
-	Init()
-	Save()
+	Init() error
+	// Ready fails until the credential file has been loaded once.
+	Ready() error
+	Save() error
+	Close() error
 
 	AddUser(username string, password string) error
 	UpdateUser(username string, password string) error
 	DeleteUser(username string) error
+	SetDisabled(username string, disabled bool) error
 
-	GetUserInfo(username string) ([]byte, []byte, error)
+	GetUserInfo(username string) (*UserCreds, error)
+	// DefaultRecord returns a record with the group, hash and pepper key a
+	// user added now would get, and a zero salt and verifier of the right
+	// lengths. Decoy handshakes are made from it.
+	DefaultRecord() *UserCreds
+	// Verifier returns the record's verifier with any pepper removed. Use
+	// it instead of creds.Verifier and do not keep the result around.
+	Verifier(username string, creds *UserCreds) ([]byte, error)
+	// Repepper moves every record onto the primary pepper key, returning
+	// how many changed.
+	Repepper() (int, error)
+
+	// ImportUsers adds records carrying plain verifiers, peppering them as
+	// configured. Existing users are kept unless replace is set, and nothing
+	// is written if any name fails ValidateUsername. It returns how many
+	// records were written.
+	ImportUsers(users UserCredList, replace bool) (int, error)
+	// ExportUsers returns a copy of every record with the pepper removed.
+	ExportUsers() (UserCredList, error)
+
+	// UpgradeLegacyUser checks password against the user's legacy hash and,
+	// if it matches, replaces the hash with an SRP salt and verifier.
+	// Unknown, disabled and already upgraded users are rejected with false
+	// and no error, after checking a decoy hash so they take as long as a
+	// wrong password.
+	UpgradeLegacyUser(username string, password string) (bool, error)
+	LegacyUsers() []string
+	ListUsers() []string
+	VerifyPassword(username string, password string, groups []*srp.ConstantGroup) (bool, error)
 }
 
 type credentialManager struct {
 	isInit bool
 	users  UserCredList
 	engine srp.SRPEngine
+	pepper Pepper
+	audit  audit.Logger
+	logger *slog.Logger
 
 	serializer CredentialSerializer
+	reloader   *fileutil.Reloader
+	lock       sync.Mutex
 }
 
-var instance *credentialManager = nil
+type CredentialOptions struct {
+	Pepper Pepper       // New verifiers are peppered if set
+	Audit  audit.Logger // Changes to users are recorded if set
+	Logger *slog.Logger // Defaults to slog.Default()
+}
 
-func GetCredentialManager(credentialsPath string, engine srp.SRPEngine) CredentialManager {
-	if instance == nil {
-		instance = &credentialManager{
-			engine:     engine,
-			isInit:     false,
-			users:      make(UserCredList),
-			serializer: GetCredentialSerializer(credentialsPath),
-		}
+// NewCredentialManager loads the credentials through serializer. A missing
+// file is not an error, it is created by the first mutation.
+func NewCredentialManager(serializer CredentialSerializer, engine srp.SRPEngine, opts CredentialOptions) (CredentialManager, error) {
+	auditLog := opts.Audit
+	if auditLog == nil {
+		auditLog = audit.Discard
+	}
+	mgr := &credentialManager{
+		engine:     engine,
+		pepper:     opts.Pepper,
+		audit:      auditLog,
+		logger:     logging.For(opts.Logger, "credentials"),
+		isInit:     false,
+		users:      make(UserCredList),
+		serializer: serializer,
+	}
+	mgr.reloader = fileutil.NewReloaderFunc(serializer.ModTime, reloadCheckInterval, mgr.reload)
+	if err := mgr.Init(); err != nil {
+		return nil, err
 	}
-	return instance
+	return mgr, nil
 }
 
-func (mgr *credentialManager) Init() {
-	log.Println("[Credentials] Loading DB from disk")
-	data, err := mgr.serializer.Load()
+func (mgr *credentialManager) Init() error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	return mgr.reloader.Load()
+}
+
+func (mgr *credentialManager) Ready() error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	if !mgr.isInit {
+		return errors.New("credentials not loaded")
+	}
+	return nil
+}
+
+// Save writes the in-memory state out as is. Mutations already persist
+// themselves, this is only needed to force a rewrite. If another process
+// changed the file since it was last read, the file wins and is reloaded
+// instead, so a stale copy never overwrites it.
+func (mgr *credentialManager) Save() error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	unlock, err := mgr.serializer.Lock()
 	if err != nil {
-		log.Printf("[Credentials] Failed to load DB from disk, err = %s\n", err)
-		return
+		return err
+	}
+	defer unlock()
+
+	if changed, err := mgr.reloader.Changed(); err == nil && changed {
+		return mgr.reloader.Load()
+	}
+	return mgr.save(mgr.users)
+}
+
+// Close flushes the in-memory state one last time.
+func (mgr *credentialManager) Close() error {
+	return mgr.Save()
+}
+
+func (mgr *credentialManager) reload() error {
+	mgr.logger.Debug("Loading credentials")
+	data, err := mgr.load()
+	if err != nil {
+		mgr.logger.Error("Failed to load credentials", "err", err)
+		return err
 	}
 
 	mgr.isInit = true
 	mgr.users = data
+	return nil
 }
 
-func (mgr *credentialManager) Save() {
-	log.Println("[Credentials] Saving DB to disk")
-	err := mgr.serializer.Save(mgr.users)
+func (mgr *credentialManager) load() (UserCredList, error) {
+	data, err := mgr.serializer.Load()
+	if errors.Is(err, os.ErrNotExist) {
+		return make(UserCredList), nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if data == nil {
+		data = make(UserCredList)
+	}
+	return data, nil
+}
+
+func (mgr *credentialManager) save(users UserCredList) error {
+	mgr.logger.Debug("Saving credentials")
+	if err := mgr.serializer.Save(users); err != nil {
+		mgr.logger.Error("Failed to save credentials", "err", err)
+		return fmt.Errorf("failed to persist credentials: %w", err)
+	}
+
+	mgr.reloader.MarkCurrent()
+	return nil
+}
+
+// mutate applies a change to a fresh copy of the file under the file lock,
+// so changes made by other processes are kept. The in-memory state is only
+// replaced once the change has been persisted.
+func (mgr *credentialManager) mutate(apply func(users UserCredList) error) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	unlock, err := mgr.serializer.Lock()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	users, err := mgr.load()
 	if err != nil {
-		log.Printf("[Credentials] Failed to save DB to disk, err = %s\n", err)
+		return err
+	}
+
+	if err = apply(users); err != nil {
+		return err
+	}
+
+	if err = mgr.save(users); err != nil {
+		return err
+	}
+
+	mgr.users = users
+	return nil
+}
+
+func (mgr *credentialManager) refreshIfChanged() {
+	// A failed reload keeps serving the last good copy.
+	if err := mgr.reloader.RefreshIfChanged(); err != nil {
+		mgr.logger.Error("Failed to reload credentials, serving the last good copy", "err", err)
 	}
 }
 
 func (mgr *credentialManager) AddUser(username string, password string) error {
-	_, found := mgr.users[username]
-	if found {
-		return errors.New("user already exists")
+	if err := ValidateUsername(username); err != nil {
+		return err
+	}
+	if err := ValidatePassword(password); err != nil {
+		return err
 	}
 
-	mgr.addUserToList(username, password)
-	return nil
+	err := mgr.mutate(func(users UserCredList) error {
+		_, found := users[username]
+		if found {
+			return ErrUserExists
+		}
+
+		return mgr.addUserToList(users, username, password)
+	})
+	if err == nil {
+		mgr.record(audit.UserAdd, username, "")
+	}
+	return err
 }
 
 func (mgr *credentialManager) UpdateUser(username string, password string) error {
-	_, found := mgr.users[username]
-	if !found {
-		return errors.New("user does not exist")
+	if err := ValidatePassword(password); err != nil {
+		return err
 	}
 
-	mgr.addUserToList(username, password)
-	return nil
+	err := mgr.mutate(func(users UserCredList) error {
+		_, found := users[username]
+		if !found {
+			return ErrUserNotFound
+		}
+
+		return mgr.addUserToList(users, username, password)
+	})
+	if err == nil {
+		mgr.record(audit.UserUpdate, username, "password")
+	}
+	return err
+}
+
+func (mgr *credentialManager) record(eventType string, username string, reason string) {
+	mgr.audit.Record(audit.Event{
+		Type:     eventType,
+		Username: username,
+		Reason:   reason,
+	})
 }
 
-func (mgr *credentialManager) addUserToList(username string, password string) {
+func (mgr *credentialManager) addUserToList(users UserCredList, username string, password string) error {
 	salt := mgr.engine.RandomSalt()
+	now := time.Now().UTC()
 
-	mgr.users[username] = &UserCreds{
-		Salt:     salt,
-		Verifier: mgr.engine.GetVerifier(salt, username, password),
+	verifier, pepperId, err := mgr.applyPepper(username, salt, mgr.engine.GetVerifier(salt, username, password))
+	if err != nil {
+		return err
+	}
+
+	newCreds := &UserCreds{
+		Salt:      salt,
+		Verifier:  verifier,
+		PepperId:  pepperId,
+		Group:     mgr.engine.Group().Name,
+		Hash:      mgr.engine.HashType().String(),
+		CreatedAt: now,
+		UpdatedAt: now,
 	}
+	if oldCreds, found := users[username]; found {
+		newCreds.Disabled = oldCreds.Disabled
+		newCreds.CreatedAt = oldCreds.CreatedAt
+	}
+
+	users[username] = newCreds
+	return nil
+}
+
+func (mgr *credentialManager) applyPepper(username string, salt []byte, verifier []byte) ([]byte, string, error) {
+	if mgr.pepper == nil {
+		return verifier, "", nil
+	}
+	return mgr.pepper.Apply(username, salt, verifier)
+}
+
+func (mgr *credentialManager) Verifier(username string, creds *UserCreds) ([]byte, error) {
+	if creds.PepperId == "" {
+		return creds.Verifier, nil
+	}
+	if mgr.pepper == nil {
+		return nil, errors.New("verifier is peppered but no pepper is configured")
+	}
+	return mgr.pepper.Reverse(username, creds.Salt, creds.Verifier, creds.PepperId)
+}
+
+func (mgr *credentialManager) Repepper() (int, error) {
+	if mgr.pepper == nil {
+		return 0, errors.New("no pepper is configured")
+	}
+	primaryId, err := mgr.pepper.PrimaryId()
+	if err != nil {
+		return 0, err
+	}
+
+	changed := []string{}
+	err = mgr.mutate(func(users UserCredList) error {
+		changed = changed[:0]
+		for username, creds := range users {
+			if creds.PepperId == primaryId || creds.IsLegacy() {
+				continue
+			}
+
+			verifier, err := mgr.Verifier(username, creds)
+			if err != nil {
+				return fmt.Errorf("user %q: %w", username, err)
+			}
+			if creds.Verifier, creds.PepperId, err = mgr.pepper.Apply(username, creds.Salt, verifier); err != nil {
+				return err
+			}
+			changed = append(changed, username)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, username := range changed {
+		mgr.record(audit.UserUpdate, username, "repepper")
+	}
+	return len(changed), nil
+}
+
+func (mgr *credentialManager) ImportUsers(imported UserCredList, replace bool) (int, error) {
+	type importedUser struct {
+		username string
+		replaced bool
+	}
+	for username := range imported {
+		if err := ValidateUsername(username); err != nil {
+			return 0, fmt.Errorf("user %q: %w", username, err)
+		}
+	}
+
+	written := []importedUser{}
+	err := mgr.mutate(func(users UserCredList) error {
+		written = written[:0]
+		for username, creds := range imported {
+			_, found := users[username]
+			if found && !replace {
+				continue
+			}
+
+			record := *creds
+			if !record.IsLegacy() {
+				verifier, pepperId, err := mgr.applyPepper(username, record.Salt, record.Verifier)
+				if err != nil {
+					return err
+				}
+				record.Verifier, record.PepperId = verifier, pepperId
+			}
+			users[username] = &record
+			written = append(written, importedUser{username, found})
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	for _, user := range written {
+		if user.replaced {
+			mgr.record(audit.UserUpdate, user.username, "import")
+		} else {
+			mgr.record(audit.UserAdd, user.username, "import")
+		}
+	}
+	return len(written), nil
+}
+
+func (mgr *credentialManager) ExportUsers() (UserCredList, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.refreshIfChanged()
+	exported := make(UserCredList, len(mgr.users))
+	for username, creds := range mgr.users {
+		if creds.IsLegacy() {
+			continue
+		}
+
+		verifier, err := mgr.Verifier(username, creds)
+		if err != nil {
+			return nil, fmt.Errorf("user %q: %w", username, err)
+		}
+
+		record := *creds
+		record.Verifier, record.PepperId = verifier, ""
+		exported[username] = &record
+	}
+	return exported, nil
+}
+
+func (mgr *credentialManager) UpgradeLegacyUser(username string, password string) (bool, error) {
+	userInfo, err := mgr.GetUserInfo(username)
+	if err != nil && !errors.Is(err, ErrUserNotFound) {
+		return false, err
+	}
+	if err != nil || userInfo.Disabled || !userInfo.IsLegacy() {
+		verifyLegacyHash(mgr.decoyLegacyHash(), password)
+		return false, nil
+	}
+
+	// Hashing is slow by design, so it is done before taking the file lock.
+	valid, err := verifyLegacyHash(userInfo.LegacyHash, password)
+	if err != nil || !valid {
+		return false, err
+	}
+
+	err = mgr.mutate(func(users UserCredList) error {
+		current, found := users[username]
+		if !found {
+			return ErrUserNotFound
+		}
+		if current.LegacyHash != userInfo.LegacyHash {
+			return ErrNotLegacy
+		}
+
+		return mgr.addUserToList(users, username, password)
+	})
+	if errors.Is(err, ErrUserNotFound) || errors.Is(err, ErrNotLegacy) {
+		// Deleted or upgraded while the hash was checked
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	mgr.logger.Info("Upgraded legacy password hash to SRP verifier", "user", username)
+	mgr.record(audit.UserUpdate, username, "legacy upgrade")
+	return true, nil
+}
+
+// decoyLegacyHash returns the hash of some legacy user, so the decoy check
+// costs as much as a real one, or a fixed bcrypt hash once none are left.
+func (mgr *credentialManager) decoyLegacyHash() string {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	for _, creds := range mgr.users {
+		if creds.IsLegacy() {
+			return creds.LegacyHash
+		}
+	}
+	return decoyBcryptHash
+}
+
+func (mgr *credentialManager) LegacyUsers() []string {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.refreshIfChanged()
+	usernames := []string{}
+	for username, creds := range mgr.users {
+		if creds.IsLegacy() {
+			usernames = append(usernames, username)
+		}
+	}
+
+	sort.Strings(usernames)
+	return usernames
 }
 
 func (mgr *credentialManager) DeleteUser(username string) error {
-	_, found := mgr.users[username]
-	if !found {
-		return errors.New("user does not exist")
+	err := mgr.mutate(func(users UserCredList) error {
+		_, found := users[username]
+		if !found {
+			return ErrUserNotFound
+		}
+
+		delete(users, username)
+		return nil
+	})
+	if err == nil {
+		mgr.record(audit.UserDelete, username, "")
 	}
+	return err
+}
 
-	delete(mgr.users, username)
-	return nil
+func (mgr *credentialManager) SetDisabled(username string, disabled bool) error {
+	err := mgr.mutate(func(users UserCredList) error {
+		userInfo, found := users[username]
+		if !found {
+			return ErrUserNotFound
+		}
+
+		userInfo.Disabled = disabled
+		userInfo.UpdatedAt = time.Now().UTC()
+		return nil
+	})
+	if err == nil && disabled {
+		mgr.record(audit.UserUpdate, username, "disabled")
+	} else if err == nil {
+		mgr.record(audit.UserUpdate, username, "enabled")
+	}
+	return err
 }
 
-func (mgr *credentialManager) GetUserInfo(username string) ([]byte, []byte, error) {
+func (mgr *credentialManager) GetUserInfo(username string) (*UserCreds, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.refreshIfChanged()
 	userInfo, found := mgr.users[username]
 	if !found {
-		return nil, nil, errors.New("user does not exist")
+		return nil, ErrUserNotFound
+	}
+
+	return userInfo, nil
+}
+
+func (mgr *credentialManager) DefaultRecord() *UserCreds {
+	creds := &UserCreds{
+		Salt:     make([]byte, mgr.engine.Group().NByteLen()),
+		Verifier: make([]byte, mgr.engine.Group().NByteLen()),
+		Group:    mgr.engine.Group().Name,
+		Hash:     mgr.engine.HashType().String(),
+	}
+	if mgr.pepper != nil {
+		creds.PepperId, _ = mgr.pepper.PrimaryId()
+	}
+	return creds
+}
+
+func (mgr *credentialManager) ListUsers() []string {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	mgr.refreshIfChanged()
+	usernames := make([]string, 0, len(mgr.users))
+	for username := range mgr.users {
+		usernames = append(usernames, username)
+	}
+
+	sort.Strings(usernames)
+	return usernames
+}
+
+// VerifyPassword recomputes the verifier under the group and hash the user
+// was stored with, which need not match the manager's engine.
+func (mgr *credentialManager) VerifyPassword(username string, password string, groups []*srp.ConstantGroup) (bool, error) {
+	userInfo, err := mgr.GetUserInfo(username)
+	if err != nil {
+		return false, err
+	}
+	if userInfo.IsLegacy() {
+		return verifyLegacyHash(userInfo.LegacyHash, password)
 	}
 
-	return userInfo.Salt, userInfo.Verifier, nil
+	group, hashType, err := userInfo.Params(groups)
+	if err != nil {
+		return false, err
+	}
+	engine, err := srp.NewSRPEngine(group, hashType)
+	if err != nil {
+		return false, err
+	}
+
+	expected, err := mgr.Verifier(username, userInfo)
+	if err != nil {
+		return false, err
+	}
+
+	verifier, err := engine.GetVerifierKDF(userInfo.KDF, userInfo.Salt, username, password)
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(verifier, expected) == 1, nil
 }
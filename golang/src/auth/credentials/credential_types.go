@@ -1,13 +1,75 @@
 package credentials
 
+import (
+	"fmt"
+	"sharpstorm/srp-auth/auth/srp"
+	"time"
+)
+
+// Credentials stored before the group and hash were recorded per user were
+// always created with these parameters.
+var legacyGroup = &srp.GROUP_3072
+var legacyHash = srp.SHA512
+
 type UserCredDB struct {
 	Version int          `json:"version"`
-	Users   UserCredList `json:"users"`
+	Users   UserCredList `json:"users,omitempty"`
+
+	// Only set for encrypted files, records are then sealed per username
+	Envelope    *CredEnvelope     `json:"envelope,omitempty"`
+	SealedUsers map[string][]byte `json:"sealedUsers,omitempty"`
 }
 
 type UserCredList map[string]*UserCreds
 
 type UserCreds struct {
-	Salt     []byte `json:"salt"`
-	Verifier []byte `json:"verifier"`
+	Salt      []byte    `json:"salt"`
+	Verifier  []byte    `json:"verifier"`
+	PepperId  string    `json:"pepperId,omitempty"` // Key the verifier is peppered under, empty if stored as is
+	KDF       string    `json:"kdf,omitempty"`      // How x is derived, see srp.KDFRFC5054 for imported records
+	Group     string    `json:"group,omitempty"`    // Name of the srp.ConstantGroup, empty for records predating configurable groups
+	Hash      string    `json:"hash,omitempty"`     // Name of the srp.HashType, empty for records predating hash agility
+	Disabled  bool      `json:"disabled,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+
+	// Set instead of Salt and Verifier for users imported from a password
+	// hash, see CredentialManager.UpgradeLegacyUser
+	LegacyHash string `json:"legacyHash,omitempty"`
+}
+
+func (creds *UserCreds) IsLegacy() bool {
+	return creds.LegacyHash != ""
+}
+
+// Params resolves the group and hash the record's verifier was created
+// under. groups lists every group the server knows about.
+func (creds *UserCreds) Params(groups []*srp.ConstantGroup) (*srp.ConstantGroup, srp.HashType, error) {
+	group := legacyGroup
+	if creds.Group != "" {
+		group = nil
+		for _, candidate := range groups {
+			if candidate.Name == creds.Group {
+				group = candidate
+				break
+			}
+		}
+		if group == nil {
+			return nil, 0, fmt.Errorf("unknown group %q", creds.Group)
+		}
+	}
+
+	if err := srp.ValidateKDF(creds.KDF); err != nil {
+		return nil, 0, err
+	}
+
+	hashType := legacyHash
+	if creds.Hash != "" {
+		var err error
+		if hashType, err = srp.HashTypeByName(creds.Hash); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	return group, hashType, nil
 }
@@ -0,0 +1,156 @@
+package credentials
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	ErrNotLegacy             = errors.New("user has no legacy password hash")
+	ErrUnsupportedLegacyHash = errors.New("unsupported legacy password hash")
+)
+
+// decoyBcryptHash is checked in place of a missing legacy hash. Its cost is
+// bcrypt's default, the password it was made from is unknown.
+const decoyBcryptHash = "$2a$10$ZaqiHeVk413AP.ZTBytqueow3fnkfLRyGoMWcPVcJ2xLn48LoBsUC"
+
+// passlib's adapted base64, "." instead of "+" and no padding.
+var passlibBase64 = base64.NewEncoding("ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789./").WithPadding(base64.NoPadding)
+
+type pbkdf2Hash struct {
+	hash       func() hash.Hash
+	iterations int
+	salt       []byte
+	key        []byte
+}
+
+// ValidateLegacyHash checks that an imported hash is in a supported format:
+// bcrypt ("$2a$", "$2b$", "$2y$"), passlib PBKDF2 ("$pbkdf2$",
+// "$pbkdf2-sha256$", "$pbkdf2-sha512$") or Django ("pbkdf2_sha256$").
+func ValidateLegacyHash(encoded string) error {
+	if isBcrypt(encoded) {
+		_, err := bcrypt.Cost([]byte(encoded))
+		return err
+	}
+	_, err := parsePBKDF2(encoded)
+	return err
+}
+
+// ReadLegacyHashes parses "username:hash" lines into legacy records, to be
+// upgraded on the user's next legacy login.
+func ReadLegacyHashes(r io.Reader) (UserCredList, error) {
+	now := time.Now().UTC()
+	users := make(UserCredList)
+	err := scanColonFile(r, 2, func(lineNo int, fields []string) error {
+		if err := ValidateUsername(fields[0]); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if err := ValidateLegacyHash(fields[1]); err != nil {
+			return fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		users[fields[0]] = &UserCreds{
+			LegacyHash: fields[1],
+			CreatedAt:  now,
+			UpdatedAt:  now,
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func verifyLegacyHash(encoded string, password string) (bool, error) {
+	if isBcrypt(encoded) {
+		err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	parsed, err := parsePBKDF2(encoded)
+	if err != nil {
+		return false, err
+	}
+	key, err := pbkdf2.Key(parsed.hash, password, parsed.salt, parsed.iterations, len(parsed.key))
+	if err != nil {
+		return false, err
+	}
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1, nil
+}
+
+func isBcrypt(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func parsePBKDF2(encoded string) (*pbkdf2Hash, error) {
+	if rest, found := strings.CutPrefix(encoded, "pbkdf2_sha256$"); found {
+		// Django: iterations$salt$base64(key), the salt is used as is
+		fields := strings.Split(rest, "$")
+		if len(fields) != 3 {
+			return nil, ErrUnsupportedLegacyHash
+		}
+		key, err := base64.StdEncoding.DecodeString(fields[2])
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrUnsupportedLegacyHash, err)
+		}
+		return newPBKDF2Hash(sha256.New, fields[0], []byte(fields[1]), key)
+	}
+
+	fields := strings.Split(encoded, "$")
+	if len(fields) != 5 || fields[0] != "" {
+		return nil, ErrUnsupportedLegacyHash
+	}
+
+	var hashFunc func() hash.Hash
+	switch fields[1] {
+	case "pbkdf2":
+		hashFunc = sha1.New
+	case "pbkdf2-sha256":
+		hashFunc = sha256.New
+	case "pbkdf2-sha512":
+		hashFunc = sha512.New
+	default:
+		return nil, ErrUnsupportedLegacyHash
+	}
+
+	salt, err := passlibBase64.DecodeString(fields[3])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedLegacyHash, err)
+	}
+	key, err := passlibBase64.DecodeString(fields[4])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedLegacyHash, err)
+	}
+	return newPBKDF2Hash(hashFunc, fields[2], salt, key)
+}
+
+func newPBKDF2Hash(hashFunc func() hash.Hash, iterations string, salt []byte, key []byte) (*pbkdf2Hash, error) {
+	rounds, err := strconv.Atoi(iterations)
+	if err != nil || rounds < 1 || len(key) == 0 {
+		return nil, ErrUnsupportedLegacyHash
+	}
+
+	return &pbkdf2Hash{
+		hash:       hashFunc,
+		iterations: rounds,
+		salt:       salt,
+		key:        key,
+	}, nil
+}
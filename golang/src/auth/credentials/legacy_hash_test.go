@@ -0,0 +1,39 @@
+package credentials
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Rejected legacy logins check a decoy hash, which must cost as much as the
+// hashes on file and be a real hash once there are none.
+func TestDecoyLegacyHash(t *testing.T) {
+	if cost, err := bcrypt.Cost([]byte(decoyBcryptHash)); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("fixed decoy has cost %d, %v", cost, err)
+	}
+
+	mgr, err := NewCredentialManager(GetCredentialSerializer(filepath.Join(t.TempDir(), "users.json")), nil, CredentialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	impl := mgr.(*credentialManager)
+	if hash := impl.decoyLegacyHash(); hash != decoyBcryptHash {
+		t.Errorf("empty store got %q", hash)
+	}
+
+	legacy := "$2a$04$C6UzMDM.H6dfI/f/IKxGhu6JkTvAahzJFs4EgPmzL8qDF9zpjTcTy"
+	if _, err = mgr.ImportUsers(UserCredList{"alice": {LegacyHash: legacy}}, false); err != nil {
+		t.Fatal(err)
+	}
+	if hash := impl.decoyLegacyHash(); hash != legacy {
+		t.Errorf("got %q, want the hash on file", hash)
+	}
+
+	for _, username := range []string{"ghost", "alice"} {
+		if upgraded, err := mgr.UpgradeLegacyUser(username, "wrong"); upgraded || err != nil {
+			t.Errorf("%s: got %v, %v", username, upgraded, err)
+		}
+	}
+}
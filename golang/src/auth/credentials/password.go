@@ -0,0 +1,23 @@
+package credentials
+
+import (
+	"errors"
+	"fmt"
+	"unicode/utf8"
+)
+
+const MinPasswordLength = 8
+
+var ErrPasswordTooShort = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+
+// ValidatePassword applies the policy for passwords set through AddUser and
+// UpdateUser. Legacy hashes are upgraded with whatever password they had.
+func ValidatePassword(password string) error {
+	if !utf8.ValidString(password) {
+		return errors.New("password must be valid UTF-8")
+	}
+	if utf8.RuneCountInString(password) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package credentials
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/keyfile"
+	"sharpstorm/srp-auth/logging"
+)
+
+const (
+	pepperInfoPrefix = "srp-auth pepper:"
+	pepperKeySize    = 32
+)
+
+var PepperKeys = keyfile.Kind{
+	Name:    "pepper",
+	KeySize: pepperKeySize,
+}
+
+// Pepper transforms verifiers before they are stored with a key the server
+// holds outside the credential file, so a leaked file alone does not allow
+// an offline dictionary attack. The transform is reversed right before a
+// verifier is used, the SRP math never sees the stored form.
+type Pepper interface {
+	Apply(username string, salt []byte, verifier []byte) ([]byte, string, error)
+	Reverse(username string, salt []byte, stored []byte, pepperId string) ([]byte, error)
+	PrimaryId() (string, error)
+}
+
+// keyRingPepper XORs the verifier with an HKDF keystream keyed by the pepper
+// and bound to the salt and username, a keyed permutation of byte strings of
+// that length. The salt changes with every password, so a keystream is
+// never reused for two verifiers.
+type keyRingPepper struct {
+	keyRing *keyfile.Ring
+}
+
+// LoadPepper reads a pepper key file, managed with `srp-auth pepper-keys`.
+func LoadPepper(filePath string) (Pepper, error) {
+	keyRing, err := keyfile.Load(filePath, PepperKeys, logging.For(nil, "credentials"))
+	if err != nil {
+		return nil, err
+	}
+	return &keyRingPepper{
+		keyRing: keyRing,
+	}, nil
+}
+
+func ReadPepperKeys(filePath string) ([]*keyfile.Key, error) {
+	return keyfile.Read(filePath, PepperKeys)
+}
+
+// RotatePepperKeys adds a new primary key, creating the file if needed. New
+// and changed passwords are peppered under it, CredentialManager.Repepper
+// moves the existing ones.
+func RotatePepperKeys(filePath string) (*keyfile.Key, error) {
+	return keyfile.Add(filePath, PepperKeys, true)
+}
+
+// RetirePepperKey removes a non-primary key, refusing while any record in
+// the credential file is still peppered under it. Those records could not
+// be read back without it.
+func RetirePepperKey(filePath string, kid string, serializer CredentialSerializer) error {
+	return keyfile.Retire(filePath, PepperKeys, kid, func(kid string) error {
+		users, err := serializer.Load()
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+
+		inUse := 0
+		for _, creds := range users {
+			if creds.PepperId == kid {
+				inUse++
+			}
+		}
+		if inUse > 0 {
+			return fmt.Errorf("%d users are still peppered under key %s", inUse, kid)
+		}
+		return nil
+	})
+}
+
+func (pepper *keyRingPepper) Apply(username string, salt []byte, verifier []byte) ([]byte, string, error) {
+	key, err := pepper.keyRing.Primary()
+	if err != nil {
+		return nil, "", err
+	}
+
+	stored, err := pepperTransform(key.Key, username, salt, verifier)
+	if err != nil {
+		return nil, "", err
+	}
+	return stored, key.Id, nil
+}
+
+func (pepper *keyRingPepper) Reverse(username string, salt []byte, stored []byte, pepperId string) ([]byte, error) {
+	key := pepper.keyRing.Lookup(pepperId)
+	if key == nil {
+		return nil, fmt.Errorf("verifier is peppered under unknown key %s", pepperId)
+	}
+	return pepperTransform(key.Key, username, salt, stored)
+}
+
+func (pepper *keyRingPepper) PrimaryId() (string, error) {
+	key, err := pepper.keyRing.Primary()
+	if err != nil {
+		return "", err
+	}
+	return key.Id, nil
+}
+
+// XOR is its own inverse, so this both applies and reverses the pepper.
+func pepperTransform(key []byte, username string, salt []byte, verifier []byte) ([]byte, error) {
+	keystream, err := hkdf.Key(sha256.New, key, salt, pepperInfoPrefix+username, len(verifier))
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, len(verifier))
+	for i := range verifier {
+		out[i] = verifier[i] ^ keystream[i]
+	}
+	return out, nil
+}
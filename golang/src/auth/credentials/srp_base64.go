@@ -0,0 +1,51 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"strings"
+)
+
+// GnuTLS and OpenSSL both store SRP values in the base64 variant of the
+// original SRP distribution: its own alphabet, no padding, and any partial
+// group at the front rather than the end.
+var srpBase64 = base64.NewEncoding("0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz./").WithPadding(base64.NoPadding)
+
+// encodeSRPBase64 writes the partial group in full, as OpenSSL does.
+func encodeSRPBase64(src []byte) string {
+	lead := (3 - len(src)%3) % 3
+	padded := append(make([]byte, lead), src...)
+	return srpBase64.EncodeToString(padded)[lead:]
+}
+
+// encodeGnuTLSBase64 drops the leading zero characters of the partial
+// group, keeping at least one, as GnuTLS does. g = 2 becomes just "2".
+func encodeGnuTLSBase64(src []byte) string {
+	encoded := encodeSRPBase64(src)
+	partial := 0 // Characters of the partial group, one more than its bytes
+	if mod := len(src) % 3; mod > 0 {
+		partial = mod + 1
+	}
+	trimmed := strings.TrimLeft(encoded[:partial], "0")
+	if trimmed == "" && partial > 0 {
+		trimmed = "0"
+	}
+	return trimmed + encoded[partial:]
+}
+
+// decodeSRPBase64 reads both encodings the way GnuTLS does. A partial group
+// of n characters holds the bytes its value needs, but at least n-1 and at
+// least one.
+func decodeSRPBase64(src string) ([]byte, error) {
+	lead := (4 - len(src)%4) % 4
+	dat, err := srpBase64.DecodeString(strings.Repeat("0", lead) + src)
+	if err != nil {
+		return nil, err
+	}
+
+	strip := min(lead, 2)
+	for strip > 0 && dat[0] == 0 {
+		dat = dat[1:]
+		strip--
+	}
+	return dat, nil
+}
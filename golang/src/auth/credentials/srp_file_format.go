@@ -0,0 +1,66 @@
+package credentials
+
+import (
+	"fmt"
+	"math/big"
+	"sharpstorm/srp-auth/auth/srp"
+	"sort"
+)
+
+// Records from GnuTLS and OpenSSL verifier files derive x as in RFC 5054
+// with SHA-1, only records created that way can be exported to them.
+const srpFileHash = srp.SHA1
+
+func isSRPFileCompatible(creds *UserCreds) bool {
+	return creds.KDF == srp.KDFRFC5054 && creds.Hash == srpFileHash.String()
+}
+
+func newSRPFileRecord(salt []byte, verifier []byte, group *srp.ConstantGroup) *UserCreds {
+	return &UserCreds{
+		Salt:     salt,
+		Verifier: verifier,
+		Group:    group.Name,
+		Hash:     srpFileHash.String(),
+		KDF:      srp.KDFRFC5054,
+	}
+}
+
+// groupByValue finds the known group with the given parameters, so files
+// that number their groups map onto srp.ConstantGroup names.
+func groupByValue(groups []*srp.ConstantGroup, N []byte, g []byte) (*srp.ConstantGroup, error) {
+	nValue := big.NewInt(0).SetBytes(N)
+	gValue := big.NewInt(0).SetBytes(g)
+	for _, group := range groups {
+		if group.N.Cmp(nValue) == 0 && group.G.Cmp(gValue) == 0 {
+			return group, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown %d bit group, add it to srp.groupFile to import it", nValue.BitLen())
+}
+
+func groupByName(groups []*srp.ConstantGroup, name string) (*srp.ConstantGroup, error) {
+	for _, group := range groups {
+		if group.Name == name {
+			return group, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown group %q", name)
+}
+
+// exportableUsers splits users into those a verifier file can hold, in a
+// stable order, and the names of those it cannot.
+func exportableUsers(users UserCredList) ([]string, []string) {
+	exportable := []string{}
+	skipped := []string{}
+	for username, creds := range users {
+		if isSRPFileCompatible(creds) {
+			exportable = append(exportable, username)
+		} else {
+			skipped = append(skipped, username)
+		}
+	}
+
+	sort.Strings(exportable)
+	sort.Strings(skipped)
+	return exportable, skipped
+}
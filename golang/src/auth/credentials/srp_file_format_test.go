@@ -0,0 +1,251 @@
+package credentials
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sharpstorm/srp-auth/auth/srp"
+	"strings"
+	"testing"
+)
+
+// The fixtures hold verifiers made by `openssl srp`, testdata/gnutls.tpasswd
+// the same records as GnuTLS srptool writes them. Each user's password is
+// listed here.
+var fixturePasswords = map[string]string{
+	"alice": "password123",
+	"bob":   "hunter22",
+	"carol": "secretpw",
+	"u17":   "pw17",
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestTPasswdRoundTrip(t *testing.T) {
+	passwd := readFixture(t, "gnutls.tpasswd")
+	conf := readFixture(t, "gnutls.tpasswd.conf")
+
+	users, err := ReadTPasswd(bytes.NewReader(passwd), bytes.NewReader(conf), srp.BuiltinGroups())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 3 {
+		t.Fatalf("read %d users, want 3", len(users))
+	}
+	checkPasswords(t, users)
+
+	var passwdOut, confOut bytes.Buffer
+	skipped, err := WriteTPasswd(users, srp.BuiltinGroups(), &passwdOut, &confOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("skipped %v", skipped)
+	}
+	if !bytes.Equal(passwdOut.Bytes(), passwd) {
+		t.Errorf("tpasswd changed:\n%s\nwant:\n%s", passwdOut.Bytes(), passwd)
+	}
+	if !bytes.Equal(confOut.Bytes(), conf) {
+		t.Errorf("tpasswd.conf changed:\n%s\nwant:\n%s", confOut.Bytes(), conf)
+	}
+}
+
+func TestSRPVFileRoundTrip(t *testing.T) {
+	vfile := readFixture(t, "openssl.srpv")
+
+	users, err := ReadSRPVFile(bytes.NewReader(vfile), srp.BuiltinGroups())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 4 {
+		t.Fatalf("read %d users, want 4", len(users))
+	}
+	if !users["carol"].Disabled || users["alice"].Disabled {
+		t.Error("only the revoked user should be disabled")
+	}
+	checkPasswords(t, users)
+
+	var out bytes.Buffer
+	skipped, err := WriteSRPVFile(users, srp.BuiltinGroups(), &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(skipped) != 0 {
+		t.Errorf("skipped %v", skipped)
+	}
+	if !bytes.Equal(out.Bytes(), vfile) {
+		t.Errorf("srpvfile changed:\n%s\nwant:\n%s", out.Bytes(), vfile)
+	}
+}
+
+// checkPasswords imports the records and checks each password against them,
+// which only holds if salts and verifiers were decoded exactly.
+func checkPasswords(t *testing.T, users UserCredList) {
+	t.Helper()
+	engine, err := srp.NewSRPEngine(&srp.GROUP_2048, srp.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mgr, err := NewCredentialManager(GetCredentialSerializer(filepath.Join(t.TempDir(), "users.json")), engine, CredentialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = mgr.ImportUsers(users, false); err != nil {
+		t.Fatal(err)
+	}
+
+	for username := range users {
+		ok, err := mgr.VerifyPassword(username, fixturePasswords[username], srp.BuiltinGroups())
+		if err != nil || !ok {
+			t.Errorf("%s: password does not match the imported verifier, err %v", username, err)
+		}
+		if ok, _ = mgr.VerifyPassword(username, "wrong", srp.BuiltinGroups()); ok {
+			t.Errorf("%s: wrong password accepted", username)
+		}
+	}
+}
+
+func TestSRPBase64(t *testing.T) {
+	tests := []struct {
+		value   []byte
+		openssl string
+		gnutls  string
+	}{
+		{[]byte{2}, "02", "2"},
+		{[]byte{0xff}, "3/", "3/"},
+		{[]byte{0x01, 0x05}, "045", "45"},
+		{[]byte{0x10, 0x05}, "105", "105"},
+		{[]byte{0x00, 0x00}, "000", "0"},
+		{[]byte{0x01, 0x02, 0x03}, "0G83", "0G83"},
+		{[]byte{0x00, 0x01, 0x02, 0x03}, "000G83", "00G83"},
+		{[]byte{}, "", ""},
+	}
+	for _, test := range tests {
+		if got := encodeSRPBase64(test.value); got != test.openssl {
+			t.Errorf("encodeSRPBase64(%x) = %q, want %q", test.value, got, test.openssl)
+		}
+		if got := encodeGnuTLSBase64(test.value); got != test.gnutls {
+			t.Errorf("encodeGnuTLSBase64(%x) = %q, want %q", test.value, got, test.gnutls)
+		}
+		if got, err := decodeSRPBase64(test.openssl); err != nil || !bytes.Equal(got, test.value) {
+			t.Errorf("decodeSRPBase64(%q) = %x, %v, want %x", test.openssl, got, err, test.value)
+		}
+	}
+}
+
+func TestGnuTLSBase64Decode(t *testing.T) {
+	tests := map[string][]byte{
+		"2":   {2},
+		"45":  {0x01, 0x05},
+		"3/":  {0xff},
+		"G83": {0x01, 0x02, 0x03},
+		"0":   {0},
+	}
+	for encoded, want := range tests {
+		if got, err := decodeSRPBase64(encoded); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("decodeSRPBase64(%q) = %x, %v, want %x", encoded, got, err, want)
+		}
+	}
+
+	if _, err := decodeSRPBase64("ab=c"); err == nil {
+		t.Error("decoded a character outside the alphabet")
+	}
+}
+
+// Values without leading zero bytes survive both encodings.
+func TestSRPBase64RoundTrip(t *testing.T) {
+	for size := 1; size <= 64; size++ {
+		value := bytes.Repeat([]byte{0x5a}, size)
+		value[0] = byte(size)
+		for name, encode := range map[string]func([]byte) string{"openssl": encodeSRPBase64, "gnutls": encodeGnuTLSBase64} {
+			decoded, err := decodeSRPBase64(encode(value))
+			if err != nil || !bytes.Equal(decoded, value) {
+				t.Errorf("%s: %d bytes came back as %x, %v", name, size, decoded, err)
+			}
+		}
+	}
+}
+
+func TestGroupByValueUnknown(t *testing.T) {
+	group := srp.BuiltinGroups()[0]
+	N := group.N.Bytes()
+
+	if _, err := groupByValue(srp.BuiltinGroups(), N, []byte{5}); err == nil {
+		t.Error("accepted a known N with another generator")
+	}
+
+	N[len(N)-1] ^= 2
+	_, err := groupByValue(srp.BuiltinGroups(), N, group.G.Bytes())
+	if err == nil || !strings.Contains(err.Error(), "unknown 1024 bit group") {
+		t.Errorf("got %v, want an unknown 1024 bit group error", err)
+	}
+
+	conf := "1:" + encodeGnuTLSBase64(N) + ":2\n"
+	_, err = ReadTPasswd(strings.NewReader("alice:2:2:1\n"), strings.NewReader(conf), srp.BuiltinGroups())
+	if err == nil || !strings.Contains(err.Error(), "tpasswd line 1: unknown 1024 bit group") {
+		t.Errorf("got %v, want the unknown group reported for line 1", err)
+	}
+
+	vfile := "I\t" + encodeSRPBase64(N) + "\t2\tcustom\t\t\n"
+	if _, err = ReadSRPVFile(strings.NewReader(vfile), srp.BuiltinGroups()); err == nil || !strings.Contains(err.Error(), "unknown 1024 bit group") {
+		t.Errorf("got %v, want an unknown group error", err)
+	}
+}
+
+// Names the login requests would reject are refused on import, with the
+// line they came from. Blank lines still count towards the line number.
+func TestReadRejectsInvalidUsernames(t *testing.T) {
+	long := strings.Repeat("a", MaxUsernameLength+1)
+	passwd := strings.SplitN(string(readFixture(t, "gnutls.tpasswd")), "\n", 2)[0]
+	conf := readFixture(t, "gnutls.tpasswd.conf")
+	vfile := strings.SplitN(string(readFixture(t, "openssl.srpv")), "\n", 2)[0]
+	rename := func(line string, sep string, column int, username string) string {
+		fields := strings.Split(line, sep)
+		fields[column] = username
+		return strings.Join(fields, sep)
+	}
+
+	for _, username := range []string{long, "al\x01ice", "\xffalice"} {
+		_, err := ReadTPasswd(strings.NewReader("\n"+rename(passwd, ":", 0, username)+"\n"), bytes.NewReader(conf), srp.BuiltinGroups())
+		if !errors.Is(err, ErrInvalidUsername) || !strings.Contains(err.Error(), "tpasswd line 2:") {
+			t.Errorf("tpasswd %q: got %v", username, err)
+		}
+
+		_, err = ReadSRPVFile(strings.NewReader(vfile+"\n\n"+rename(vfile, "\t", srpvColId, username)+"\n"), srp.BuiltinGroups())
+		if !errors.Is(err, ErrInvalidUsername) || !strings.Contains(err.Error(), "line 3:") {
+			t.Errorf("srpvfile %q: got %v", username, err)
+		}
+
+		_, err = ReadLegacyHashes(strings.NewReader("bob:$2a$04$C6UzMDM.H6dfI/f/IKxGhu6JkTvAahzJFs4EgPmzL8qDF9zpjTcTy\n" + username + ":$2a$04$C6UzMDM.H6dfI/f/IKxGhu6JkTvAahzJFs4EgPmzL8qDF9zpjTcTy\n"))
+		if !errors.Is(err, ErrInvalidUsername) || !strings.Contains(err.Error(), "line 2:") {
+			t.Errorf("legacy %q: got %v", username, err)
+		}
+	}
+}
+
+func TestImportUsersRejectsInvalidUsernames(t *testing.T) {
+	mgr, err := NewCredentialManager(GetCredentialSerializer(filepath.Join(t.TempDir(), "users.json")), nil, CredentialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := "$2a$04$C6UzMDM.H6dfI/f/IKxGhu6JkTvAahzJFs4EgPmzL8qDF9zpjTcTy"
+	users := UserCredList{
+		"alice":    {LegacyHash: hash},
+		"bad\tone": {LegacyHash: hash},
+	}
+
+	if written, err := mgr.ImportUsers(users, false); !errors.Is(err, ErrInvalidUsername) || written != 0 {
+		t.Fatalf("got %d, %v", written, err)
+	}
+	if _, err = mgr.GetUserInfo("alice"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("alice was imported alongside an invalid name: %v", err)
+	}
+}
@@ -0,0 +1,149 @@
+package credentials
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sharpstorm/srp-auth/auth/srp"
+	"strings"
+)
+
+// Row types and columns of an OpenSSL `openssl srp` verifier file, a
+// tab separated text database.
+const (
+	srpvTypeIndex    = "I"
+	srpvTypeValid    = "V"
+	srpvTypeRevoked  = "R"
+	srpvTypeModified = "v"
+
+	srpvColType     = 0
+	srpvColVerifier = 1 // N for index rows
+	srpvColSalt     = 2 // g for index rows
+	srpvColId       = 3
+	srpvColGroup    = 4
+	srpvColInfo     = 5
+	srpvColumns     = 6
+)
+
+// ReadSRPVFile parses an OpenSSL srpvfile. Group columns name either an
+// index row in the file or one of OpenSSL's default groups, which share
+// their names with the builtin groups. Revoked and pending rows are
+// imported as disabled users, OpenSSL does not accept them either.
+func ReadSRPVFile(r io.Reader, groups []*srp.ConstantGroup) (UserCredList, error) {
+	rows := [][]string{}
+	lineNos := []int{}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		row := strings.Split(scanner.Text(), "\t")
+		if len(row) != srpvColumns {
+			return nil, fmt.Errorf("line %d: expected %d fields, got %d", lineNo, srpvColumns, len(row))
+		}
+		rows = append(rows, row)
+		lineNos = append(lineNos, lineNo)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	indexGroups := make(map[string]*srp.ConstantGroup)
+	for i, row := range rows {
+		lineNo := lineNos[i]
+		if row[srpvColType] != srpvTypeIndex {
+			continue
+		}
+
+		N, err := decodeSRPBase64(row[srpvColVerifier])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid N: %w", lineNo, err)
+		}
+		g, err := decodeSRPBase64(row[srpvColSalt])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid g: %w", lineNo, err)
+		}
+		if indexGroups[row[srpvColId]], err = groupByValue(groups, N, g); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+	}
+
+	users := make(UserCredList)
+	for i, row := range rows {
+		lineNo := lineNos[i]
+		switch row[srpvColType] {
+		case srpvTypeIndex:
+			continue
+		case srpvTypeValid, srpvTypeRevoked, srpvTypeModified:
+		default:
+			return nil, fmt.Errorf("line %d: unknown row type %q", lineNo, row[srpvColType])
+		}
+		if err := ValidateUsername(row[srpvColId]); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+
+		group, found := indexGroups[row[srpvColGroup]]
+		if !found {
+			var err error
+			if group, err = groupByName(groups, row[srpvColGroup]); err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+		}
+
+		verifier, err := decodeSRPBase64(row[srpvColVerifier])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid verifier: %w", lineNo, err)
+		}
+		salt, err := decodeSRPBase64(row[srpvColSalt])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid salt: %w", lineNo, err)
+		}
+
+		creds := newSRPFileRecord(salt, verifier, group)
+		creds.Disabled = row[srpvColType] != srpvTypeValid
+		users[row[srpvColId]] = creds
+	}
+	return users, nil
+}
+
+// WriteSRPVFile writes the users an srpvfile can hold. Custom groups get an
+// index row named after the group. Verifiers must not be peppered. It
+// returns the users that were skipped because their verifier was not
+// derived as RFC 5054 with SHA-1.
+func WriteSRPVFile(users UserCredList, groups []*srp.ConstantGroup, w io.Writer) ([]string, error) {
+	exportable, skipped := exportableUsers(users)
+
+	out := bufio.NewWriter(w)
+	indexed := make(map[string]bool)
+	for _, username := range exportable {
+		creds := users[username]
+		if srp.GetBuiltinGroup(creds.Group) == nil && !indexed[creds.Group] {
+			group, err := groupByName(groups, creds.Group)
+			if err != nil {
+				return nil, fmt.Errorf("user %q: %w", username, err)
+			}
+			writeSRPVRow(out, srpvTypeIndex, encodeSRPBase64(group.N.Bytes()), encodeSRPBase64(group.G.Bytes()), group.Name, "")
+			indexed[creds.Group] = true
+		}
+
+		rowType := srpvTypeValid
+		if creds.Disabled {
+			rowType = srpvTypeRevoked
+		}
+		writeSRPVRow(out, rowType, encodeSRPBase64(creds.Verifier), encodeSRPBase64(creds.Salt), username, creds.Group)
+	}
+
+	return skipped, out.Flush()
+}
+
+func writeSRPVRow(out *bufio.Writer, rowType string, verifier string, salt string, id string, group string) {
+	row := make([]string, srpvColumns)
+	row[srpvColType] = rowType
+	row[srpvColVerifier] = verifier
+	row[srpvColSalt] = salt
+	row[srpvColId] = id
+	row[srpvColGroup] = group
+	row[srpvColInfo] = ""
+	fmt.Fprintln(out, strings.Join(row, "\t"))
+}
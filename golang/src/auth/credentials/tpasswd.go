@@ -0,0 +1,121 @@
+package credentials
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sharpstorm/srp-auth/auth/srp"
+	"strconv"
+	"strings"
+)
+
+type tpasswdGroup struct {
+	N []byte
+	g []byte
+}
+
+// ReadTPasswd parses a GnuTLS tpasswd file, "username:verifier:salt:index"
+// per line, resolving each index through tpasswd.conf, "index:N:g" per line,
+// onto one of groups.
+func ReadTPasswd(passwd io.Reader, conf io.Reader, groups []*srp.ConstantGroup) (UserCredList, error) {
+	confGroups := make(map[string]*tpasswdGroup)
+	err := scanColonFile(conf, 3, func(lineNo int, fields []string) error {
+		N, err := decodeSRPBase64(fields[1])
+		if err != nil {
+			return fmt.Errorf("tpasswd.conf line %d: invalid N: %w", lineNo, err)
+		}
+		g, err := decodeSRPBase64(fields[2])
+		if err != nil {
+			return fmt.Errorf("tpasswd.conf line %d: invalid g: %w", lineNo, err)
+		}
+		confGroups[fields[0]] = &tpasswdGroup{N: N, g: g}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(UserCredList)
+	err = scanColonFile(passwd, 4, func(lineNo int, fields []string) error {
+		if err := ValidateUsername(fields[0]); err != nil {
+			return fmt.Errorf("tpasswd line %d: %w", lineNo, err)
+		}
+		confGroup, found := confGroups[fields[3]]
+		if !found {
+			return fmt.Errorf("tpasswd line %d: index %s is not in tpasswd.conf", lineNo, fields[3])
+		}
+		group, err := groupByValue(groups, confGroup.N, confGroup.g)
+		if err != nil {
+			return fmt.Errorf("tpasswd line %d: %w", lineNo, err)
+		}
+
+		verifier, err := decodeSRPBase64(fields[1])
+		if err != nil {
+			return fmt.Errorf("tpasswd line %d: invalid verifier: %w", lineNo, err)
+		}
+		salt, err := decodeSRPBase64(fields[2])
+		if err != nil {
+			return fmt.Errorf("tpasswd line %d: invalid salt: %w", lineNo, err)
+		}
+
+		users[fields[0]] = newSRPFileRecord(salt, verifier, group)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// WriteTPasswd writes the users a tpasswd file can hold, numbering their
+// groups in tpasswd.conf. Verifiers must not be peppered. It returns the
+// users that were skipped because their verifier was not derived as RFC
+// 5054 with SHA-1.
+func WriteTPasswd(users UserCredList, groups []*srp.ConstantGroup, passwd io.Writer, conf io.Writer) ([]string, error) {
+	exportable, skipped := exportableUsers(users)
+
+	indices := make(map[string]string)
+	passwdOut := bufio.NewWriter(passwd)
+	confOut := bufio.NewWriter(conf)
+	for _, username := range exportable {
+		creds := users[username]
+		index, found := indices[creds.Group]
+		if !found {
+			group, err := groupByName(groups, creds.Group)
+			if err != nil {
+				return nil, fmt.Errorf("user %q: %w", username, err)
+			}
+			index = strconv.Itoa(len(indices) + 1)
+			indices[creds.Group] = index
+			fmt.Fprintf(confOut, "%s:%s:%s\n", index, encodeGnuTLSBase64(group.N.Bytes()), encodeGnuTLSBase64(group.G.Bytes()))
+		}
+
+		fmt.Fprintf(passwdOut, "%s:%s:%s:%s\n", username, encodeGnuTLSBase64(creds.Verifier), encodeGnuTLSBase64(creds.Salt), index)
+	}
+
+	if err := passwdOut.Flush(); err != nil {
+		return nil, err
+	}
+	return skipped, confOut.Flush()
+}
+
+func scanColonFile(r io.Reader, fieldCount int, handle func(lineNo int, fields []string) error) error {
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Split(line, ":")
+		if len(fields) != fieldCount {
+			return fmt.Errorf("line %d: expected %d fields, got %d", lineNo, fieldCount, len(fields))
+		}
+		if err := handle(lineNo, fields); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,28 @@
+package credentials
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const MaxUsernameLength = 128
+
+var ErrInvalidUsername = errors.New("username must be 1 to 128 bytes of printable UTF-8 without ':'")
+
+// ValidateUsername rejects usernames that cannot be written to the verifier
+// file formats, which separate fields with ':', or that would garble logs.
+func ValidateUsername(username string) error {
+	if username == "" || len(username) > MaxUsernameLength || !utf8.ValidString(username) {
+		return ErrInvalidUsername
+	}
+	if strings.ContainsRune(username, ':') || strings.IndexFunc(username, isUnprintable) >= 0 {
+		return ErrInvalidUsername
+	}
+	return nil
+}
+
+func isUnprintable(r rune) bool {
+	return !unicode.IsPrint(r)
+}
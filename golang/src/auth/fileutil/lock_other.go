@@ -0,0 +1,31 @@
+//go:build !unix
+
+package fileutil
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+const lockRetryInterval = 50 * time.Millisecond
+
+// Lock takes an exclusive lock on lockPath, creating it if needed.
+//
+// Without flock, the lock file itself is the lock. A crashed process leaves
+// it behind and it has to be removed by hand.
+func Lock(lockPath string) (func(), error) {
+	for {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_RDWR, 0600)
+		if err == nil {
+			file.Close()
+			return func() {
+				os.Remove(lockPath)
+			}, nil
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+		time.Sleep(lockRetryInterval)
+	}
+}
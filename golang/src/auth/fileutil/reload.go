@@ -0,0 +1,75 @@
+package fileutil
+
+import "time"
+
+// Reloader calls load again when a file's modification time changes,
+// looking at most once per period. It holds no lock of its own, callers
+// serialise access together with the state load replaces.
+type Reloader struct {
+	modTime       func() (time.Time, error)
+	load          func() error
+	period        time.Duration
+	loadedModTime time.Time
+	lastChecked   time.Time
+}
+
+func NewReloader(filePath string, period time.Duration, load func() error) *Reloader {
+	return NewReloaderFunc(func() (time.Time, error) { return ModTime(filePath) }, period, load)
+}
+
+// NewReloaderFunc is NewReloader for files only reachable through an
+// abstraction, modTime must return the zero time for a missing file.
+func NewReloaderFunc(modTime func() (time.Time, error), period time.Duration, load func() error) *Reloader {
+	return &Reloader{
+		modTime: modTime,
+		load:    load,
+		period:  period,
+	}
+}
+
+// Load reads the file regardless of whether it changed.
+func (reloader *Reloader) Load() error {
+	modTime, err := reloader.modTime()
+	if err != nil {
+		return err
+	}
+	if err = reloader.load(); err != nil {
+		return err
+	}
+
+	reloader.loadedModTime = modTime
+	reloader.lastChecked = time.Now()
+	return nil
+}
+
+// RefreshIfChanged reloads the file if it changed since the last load. On
+// error the caller keeps its last good copy, the next check retries.
+func (reloader *Reloader) RefreshIfChanged() error {
+	if time.Since(reloader.lastChecked) < reloader.period {
+		return nil
+	}
+	reloader.lastChecked = time.Now()
+
+	if changed, err := reloader.Changed(); err != nil || !changed {
+		return nil
+	}
+	return reloader.Load()
+}
+
+// Changed reports whether the file changed since the last load, checking
+// now regardless of the period.
+func (reloader *Reloader) Changed() (bool, error) {
+	modTime, err := reloader.modTime()
+	if err != nil {
+		return false, err
+	}
+	return !modTime.Equal(reloader.loadedModTime), nil
+}
+
+// MarkCurrent records the file as loaded after the caller wrote it, so its
+// own write does not trigger a reload.
+func (reloader *Reloader) MarkCurrent() {
+	if modTime, err := reloader.modTime(); err == nil {
+		reloader.loadedModTime = modTime
+	}
+}
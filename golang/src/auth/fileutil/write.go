@@ -0,0 +1,44 @@
+package fileutil
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteAtomic replaces filePath in one rename so that concurrent readers
+// never see a partially written file.
+func WriteAtomic(filePath string, dat []byte, perm os.FileMode) error {
+	tmpFile, err := os.CreateTemp(filepath.Dir(filePath), filepath.Base(filePath)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err = tmpFile.Write(dat); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmpFile.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmpFile.Name(), filePath)
+}
+
+// ModTime returns the zero time if the file does not exist.
+func ModTime(filePath string) (time.Time, error) {
+	info, err := os.Stat(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
@@ -1,141 +1,434 @@
 package auth
 
 import (
-	"log"
+	"context"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"log/slog"
 	"sharpstorm/srp-auth/auth/credentials"
 	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/logging"
+	"sync"
 	"time"
+)
 
-	"github.com/google/uuid"
+const (
+	drainPollInterval = 100 * time.Millisecond
+	decoySaltInfo     = "srp-auth decoy salt"
 )
 
-const signatureValidity = 10 * time.Second // Signatures are only valid for 15 seconds
-const handshakeLimit = 3
+var (
+	ErrDraining = errors.New("not accepting new handshakes")
+	// ErrHandshakeUnavailable is not returned, users that cannot log in get
+	// a decoy handshake instead. It names the reason in the audit log.
+	ErrHandshakeUnavailable = errors.New("cannot start a handshake for this user")
+	ErrInvalidClientPublic  = errors.New("invalid client public key")
+)
 
 type HandshakeManager interface {
-	GenerateHandshake(username string) (*SrpHandshakeSession, []byte, []byte)
-	ConsumeHandshake(username string, handshakeId string) *SrpHandshakeSession
+	// ctx only carries request scoped values such as the request id for logs.
+	// Unknown, disabled and legacy users get a decoy handshake that looks
+	// like a real one and whose client proof never verifies, so that the
+	// API does not tell which usernames exist.
+	// channelBinding is the binding type the caller will pass to
+	// BindChannel on verify, or empty.
+	GenerateHandshake(ctx context.Context, username string, clientPublic []byte, channelBinding string) (*SrpHandshakeSession, error)
+	// ConsumeHandshake fails with ErrHandshakeNotFound, ErrHandshakeExpired
+	// or ErrHandshakeReplayed if the handshake cannot be verified anymore.
+	ConsumeHandshake(ctx context.Context, username string, handshakeId string) (*SrpHandshakeSession, error)
+	// ProofSize is the client proof length verify expects for username, the
+	// digest size of its hash, decoys included. It lets a malformed proof
+	// be rejected before the handshake is consumed.
+	ProofSize(username string) int
+
+	// Pending counts users mid handshake on this instance, always 0 for
+	// stores that keep no state.
+	Pending() int
+	IsDraining() bool
+	Drain(ctx context.Context) error
+	Close()
+}
+
+type HandshakeOptions struct {
+	Groups   []*srp.ConstantGroup // Every group stored credentials may refer to
+	Validity time.Duration
+	Limit    int            // Concurrent handshakes per user, only used by the default store
+	Store    HandshakeStore // Defaults to NewMemoryHandshakeStore
+	Logger   *slog.Logger   // Defaults to slog.Default()
+	// DecoyKey keeps decoy handshakes the same across restarts and
+	// instances, a key per process is used if unset.
+	DecoyKey []byte
 }
 
 type handshakeManager struct {
 	credentialManager credentials.CredentialManager
-	activeHandshakes  map[string][]*SrpHandshakeSession
-	factory           srp.SRPVerifierFactory
-	expiryWorkerLock  chan bool
+	store             HandshakeStore
+	logger            *slog.Logger
+
+	groups    []*srp.ConstantGroup
+	validity  time.Duration
+	factories map[factoryKey]srp.SRPVerifierFactory
+	decoyKey  []byte
+	lock      sync.Mutex
+
+	draining bool
+}
+
+type factoryKey struct {
+	group string
+	hash  srp.HashType
 }
 
 type SrpHandshakeSession struct {
 	HandshakeId string
 	Verifier    srp.SRPVerifier
-	publicKey   []byte
-	expiryTime  time.Time
+	Group       *srp.ConstantGroup
+	Hash        srp.HashType
+	KDF         string
+	Salt        []byte
+	PublicKey   []byte
+	Decoy       bool // Set for handshakes of users that cannot log in
+	// ChannelBinding is the binding type agreed when the handshake started,
+	// e.g. "tls-exporter". The verifier must then be bound to the value of
+	// the channel the proof arrives on.
+	ChannelBinding string
 }
 
-func NewHandshakeManager(credentialManager credentials.CredentialManager) HandshakeManager {
-	mgr := &handshakeManager{
+func NewHandshakeManager(credentialManager credentials.CredentialManager, opts HandshakeOptions) HandshakeManager {
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryHandshakeStore(opts.Validity, opts.Limit)
+	}
+
+	decoyKey := opts.DecoyKey
+	if decoyKey == nil {
+		decoyKey = make([]byte, sha256.Size)
+		rand.Read(decoyKey)
+	}
+
+	return &handshakeManager{
 		credentialManager: credentialManager,
-		activeHandshakes:  make(map[string][]*SrpHandshakeSession),
-		factory:           srp.NewSRPVerifierFactory(SRP_GROUP, SRP_HASH),
-		expiryWorkerLock:  make(chan bool, 1),
+		store:             store,
+		logger:            logging.For(opts.Logger, "handshake"),
+		groups:            opts.Groups,
+		validity:          opts.Validity,
+		factories:         make(map[factoryKey]srp.SRPVerifierFactory),
+		decoyKey:          decoyKey,
+	}
+}
+
+func (cm *handshakeManager) GenerateHandshake(ctx context.Context, username string, clientPublic []byte, channelBinding string) (*SrpHandshakeSession, error) {
+	if cm.IsDraining() {
+		return nil, ErrDraining
+	}
+
+	userInfo, err := cm.credentialManager.GetUserInfo(username)
+	if err != nil || userInfo.Disabled || userInfo.IsLegacy() {
+		return cm.generateDecoy(ctx, username, clientPublic, channelBinding)
 	}
 
-	mgr.expiryWorkerLock <- true
-	return mgr
+	group, hashType, factory, err := cm.factoryFor(userInfo)
+	if err != nil {
+		cm.logger.WarnContext(ctx, "Unusable credentials for user", "user", username, "err", err)
+		return cm.generateDecoy(ctx, username, clientPublic, channelBinding)
+	}
+
+	plainVerifier, err := cm.credentialManager.Verifier(username, userInfo)
+	if err != nil {
+		cm.logger.WarnContext(ctx, "Unusable credentials for user", "user", username, "err", err)
+		return cm.generateDecoy(ctx, username, clientPublic, channelBinding)
+	}
+
+	handshake, err := cm.start(username, group, hashType, factory, userInfo.Salt, plainVerifier, clientPublic, channelBinding, false)
+	if err != nil {
+		return nil, err
+	}
+	handshake.KDF = userInfo.KDF
+
+	cm.logger.DebugContext(ctx, "Started handshake", "user", username, "group", group.Name, "hash", hashType.String())
+	return handshake, nil
+}
+
+// generateDecoy answers for a user that cannot log in as if it were a
+// real user, see decoyCreds.
+func (cm *handshakeManager) generateDecoy(ctx context.Context, username string, clientPublic []byte, channelBinding string) (*SrpHandshakeSession, error) {
+	creds, err := cm.decoyCreds(username)
+	if err != nil {
+		return nil, err
+	}
+	group, hashType, factory, err := cm.factoryFor(creds)
+	if err != nil {
+		return nil, err
+	}
+
+	handshake, err := cm.start(username, group, hashType, factory, creds.Salt, cm.decoyVerifier(username, creds), clientPublic, channelBinding, true)
+	if err != nil {
+		return nil, err
+	}
+	handshake.KDF = creds.KDF
+
+	cm.logger.DebugContext(ctx, "Started decoy handshake", "user", username)
+	return handshake, nil
 }
 
-func (cm *handshakeManager) GenerateHandshake(username string) (*SrpHandshakeSession, []byte, []byte) {
-	salt, verifier, err := cm.credentialManager.GetUserInfo(username)
+// decoyCreds makes up a record for username with the parameters a user
+// added now would get. They do not depend on which users exist, so adding
+// or removing one does not change the decoys, and the salt stays the same
+// for the username for as long as the decoy key does.
+func (cm *handshakeManager) decoyCreds(username string) (*credentials.UserCreds, error) {
+	creds := cm.credentialManager.DefaultRecord()
+	salt, err := hkdf.Key(sha256.New, cm.decoyKey, nil, decoySaltInfo+username, len(creds.Salt))
 	if err != nil {
-		return nil, nil, nil
+		return nil, err
 	}
+	creds.Salt = salt
+	rand.Read(creds.Verifier)
+	return creds, nil
+}
 
-	handshakeId := cm.generateIdentifier()
-	newHandshake := &SrpHandshakeSession{
-		HandshakeId: handshakeId,
-		Verifier:    cm.factory.GetVerifierFor(username, salt, verifier),
-		expiryTime:  time.Now().Add(signatureValidity),
+// decoyVerifier takes the record through the pepper like a real one, so a
+// decoy costs the same.
+func (cm *handshakeManager) decoyVerifier(username string, creds *credentials.UserCreds) []byte {
+	plainVerifier, err := cm.credentialManager.Verifier(username, creds)
+	if err != nil {
+		return creds.Verifier
 	}
-	pk, err := newHandshake.Verifier.InitPublicKey()
+	return plainVerifier
+}
+
+func (cm *handshakeManager) start(
+	username string,
+	group *srp.ConstantGroup,
+	hashType srp.HashType,
+	factory srp.SRPVerifierFactory,
+	salt []byte,
+	plainVerifier []byte,
+	clientPublic []byte,
+	channelBinding string,
+	decoy bool) (*SrpHandshakeSession, error) {
+	if len(clientPublic) == 0 || len(clientPublic) > group.NByteLen() {
+		return nil, ErrInvalidClientPublic
+	}
+
+	verifier := factory.GetVerifierFor(username, salt, plainVerifier)
+	pk, err := verifier.InitPublicKey()
 	if err != nil {
-		return nil, nil, nil
+		return nil, err
+	}
+	if err = verifier.SetClientPublicKey(clientPublic); err != nil {
+		return nil, ErrInvalidClientPublic
 	}
-	newHandshake.publicKey = pk
 
-	curHandshakes, found := cm.activeHandshakes[username]
-	if !found {
-		cm.activeHandshakes[username] = []*SrpHandshakeSession{newHandshake}
-	} else {
-		if len(curHandshakes) < handshakeLimit {
-			cm.activeHandshakes[username] = append(curHandshakes, newHandshake)
-		} else {
-			for i := 0; i < handshakeLimit-1; i++ {
-				curHandshakes[i] = curHandshakes[i+1]
-			}
-			curHandshakes[handshakeLimit-1] = newHandshake
-		}
+	handshakeId, err := cm.store.Save(&HandshakeState{
+		Username:       username,
+		Group:          group.Name,
+		Hash:           hashType.String(),
+		Secret:         verifier.ExportEphemeral(),
+		ClientPublic:   clientPublic,
+		ChannelBinding: channelBinding,
+		ExpiresAt:      time.Now().Add(cm.validity),
+		Decoy:          decoy,
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	cm.launchExpireHandshakeWorker()
-	return newHandshake, salt, pk
+	return &SrpHandshakeSession{
+		HandshakeId:    handshakeId,
+		Verifier:       verifier,
+		Group:          group,
+		Hash:           hashType,
+		Salt:           salt,
+		PublicKey:      pk,
+		Decoy:          decoy,
+		ChannelBinding: channelBinding,
+	}, nil
 }
 
-func (cm *handshakeManager) generateIdentifier() string {
-	return uuid.NewString()
+func (cm *handshakeManager) factoryFor(userInfo *credentials.UserCreds) (*srp.ConstantGroup, srp.HashType, srp.SRPVerifierFactory, error) {
+	group, hashType, err := userInfo.Params(cm.groups)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+
+	factory, err := cm.factoryForParams(group, hashType)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return group, hashType, factory, nil
 }
 
-func (cm *handshakeManager) ConsumeHandshake(username string, handshakeId string) *SrpHandshakeSession {
-	curHandshakes, found := cm.activeHandshakes[username]
-	if !found {
-		return nil
+func (cm *handshakeManager) factoryForParams(group *srp.ConstantGroup, hashType srp.HashType) (srp.SRPVerifierFactory, error) {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	key := factoryKey{group: group.Name, hash: hashType}
+	if factory, found := cm.factories[key]; found {
+		return factory, nil
 	}
 
-	handshake, idx := cm.findHandshake(curHandshakes, handshakeId)
-	if handshake == nil {
-		return nil
+	factory, err := srp.NewSRPVerifierFactory(group, hashType)
+	if err != nil {
+		return nil, err
 	}
+	cm.factories[key] = factory
+	return factory, nil
+}
 
-	curHandshakes[idx] = curHandshakes[len(curHandshakes)-1]
-	newHandshakeArr := curHandshakes[:len(curHandshakes)-1]
-	if len(newHandshakeArr) == 0 {
-		delete(cm.activeHandshakes, username)
-	} else {
-		cm.activeHandshakes[username] = newHandshakeArr
+// ConsumeHandshake takes the handshake out of the store and rebuilds its
+// verifier from the current credentials. If the credentials changed group
+// or hash in the meantime the handshake is dropped. Decoy handshakes, and
+// handshakes of users deleted or disabled since, get a verifier that
+// rejects every client proof.
+func (cm *handshakeManager) ConsumeHandshake(ctx context.Context, username string, handshakeId string) (*SrpHandshakeSession, error) {
+	state, err := cm.store.Take(username, handshakeId)
+	if err != nil {
+		if !errors.Is(err, ErrHandshakeNotFound) {
+			cm.logger.WarnContext(ctx, "Rejected handshake", "user", username, "err", err)
+		}
+		return nil, err
+	}
+	if state.Decoy {
+		return cm.consumeDecoy(ctx, username, handshakeId, state)
 	}
 
-	if time.Now().After(handshake.expiryTime) {
-		return nil
+	userInfo, err := cm.credentialManager.GetUserInfo(username)
+	if err != nil || userInfo.Disabled || userInfo.IsLegacy() {
+		return cm.consumeDecoy(ctx, username, handshakeId, state)
 	}
 
-	return handshake
+	group, hashType, factory, err := cm.factoryFor(userInfo)
+	if err != nil || group.Name != state.Group || hashType.String() != state.Hash {
+		return nil, ErrHandshakeNotFound
+	}
+
+	plainVerifier, err := cm.credentialManager.Verifier(username, userInfo)
+	if err != nil {
+		return cm.consumeDecoy(ctx, username, handshakeId, state)
+	}
+
+	verifier, err := factory.RestoreVerifierFor(username, userInfo.Salt, plainVerifier, state.Secret, state.ClientPublic)
+	if err != nil {
+		cm.logger.ErrorContext(ctx, "Failed to restore handshake", "user", username, "err", err)
+		return nil, err
+	}
+
+	return &SrpHandshakeSession{
+		HandshakeId:    handshakeId,
+		Verifier:       verifier,
+		Group:          group,
+		Hash:           hashType,
+		KDF:            userInfo.KDF,
+		Salt:           userInfo.Salt,
+		ChannelBinding: state.ChannelBinding,
+	}, nil
 }
 
-func (cm *handshakeManager) launchExpireHandshakeWorker() {
-	select {
-	case <-cm.expiryWorkerLock:
-		log.Println("[Handshake Mgr] Starting expiry worker")
-		time.AfterFunc(signatureValidity, cm.expireHandshake)
-	default:
+// consumeDecoy restores the handshake as a decoy, with the group and hash
+// it was started under if the store kept them. Stores that keep nothing
+// for decoys leave b and A unset, random ones cost the same to restore.
+func (cm *handshakeManager) consumeDecoy(ctx context.Context, username string, handshakeId string, state *HandshakeState) (*SrpHandshakeSession, error) {
+	creds, err := cm.decoyCreds(username)
+	if err != nil {
+		return nil, err
 	}
+	if state.Group != "" {
+		creds.Group, creds.Hash = state.Group, state.Hash
+	}
+	group, hashType, factory, err := cm.factoryFor(creds)
+	if err != nil {
+		return nil, ErrHandshakeNotFound
+	}
+
+	secret, clientPublic := state.Secret, state.ClientPublic
+	if len(secret) == 0 {
+		secret = make([]byte, group.NByteLen())
+		clientPublic = make([]byte, group.NByteLen())
+		rand.Read(secret)
+		rand.Read(clientPublic)
+	}
+
+	verifier, err := factory.RestoreVerifierFor(username, creds.Salt, cm.decoyVerifier(username, creds), secret, clientPublic)
+	if err != nil {
+		cm.logger.ErrorContext(ctx, "Failed to restore handshake", "user", username, "err", err)
+		return nil, err
+	}
+
+	return &SrpHandshakeSession{
+		HandshakeId:    handshakeId,
+		Verifier:       verifier,
+		Group:          group,
+		Hash:           hashType,
+		KDF:            creds.KDF,
+		Salt:           creds.Salt,
+		Decoy:          true,
+		ChannelBinding: state.ChannelBinding,
+	}, nil
 }
 
-func (cm *handshakeManager) expireHandshake() {
-	log.Println("[Handshake Mgr] Expiring handshakes")
+func (cm *handshakeManager) ProofSize(username string) int {
+	userInfo, err := cm.credentialManager.GetUserInfo(username)
+	if err == nil && !userInfo.Disabled && !userInfo.IsLegacy() {
+		if _, hashType, err := userInfo.Params(cm.groups); err == nil {
+			return hashType.Size()
+		}
+	}
 
-	if len(cm.activeHandshakes) == 0 {
-		cm.expiryWorkerLock <- true
-		log.Println("[Handshake Mgr] Expiry worker dying")
-		return
+	creds, err := cm.decoyCreds(username)
+	if err != nil {
+		return 0
+	}
+	_, hashType, err := creds.Params(cm.groups)
+	if err != nil {
+		return 0
 	}
+	return hashType.Size()
+}
+
+func (cm *handshakeManager) Pending() int {
+	return cm.store.Pending()
+}
+
+func (cm *handshakeManager) IsDraining() bool {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
 
-	time.AfterFunc(signatureValidity, cm.expireHandshake)
+	return cm.draining
 }
 
-func (cm *handshakeManager) findHandshake(handshakes []*SrpHandshakeSession, handshakeId string) (*SrpHandshakeSession, int) {
-	for idx, handshake := range handshakes {
-		if handshake.HandshakeId == handshakeId {
-			return handshake, idx
+// Drain stops new handshakes from being issued and waits until every
+// outstanding one has been verified or has expired, or until ctx is done.
+// Verify requests must still be served while draining.
+func (cm *handshakeManager) Drain(ctx context.Context) error {
+	cm.lock.Lock()
+	cm.draining = true
+	cm.lock.Unlock()
+	cm.logger.Info("Draining handshakes")
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for {
+		remaining := cm.store.Pending()
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			cm.logger.Warn("Gave up draining", "users_pending", remaining)
+			return ctx.Err()
+		case <-ticker.C:
 		}
 	}
+}
+
+func (cm *handshakeManager) Close() {
+	cm.lock.Lock()
+	cm.draining = true
+	cm.lock.Unlock()
 
-	return nil, 0
+	cm.store.Close()
 }
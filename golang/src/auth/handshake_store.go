@@ -0,0 +1,260 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"log/slog"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/logging"
+	"sync"
+	"time"
+)
+
+// Memory store hids are random, decoy hids are sealed. Both are this many
+// bytes, a nonce, an 8 byte expiry and a tag, so they look alike.
+const memoryHandshakeIdSize = 36
+
+var (
+	ErrHandshakeNotFound = errors.New("handshake not found")
+	ErrHandshakeExpired  = errors.New("handshake expired")
+	ErrHandshakeReplayed = errors.New("handshake already used")
+	// ErrMalformedHandshake is returned for handshake ids that could not
+	// have been issued, such as tampered sealed ids.
+	ErrMalformedHandshake = errors.New("malformed handshake id")
+)
+
+// HandshakeState is everything needed to resume a handshake on verify. The
+// verifier itself is rebuilt from the stored credentials.
+type HandshakeState struct {
+	Id           string `json:"jti"`
+	Username     string `json:"sub"`
+	Group        string `json:"grp"`
+	Hash         string `json:"hash"`
+	Secret       []byte `json:"b"` // Server private key b
+	ClientPublic []byte `json:"A"`
+	// Channel binding type the proofs are bound to, see SrpHandshakeSession
+	ChannelBinding string    `json:"cb,omitempty"`
+	ExpiresAt      time.Time `json:"exp"`
+	// Decoy is set for handshakes of users that cannot log in. Stores that
+	// keep state may hand out a hid without keeping anything for them, Take
+	// then returns the state with only Username, ExpiresAt and Decoy set.
+	Decoy bool `json:"-"`
+}
+
+// HandshakeStore keeps handshakes between the handshake and verify calls.
+// Save returns the hid handed to the client, Take returns the state for it
+// at most once.
+type HandshakeStore interface {
+	Save(state *HandshakeState) (string, error)
+	Take(username string, handshakeId string) (*HandshakeState, error)
+	// Pending counts handshakes that may still be verified against this
+	// store, used when draining.
+	Pending() int
+	Close()
+}
+
+type memoryHandshakeStore struct {
+	activeHandshakes map[string][]*HandshakeState
+	usedDecoys       map[string]time.Time // Decoy hids taken, until they expire
+	decoyKey         []byte
+	expiryWorkerLock chan bool
+	expiryTimer      *time.Timer
+
+	validity time.Duration
+	limit    int
+	logger   *slog.Logger
+	lock     sync.Mutex
+	closed   bool
+}
+
+// NewMemoryHandshakeStore keeps at most limit handshakes per user, the
+// oldest is dropped when another is started. Verify must reach the same
+// instance that served the handshake. Decoys are not kept, their hid is
+// sealed under a key per store and only recorded once taken.
+func NewMemoryHandshakeStore(validity time.Duration, limit int) HandshakeStore {
+	decoyKey := make([]byte, masterkey.KeySize)
+	rand.Read(decoyKey)
+
+	store := &memoryHandshakeStore{
+		activeHandshakes: make(map[string][]*HandshakeState),
+		usedDecoys:       make(map[string]time.Time),
+		decoyKey:         decoyKey,
+		expiryWorkerLock: make(chan bool, 1),
+		validity:         validity,
+		limit:            limit,
+		logger:           logging.For(nil, "handshake"),
+	}
+
+	store.expiryWorkerLock <- true
+	return store
+}
+
+func (store *memoryHandshakeStore) Save(state *HandshakeState) (string, error) {
+	if state.Decoy {
+		return store.sealDecoy(state)
+	}
+
+	id := make([]byte, memoryHandshakeIdSize)
+	rand.Read(id)
+	state.Id = base64.RawURLEncoding.EncodeToString(id)
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	curHandshakes := store.activeHandshakes[state.Username]
+	if len(curHandshakes) >= store.limit {
+		curHandshakes = curHandshakes[len(curHandshakes)-store.limit+1:]
+	}
+	store.activeHandshakes[state.Username] = append(curHandshakes, state)
+
+	store.launchExpireHandshakeWorker()
+	return state.Id, nil
+}
+
+func (store *memoryHandshakeStore) Take(username string, handshakeId string) (*HandshakeState, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	curHandshakes := store.activeHandshakes[username]
+	idx := -1
+	for i, handshake := range curHandshakes {
+		if handshake.Id == handshakeId {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return store.takeDecoy(username, handshakeId)
+	}
+
+	state := curHandshakes[idx]
+	curHandshakes[idx] = curHandshakes[len(curHandshakes)-1]
+	newHandshakeArr := curHandshakes[:len(curHandshakes)-1]
+	if len(newHandshakeArr) == 0 {
+		delete(store.activeHandshakes, username)
+	} else {
+		store.activeHandshakes[username] = newHandshakeArr
+	}
+
+	if time.Now().After(state.ExpiresAt) {
+		return nil, ErrHandshakeExpired
+	}
+	return state, nil
+}
+
+func (store *memoryHandshakeStore) Pending() int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.removeExpired()
+	return len(store.activeHandshakes)
+}
+
+func (store *memoryHandshakeStore) Close() {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.closed = true
+	if store.expiryTimer != nil {
+		store.expiryTimer.Stop()
+	}
+	store.activeHandshakes = make(map[string][]*HandshakeState)
+	store.usedDecoys = make(map[string]time.Time)
+}
+
+// sealDecoy returns a hid that takeDecoy can check without the store
+// keeping anything, so decoys for made up usernames cannot fill it up.
+func (store *memoryHandshakeStore) sealDecoy(state *HandshakeState) (string, error) {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(state.ExpiresAt.UnixNano()))
+	sealed, err := masterkey.Seal(store.decoyKey, payload, []byte(state.Username))
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// Must be called with store.lock held. A decoy hid is single use like any
+// other, so retrying it fails the same way.
+func (store *memoryHandshakeStore) takeDecoy(username string, handshakeId string) (*HandshakeState, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(handshakeId)
+	if err != nil || len(sealed) != memoryHandshakeIdSize {
+		return nil, ErrHandshakeNotFound
+	}
+	payload, err := masterkey.Open(store.decoyKey, sealed, []byte(username))
+	if err != nil {
+		return nil, ErrHandshakeNotFound
+	}
+
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+	if _, used := store.usedDecoys[handshakeId]; used {
+		return nil, ErrHandshakeNotFound
+	}
+	if time.Now().After(expiresAt) {
+		return nil, ErrHandshakeExpired
+	}
+	store.usedDecoys[handshakeId] = expiresAt
+	store.launchExpireHandshakeWorker()
+
+	return &HandshakeState{
+		Id:        handshakeId,
+		Username:  username,
+		ExpiresAt: expiresAt,
+		Decoy:     true,
+	}, nil
+}
+
+// Must be called with store.lock held.
+func (store *memoryHandshakeStore) launchExpireHandshakeWorker() {
+	if store.closed {
+		return
+	}
+
+	select {
+	case <-store.expiryWorkerLock:
+		store.logger.Debug("Starting expiry worker")
+		store.expiryTimer = time.AfterFunc(store.validity, store.expireHandshake)
+	default:
+	}
+}
+
+func (store *memoryHandshakeStore) expireHandshake() {
+	store.logger.Debug("Expiring handshakes")
+
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.removeExpired()
+	if (len(store.activeHandshakes) == 0 && len(store.usedDecoys) == 0) || store.closed {
+		store.expiryWorkerLock <- true
+		store.logger.Debug("Expiry worker stopping")
+		return
+	}
+
+	store.expiryTimer = time.AfterFunc(store.validity, store.expireHandshake)
+}
+
+// Must be called with store.lock held.
+func (store *memoryHandshakeStore) removeExpired() {
+	now := time.Now()
+	for handshakeId, expiresAt := range store.usedDecoys {
+		if !now.Before(expiresAt) {
+			delete(store.usedDecoys, handshakeId)
+		}
+	}
+	for username, handshakes := range store.activeHandshakes {
+		live := handshakes[:0]
+		for _, handshake := range handshakes {
+			if now.Before(handshake.ExpiresAt) {
+				live = append(live, handshake)
+			}
+		}
+
+		if len(live) == 0 {
+			delete(store.activeHandshakes, username)
+		} else {
+			store.activeHandshakes[username] = live
+		}
+	}
+}
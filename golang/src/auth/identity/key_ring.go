@@ -0,0 +1,94 @@
+package identity
+
+import (
+	"crypto/ed25519"
+	"sharpstorm/srp-auth/auth/keyfile"
+	"sharpstorm/srp-auth/logging"
+	"time"
+)
+
+var IdentityKeys = keyfile.Kind{
+	Name:    "identity",
+	KeySize: ed25519.SeedSize,
+}
+
+// IdentityKey is an Ed25519 key the server signs handshake responses with.
+// Key holds the private key seed.
+type IdentityKey = keyfile.Key
+
+func PublicKeyOf(key *IdentityKey) ed25519.PublicKey {
+	return ed25519.NewKeyFromSeed(key.Key).Public().(ed25519.PublicKey)
+}
+
+// PublicKey is a key clients may pin, see KeyRing.PublicKeys.
+type PublicKey struct {
+	Id        string            `json:"kid"`
+	Key       ed25519.PublicKey `json:"publickey"`
+	CreatedAt time.Time         `json:"createdAt"`
+	Primary   bool              `json:"primary"`
+}
+
+// KeyRing signs with its primary key and publishes every key it holds, so
+// a key added ahead of a rotation can be pinned before it signs anything.
+// Instances sharing the key file reload it when it changes.
+type KeyRing interface {
+	Sign(message []byte) (kid string, signature []byte, err error)
+	PublicKeys() []PublicKey
+}
+
+type fileKeyRing struct {
+	keys *keyfile.Ring
+}
+
+func LoadKeyRing(filePath string) (KeyRing, error) {
+	keys, err := keyfile.Load(filePath, IdentityKeys, logging.For(nil, "identity"))
+	if err != nil {
+		return nil, err
+	}
+	return &fileKeyRing{keys: keys}, nil
+}
+
+func (ring *fileKeyRing) Sign(message []byte) (string, []byte, error) {
+	key, err := ring.keys.Primary()
+	if err != nil {
+		return "", nil, err
+	}
+	return key.Id, ed25519.Sign(ed25519.NewKeyFromSeed(key.Key), message), nil
+}
+
+func (ring *fileKeyRing) PublicKeys() []PublicKey {
+	keys := ring.keys.Keys()
+	publicKeys := make([]PublicKey, 0, len(keys))
+	for _, key := range keys {
+		publicKeys = append(publicKeys, PublicKey{
+			Id:        key.Id,
+			Key:       PublicKeyOf(key),
+			CreatedAt: key.CreatedAt,
+			Primary:   key.Primary,
+		})
+	}
+	return publicKeys
+}
+
+func ReadKeys(filePath string) ([]*IdentityKey, error) {
+	return keyfile.Read(filePath, IdentityKeys)
+}
+
+// AddKey adds a new key, creating the file if needed. It only becomes the
+// primary key if the ring has none, otherwise it is published for clients
+// to pin until PromoteKey makes it sign.
+func AddKey(filePath string) (*IdentityKey, error) {
+	return keyfile.Add(filePath, IdentityKeys, false)
+}
+
+// PromoteKey makes a key the primary one. The previous primary key stays
+// published until it is retired, so clients that pinned it keep working.
+func PromoteKey(filePath string, kid string) error {
+	return keyfile.Promote(filePath, IdentityKeys, kid)
+}
+
+// RetireKey removes a non-primary key. Clients that only pinned it can no
+// longer verify the server.
+func RetireKey(filePath string, kid string) error {
+	return keyfile.Retire(filePath, IdentityKeys, kid, nil)
+}
@@ -0,0 +1,172 @@
+package keyfile
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"time"
+)
+
+const keyDataVersion = 1
+
+var ErrNoPrimaryKey = errors.New("key ring has no primary key")
+
+// Kind tells key files apart, so the tooling for one kind of key refuses a
+// file holding another.
+type Kind struct {
+	Name    string // Recorded in the file and used in messages
+	KeySize int
+	// Untyped accepts files written before key files recorded their kind,
+	// all of which held token keys.
+	Untyped bool
+}
+
+type Key struct {
+	Id        string    `json:"kid"`
+	Key       []byte    `json:"key"`
+	CreatedAt time.Time `json:"createdAt"`
+	Primary   bool      `json:"primary"`
+}
+
+type keyFile struct {
+	Version int    `json:"version"`
+	Type    string `json:"type,omitempty"`
+	Keys    []*Key `json:"keys"`
+}
+
+func Read(filePath string, kind Kind) ([]*Key, error) {
+	dat, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var container keyFile
+	if err = json.Unmarshal(dat, &container); err != nil {
+		return nil, err
+	}
+	if container.Version != keyDataVersion {
+		return nil, fmt.Errorf("%s key file has the wrong version", kind.Name)
+	}
+	if container.Type != kind.Name && !(container.Type == "" && kind.Untyped) {
+		return nil, fmt.Errorf("%s holds %s keys, not %s keys", filePath, typeName(container.Type), kind.Name)
+	}
+
+	for _, key := range container.Keys {
+		if len(key.Key) != kind.KeySize {
+			return nil, fmt.Errorf("%s key %q has the wrong size", kind.Name, key.Id)
+		}
+	}
+	return container.Keys, nil
+}
+
+func typeName(fileType string) string {
+	if fileType == "" {
+		return "untyped"
+	}
+	return fileType
+}
+
+// Add adds a new random key, creating the file if needed. The key becomes
+// the primary one if primary is set or the ring has none yet.
+func Add(filePath string, kind Kind, primary bool) (*Key, error) {
+	var newKey *Key
+	err := update(filePath, kind, func(keys []*Key) ([]*Key, error) {
+		secret := make([]byte, kind.KeySize)
+		suffix := make([]byte, 4)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+
+		now := time.Now().UTC()
+		newKey = &Key{
+			Id:        now.Format("20060102") + "-" + hex.EncodeToString(suffix),
+			Key:       secret,
+			CreatedAt: now,
+			Primary:   primary || len(keys) == 0,
+		}
+		if newKey.Primary {
+			for _, key := range keys {
+				key.Primary = false
+			}
+		}
+		return append(keys, newKey), nil
+	})
+	return newKey, err
+}
+
+// Promote makes a key the primary one, the previous primary key stays in
+// the ring until it is retired.
+func Promote(filePath string, kind Kind, kid string) error {
+	return update(filePath, kind, func(keys []*Key) ([]*Key, error) {
+		found := false
+		for _, key := range keys {
+			found = found || key.Id == kid
+		}
+		if !found {
+			return nil, fmt.Errorf("no %s key %q", kind.Name, kid)
+		}
+
+		for _, key := range keys {
+			key.Primary = key.Id == kid
+		}
+		return keys, nil
+	})
+}
+
+// Retire removes a non-primary key. If set, inUse is asked under the file
+// lock whether the key is still needed and can veto the removal.
+func Retire(filePath string, kind Kind, kid string, inUse func(kid string) error) error {
+	return update(filePath, kind, func(keys []*Key) ([]*Key, error) {
+		for idx, key := range keys {
+			if key.Id != kid {
+				continue
+			}
+			if key.Primary {
+				return nil, errors.New("cannot retire the primary key, make another key primary first")
+			}
+			if inUse != nil {
+				if err := inUse(kid); err != nil {
+					return nil, err
+				}
+			}
+			return append(keys[:idx], keys[idx+1:]...), nil
+		}
+		return nil, fmt.Errorf("no %s key %q", kind.Name, kid)
+	})
+}
+
+func update(filePath string, kind Kind, apply func([]*Key) ([]*Key, error)) error {
+	unlock, err := fileutil.Lock(filePath + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	keys, err := Read(filePath, kind)
+	if errors.Is(err, os.ErrNotExist) {
+		keys = []*Key{}
+	} else if err != nil {
+		return err
+	}
+
+	if keys, err = apply(keys); err != nil {
+		return err
+	}
+
+	dat, err := json.MarshalIndent(keyFile{
+		Version: keyDataVersion,
+		Type:    kind.Name,
+		Keys:    keys,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(filePath, dat, 0600)
+}
@@ -0,0 +1,91 @@
+package keyfile
+
+import (
+	"fmt"
+	"log/slog"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"sync"
+	"time"
+)
+
+const keyRingCheckPeriod = 5 * time.Second
+
+// Ring holds the keys of a key file. Instances sharing the file reload it
+// when it changes, so a rotation is picked up without a restart.
+type Ring struct {
+	filePath string
+	kind     Kind
+	keys     []*Key
+	reloader *fileutil.Reloader
+	logger   *slog.Logger
+	lock     sync.Mutex
+}
+
+// Load fails unless the file holds a primary key.
+func Load(filePath string, kind Kind, logger *slog.Logger) (*Ring, error) {
+	ring := &Ring{
+		filePath: filePath,
+		kind:     kind,
+		logger:   logger,
+	}
+	ring.reloader = fileutil.NewReloader(filePath, keyRingCheckPeriod, ring.reload)
+	if err := ring.reloader.Load(); err != nil {
+		return nil, err
+	}
+	if _, err := ring.Primary(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func (ring *Ring) Primary() (*Key, error) {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	ring.refreshIfChanged()
+	for _, key := range ring.keys {
+		if key.Primary {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("%s %w", ring.kind.Name, ErrNoPrimaryKey)
+}
+
+func (ring *Ring) Lookup(kid string) *Key {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	ring.refreshIfChanged()
+	for _, key := range ring.keys {
+		if key.Id == kid {
+			return key
+		}
+	}
+	return nil
+}
+
+// Keys returns every key in the ring, the primary one included.
+func (ring *Ring) Keys() []*Key {
+	ring.lock.Lock()
+	defer ring.lock.Unlock()
+
+	ring.refreshIfChanged()
+	return ring.keys
+}
+
+func (ring *Ring) refreshIfChanged() {
+	if err := ring.reloader.RefreshIfChanged(); err != nil {
+		ring.logger.Warn("Keeping old keys, reload failed", "kind", ring.kind.Name, "file", ring.filePath, "err", err)
+	}
+}
+
+func (ring *Ring) reload() error {
+	keys, err := Read(ring.filePath, ring.kind)
+	if err != nil {
+		return err
+	}
+
+	ring.keys = keys
+	ring.logger.Info("Loaded keys", "kind", ring.kind.Name, "file", ring.filePath, "count", len(keys))
+	return nil
+}
@@ -0,0 +1,87 @@
+package masterkey
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const KeySize = 32
+
+var ErrWrongKeySize = fmt.Errorf("master key must be %d bytes", KeySize)
+
+// Parse decodes a base64 encoded AES-256 key, as produced by
+// `openssl rand -base64 32`.
+func Parse(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("master key is not valid base64: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, ErrWrongKeySize
+	}
+	return key, nil
+}
+
+func LoadFile(filePath string) ([]byte, error) {
+	dat, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(string(dat))
+}
+
+// Load prefers the inline value, falling back to the key file.
+func Load(inline string, filePath string) ([]byte, error) {
+	if inline != "" {
+		return Parse(inline)
+	}
+	if filePath != "" {
+		return LoadFile(filePath)
+	}
+	return nil, errors.New("no master key configured")
+}
+
+// Seal encrypts plaintext with AES-GCM, prefixing the random nonce.
+func Seal(key []byte, plaintext []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func Open(key []byte, sealed []byte, additionalData []byte) ([]byte, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed data is truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, ErrWrongKeySize
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,138 @@
+package auth
+
+import (
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/auth/session"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	sealedHandshakeVersion = "h1"
+	sealedHandshakeKeyInfo = "srp-auth handshake state"
+)
+
+// ReplayCache records single use ids until they expire. Claim reports
+// whether the id was newly recorded, false means it was seen before.
+// session.RevocationList satisfies it, so the file backed list can be
+// shared between instances.
+type ReplayCache interface {
+	Claim(id string, expiresAt time.Time) (bool, error)
+}
+
+// sealedHandshakeStore keeps nothing locally. The state, including the
+// server private key b, is sealed into the hid "h1.<kid>.<base64url>" under
+// a key derived from the token key ring, so verify can be served by any
+// instance sharing the key file. The replay cache makes each hid single
+// use; it has to be shared too for that to hold across instances.
+type sealedHandshakeStore struct {
+	keyRing session.TokenKeyRing
+	replay  ReplayCache
+}
+
+func NewSealedHandshakeStore(keyRing session.TokenKeyRing, replay ReplayCache) HandshakeStore {
+	return &sealedHandshakeStore{
+		keyRing: keyRing,
+		replay:  replay,
+	}
+}
+
+func (store *sealedHandshakeStore) Save(state *HandshakeState) (string, error) {
+	key, err := store.keyRing.Primary()
+	if err != nil {
+		return "", err
+	}
+	sealKey, err := deriveHandshakeKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	state.Id = uuid.NewString()
+	plaintext, err := json.Marshal(state)
+	if err != nil {
+		return "", err
+	}
+
+	header := sealedHandshakeVersion + "." + key.Id
+	sealed, err := masterkey.Seal(sealKey, plaintext, []byte(header))
+	if err != nil {
+		return "", err
+	}
+	return header + "." + base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+func (store *sealedHandshakeStore) Take(username string, handshakeId string) (*HandshakeState, error) {
+	state, err := store.open(handshakeId)
+	if err != nil {
+		return nil, err
+	}
+
+	if state.Username != username {
+		return nil, ErrHandshakeNotFound
+	}
+	if time.Now().After(state.ExpiresAt) {
+		return nil, ErrHandshakeExpired
+	}
+
+	fresh, err := store.replay.Claim(state.Id, state.ExpiresAt)
+	if err != nil {
+		return nil, err
+	}
+	if !fresh {
+		return nil, ErrHandshakeReplayed
+	}
+	return state, nil
+}
+
+// Handshakes sealed here can be verified by any instance, there is nothing
+// to wait for.
+func (store *sealedHandshakeStore) Pending() int {
+	return 0
+}
+
+func (store *sealedHandshakeStore) Close() {
+}
+
+func (store *sealedHandshakeStore) open(handshakeId string) (*HandshakeState, error) {
+	parts := strings.Split(handshakeId, ".")
+	if len(parts) != 3 || parts[0] != sealedHandshakeVersion {
+		return nil, ErrMalformedHandshake
+	}
+
+	key := store.keyRing.Lookup(parts[1])
+	if key == nil {
+		return nil, ErrHandshakeNotFound
+	}
+	sealKey, err := deriveHandshakeKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrMalformedHandshake
+	}
+
+	plaintext, err := masterkey.Open(sealKey, sealed, []byte(parts[0]+"."+parts[1]))
+	if err != nil {
+		return nil, ErrHandshakeNotFound
+	}
+
+	var state HandshakeState
+	if err = json.Unmarshal(plaintext, &state); err != nil {
+		return nil, ErrMalformedHandshake
+	}
+	return &state, nil
+}
+
+// Session tokens are sealed under the key itself, handshake state under a
+// subkey so the two can never be confused for one another.
+func deriveHandshakeKey(key *session.TokenKey) ([]byte, error) {
+	return hkdf.Key(sha256.New, key.Key, nil, sealedHandshakeKeyInfo, masterkey.KeySize)
+}
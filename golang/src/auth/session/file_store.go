@@ -0,0 +1,211 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/logging"
+	"time"
+)
+
+const sessionDataVersion = 1
+
+type sessionFile struct {
+	Version  int                 `json:"version"`
+	Sessions []sessionFileRecord `json:"sessions"`
+}
+
+// Only what is needed to expire records without the master key is kept in
+// the clear, the session id and derived key are sealed.
+type sessionFileRecord struct {
+	Username  string    `json:"username"`
+	ExpiresAt time.Time `json:"expiresAt"`
+	Sealed    []byte    `json:"sealed"`
+}
+
+type sealedSession struct {
+	Id              string    `json:"id"`
+	Secret          []byte    `json:"secret"`
+	CreatedAt       time.Time `json:"createdAt"`
+	AuthenticatedAt time.Time `json:"authenticatedAt"` // Zero in files written before reauthentication, read as CreatedAt
+}
+
+// fileStore keeps every session in memory and rewrites the whole file on
+// each change. Changes are made to a copy that replaces the sessions in
+// memory only once it is saved, so memory never holds what the file lost.
+type fileStore struct {
+	*memoryStore
+	filePath  string
+	masterKey []byte
+}
+
+func NewFileStore(filePath string, masterKey []byte) (SessionStore, error) {
+	if len(masterKey) != masterkey.KeySize {
+		return nil, masterkey.ErrWrongKeySize
+	}
+
+	store := &fileStore{
+		memoryStore: NewMemoryStore().(*memoryStore),
+		filePath:    filePath,
+		masterKey:   masterKey,
+	}
+	if err := store.load(); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+func (store *fileStore) Put(record *SessionRecord) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	sessions := maps.Clone(store.sessions)
+	sessions[record.Id] = record
+	return store.commit(sessions)
+}
+
+func (store *fileStore) Delete(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if _, found := store.sessions[id]; !found {
+		return nil
+	}
+	sessions := maps.Clone(store.sessions)
+	delete(sessions, id)
+	return store.commit(sessions)
+}
+
+func (store *fileStore) Close() error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.save(store.sessions)
+}
+
+// Must be called with store.lock held.
+func (store *fileStore) commit(sessions map[string]*SessionRecord) error {
+	if err := store.save(sessions); err != nil {
+		return err
+	}
+	store.sessions = sessions
+	return nil
+}
+
+func (store *fileStore) load() error {
+	dat, err := os.ReadFile(store.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var container sessionFile
+	if err = json.Unmarshal(dat, &container); err != nil {
+		return err
+	}
+	if container.Version != sessionDataVersion {
+		return errors.New("session file has the wrong version")
+	}
+
+	now := time.Now()
+	discarded := 0
+	for _, fileRecord := range container.Sessions {
+		if expiredAt(fileRecord.ExpiresAt, now) {
+			discarded++
+			continue
+		}
+
+		record, err := store.open(fileRecord)
+		if err != nil {
+			return fmt.Errorf("failed to decrypt session file, wrong master key? %w", err)
+		}
+		store.sessions[record.Id] = record
+	}
+
+	logging.For(nil, "session").Info("Restored sessions", "count", len(store.sessions), "expired", discarded)
+	return nil
+}
+
+// Must be called with store.lock held.
+func (store *fileStore) save(sessions map[string]*SessionRecord) error {
+	now := time.Now()
+	container := sessionFile{
+		Version:  sessionDataVersion,
+		Sessions: []sessionFileRecord{},
+	}
+	for _, record := range sessions {
+		if record.isExpired(now) {
+			continue
+		}
+
+		fileRecord, err := store.seal(record)
+		if err != nil {
+			return err
+		}
+		container.Sessions = append(container.Sessions, fileRecord)
+	}
+
+	dat, err := json.Marshal(container)
+	if err != nil {
+		return err
+	}
+	return fileutil.WriteAtomic(store.filePath, dat, 0600)
+}
+
+func (store *fileStore) seal(record *SessionRecord) (sessionFileRecord, error) {
+	plaintext, err := json.Marshal(sealedSession{
+		Id:              record.Id,
+		Secret:          record.Secret,
+		CreatedAt:       record.CreatedAt,
+		AuthenticatedAt: record.AuthenticatedAt,
+	})
+	if err != nil {
+		return sessionFileRecord{}, err
+	}
+
+	sealed, err := masterkey.Seal(store.masterKey, plaintext, sealingContext(record.Username, record.ExpiresAt))
+	if err != nil {
+		return sessionFileRecord{}, err
+	}
+
+	return sessionFileRecord{
+		Username:  record.Username,
+		ExpiresAt: record.ExpiresAt,
+		Sealed:    sealed,
+	}, nil
+}
+
+func (store *fileStore) open(fileRecord sessionFileRecord) (*SessionRecord, error) {
+	plaintext, err := masterkey.Open(store.masterKey, fileRecord.Sealed, sealingContext(fileRecord.Username, fileRecord.ExpiresAt))
+	if err != nil {
+		return nil, err
+	}
+
+	var inner sealedSession
+	if err = json.Unmarshal(plaintext, &inner); err != nil {
+		return nil, err
+	}
+
+	if inner.AuthenticatedAt.IsZero() {
+		inner.AuthenticatedAt = inner.CreatedAt
+	}
+	return &SessionRecord{
+		Id:              inner.Id,
+		Username:        fileRecord.Username,
+		Secret:          inner.Secret,
+		CreatedAt:       inner.CreatedAt,
+		ExpiresAt:       fileRecord.ExpiresAt,
+		AuthenticatedAt: inner.AuthenticatedAt,
+	}, nil
+}
+
+// The clear fields are bound as additional data so they cannot be swapped
+// between records.
+func sealingContext(username string, expiresAt time.Time) []byte {
+	return []byte(fmt.Sprintf("srp-session:%s:%d", username, expiresAt.UnixNano()))
+}
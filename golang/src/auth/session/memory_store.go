@@ -0,0 +1,68 @@
+package session
+
+import (
+	"sort"
+	"sync"
+)
+
+type memoryStore struct {
+	sessions map[string]*SessionRecord
+	lock     sync.Mutex
+}
+
+func NewMemoryStore() SessionStore {
+	return &memoryStore{
+		sessions: make(map[string]*SessionRecord),
+	}
+}
+
+func (store *memoryStore) Put(record *SessionRecord) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	store.sessions[record.Id] = record
+	return nil
+}
+
+func (store *memoryStore) Get(id string) *SessionRecord {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return store.sessions[id]
+}
+
+func (store *memoryStore) Delete(id string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	delete(store.sessions, id)
+	return nil
+}
+
+func (store *memoryStore) ListByUser(username string) []*SessionRecord {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	records := []*SessionRecord{}
+	for _, record := range store.sessions {
+		if record.Username == username {
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].CreatedAt.Before(records[j].CreatedAt)
+	})
+	return records
+}
+
+func (store *memoryStore) Count() int {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	return len(store.sessions)
+}
+
+func (store *memoryStore) Close() error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"sharpstorm/srp-auth/logging"
+	"sync"
+	"time"
+)
+
+const revocationCheckPeriod = time.Second
+
+// RevocationList remembers revoked token ids until the token would have
+// expired anyway.
+type RevocationList interface {
+	Revoke(tokenId string, expiresAt time.Time) error
+	IsRevoked(tokenId string) bool
+	// Claim revokes the id unless it already was, reporting whether this
+	// call did. It is atomic across instances sharing a file.
+	Claim(tokenId string, expiresAt time.Time) (bool, error)
+	Close() error
+}
+
+type revocationList struct {
+	entries map[string]time.Time
+	logger  *slog.Logger
+	lock    sync.Mutex
+
+	// Only set for file backed lists
+	filePath string
+	reloader *fileutil.Reloader
+}
+
+// NewMemoryRevocationList is only suitable for a single instance, other
+// instances would keep accepting a token revoked here.
+func NewMemoryRevocationList() RevocationList {
+	return &revocationList{
+		entries: make(map[string]time.Time),
+		logger:  logging.For(nil, "session"),
+	}
+}
+
+// NewFileRevocationList shares revocations through a file that every
+// instance can read and write, such as one on a shared volume.
+func NewFileRevocationList(filePath string) (RevocationList, error) {
+	list := &revocationList{
+		entries:  make(map[string]time.Time),
+		filePath: filePath,
+		logger:   logging.For(nil, "session"),
+	}
+	list.reloader = fileutil.NewReloader(filePath, revocationCheckPeriod, list.reload)
+	if err := list.reloader.Load(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (list *revocationList) Revoke(tokenId string, expiresAt time.Time) error {
+	_, err := list.update(tokenId, expiresAt)
+	return err
+}
+
+func (list *revocationList) Claim(tokenId string, expiresAt time.Time) (bool, error) {
+	return list.update(tokenId, expiresAt)
+}
+
+// update adds the id, reporting false if it was already present.
+func (list *revocationList) update(tokenId string, expiresAt time.Time) (bool, error) {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	if list.filePath == "" {
+		list.prune(list.entries)
+		_, found := list.entries[tokenId]
+		list.entries[tokenId] = expiresAt
+		return !found, nil
+	}
+
+	unlock, err := fileutil.Lock(list.filePath + ".lock")
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	entries, err := list.read()
+	if err != nil {
+		return false, err
+	}
+	list.prune(entries)
+	if _, found := entries[tokenId]; found {
+		list.entries = entries
+		return false, nil
+	}
+	entries[tokenId] = expiresAt
+
+	dat, err := json.Marshal(entries)
+	if err != nil {
+		return false, err
+	}
+	if err = fileutil.WriteAtomic(list.filePath, dat, 0600); err != nil {
+		return false, err
+	}
+
+	list.entries = entries
+	list.reloader.MarkCurrent()
+	return true, nil
+}
+
+func (list *revocationList) IsRevoked(tokenId string) bool {
+	list.lock.Lock()
+	defer list.lock.Unlock()
+
+	list.refreshIfChanged()
+	_, found := list.entries[tokenId]
+	return found
+}
+
+func (list *revocationList) Close() error {
+	return nil
+}
+
+func (list *revocationList) refreshIfChanged() {
+	if list.reloader == nil {
+		return
+	}
+	if err := list.reloader.RefreshIfChanged(); err != nil {
+		list.logger.Warn("Failed to reload revocation list", "file", list.filePath, "err", err)
+	}
+}
+
+func (list *revocationList) reload() error {
+	entries, err := list.read()
+	if err != nil {
+		return err
+	}
+	list.entries = entries
+	return nil
+}
+
+func (list *revocationList) read() (map[string]time.Time, error) {
+	entries := make(map[string]time.Time)
+	dat, err := os.ReadFile(list.filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return entries, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = json.Unmarshal(dat, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+func (list *revocationList) prune(entries map[string]time.Time) {
+	now := time.Now()
+	for tokenId, expiresAt := range entries {
+		if now.After(expiresAt) {
+			delete(entries, tokenId)
+		}
+	}
+}
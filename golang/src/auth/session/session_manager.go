@@ -1,82 +1,247 @@
 package session
 
-import "log"
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/logging"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
 
 type Session struct {
-	Id     string
-	Secret []byte
+	Id        string
+	Secret    []byte
+	ExpiresAt time.Time
+	// AuthenticatedAt is when the user last proved their password for the
+	// session, at login or through Reauthenticate.
+	AuthenticatedAt time.Time
 }
 
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionExpired  = errors.New("session expired")
+	ErrSessionRevoked  = errors.New("session revoked")
+)
+
 type SessionManager interface {
 	IsActive(session string) bool
-	RegisterSession(username string, session string, secret []byte)
-	RemoveSession(session string)
-	GetSession(session string) (*Session, string)
+	// CheckSession returns nil for an active session and otherwise says why
+	// it is not, ErrSessionNotFound, ErrSessionExpired or ErrSessionRevoked.
+	CheckSession(session string) error
+	// CreateSession issues a new session id for an authenticated user. ctx
+	// only carries request scoped values such as the request id for logs.
+	CreateSession(ctx context.Context, username string, secret []byte) (string, error)
+	// Reauthenticate records that the user of an active session just proved
+	// their password again, replacing the session secret with the one of
+	// the new handshake. It returns the session id to use from now on,
+	// which changes for token sessions.
+	Reauthenticate(ctx context.Context, session string, secret []byte) (string, error)
+	// RemoveSession revokes a session. An error means the revocation was
+	// not stored and the session is still valid.
+	RemoveSession(ctx context.Context, session string) error
+	// GetSession returns an active session and its username in a single
+	// lookup, or the same errors as CheckSession.
+	GetSession(session string) (*Session, string, error)
+	Close() error
 }
 
+type SessionOptions struct {
+	MaxPerUser int
+	TTL        time.Duration // Zero means sessions never expire, token sessions require one
+	Audit      audit.Logger  // Nil records nothing
+	Logger     *slog.Logger  // Defaults to slog.Default()
+}
+
+const (
+	revokeLogout  = "logout"
+	revokeExpired = "expired"
+	revokeLimit   = "session limit"
+)
+
 type sessionManager struct {
-	sessions     map[string]string
-	userSessions map[string][]Session
+	store      SessionStore
+	maxPerUser int
+	ttl        time.Duration
+	audit      audit.Logger
+	logger     *slog.Logger
+	lock       sync.Mutex
 }
 
-func NewSessionManager() SessionManager {
+func NewSessionManager(store SessionStore, opts SessionOptions) SessionManager {
 	return &sessionManager{
-		sessions:     make(map[string]string),
-		userSessions: make(map[string][]Session),
+		store:      store,
+		maxPerUser: opts.MaxPerUser,
+		ttl:        opts.TTL,
+		audit:      opts.auditLogger(),
+		logger:     logging.For(opts.Logger, "session"),
 	}
 }
 
+func (opts SessionOptions) auditLogger() audit.Logger {
+	if opts.Audit == nil {
+		return audit.Discard
+	}
+	return opts.Audit
+}
+
 func (mgr *sessionManager) IsActive(session string) bool {
-	_, found := mgr.sessions[session]
-	return found
+	return mgr.CheckSession(session) == nil
 }
 
-func (mgr *sessionManager) GetSession(session string) (*Session, string) {
-	username, found := mgr.sessions[session]
-	if !found {
-		return nil, ""
+func (mgr *sessionManager) CheckSession(session string) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	_, err := mgr.lookup(session)
+	return err
+}
+
+// lookup treats expired sessions as absent and removes them on sight.
+// Revoked sessions are deleted from the store, so they are not found.
+func (mgr *sessionManager) lookup(session string) (*SessionRecord, error) {
+	record := mgr.store.Get(session)
+	if record == nil {
+		return nil, ErrSessionNotFound
 	}
 
-	userSessions := mgr.userSessions[username]
-	for _, userSession := range userSessions {
-		if userSession.Id == session {
-			return &userSession, username
-		}
+	if record.isExpired(time.Now()) {
+		// Expired either way, a failure only leaves the record for later
+		mgr.removeSession(context.Background(), session, revokeExpired)
+		return nil, ErrSessionExpired
 	}
-	return nil, ""
+	return record, nil
 }
 
-func (mgr *sessionManager) RegisterSession(username string, session string, secret []byte) {
-	if mgr.IsActive(session) {
-		return
+func (mgr *sessionManager) GetSession(session string) (*Session, string, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	record, err := mgr.lookup(session)
+	if err != nil {
+		return nil, "", err
 	}
 
-	userSessions, found := mgr.userSessions[username]
-	sessionObj := Session{
-		Id:     session,
-		Secret: secret,
+	return &Session{
+		Id:              record.Id,
+		Secret:          record.Secret,
+		ExpiresAt:       record.ExpiresAt,
+		AuthenticatedAt: record.AuthenticatedAt,
+	}, record.Username, nil
+}
+
+func (mgr *sessionManager) CreateSession(ctx context.Context, username string, secret []byte) (string, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	session := uuid.NewString()
+	now := time.Now()
+	userSessions := []*SessionRecord{}
+	for _, record := range mgr.store.ListByUser(username) {
+		if record.isExpired(now) {
+			mgr.removeSession(ctx, record.Id, revokeExpired)
+		} else {
+			userSessions = append(userSessions, record)
+		}
 	}
-	if found {
-		newSessions := userSessions
-		if len(userSessions) >= 3 {
-			toRevoke := userSessions[0]
-			newSessions = userSessions[1:]
-			mgr.RemoveSession(toRevoke.Id)
+	for len(userSessions) >= mgr.maxPerUser {
+		if err := mgr.removeSession(ctx, userSessions[0].Id, revokeLimit); err != nil {
+			return "", err
 		}
-		newSessions = append(newSessions, sessionObj)
-		mgr.userSessions[username] = newSessions
-	} else {
-		mgr.userSessions[username] = []Session{sessionObj}
+		userSessions = userSessions[1:]
+	}
+
+	record := &SessionRecord{
+		Id:              session,
+		Username:        username,
+		Secret:          secret,
+		CreatedAt:       now,
+		AuthenticatedAt: now,
 	}
-	mgr.sessions[session] = username
-	log.Printf("[Session Control] Issued Key: %s\n", session)
+	if mgr.ttl > 0 {
+		record.ExpiresAt = now.Add(mgr.ttl)
+	}
+
+	if err := mgr.store.Put(record); err != nil {
+		mgr.logger.ErrorContext(ctx, "Failed to persist session", "err", err)
+		return "", err
+	}
+	mgr.logger.InfoContext(ctx, "Issued session", "user", username, "session_ref", audit.SessionRef(session))
+	mgr.audit.Record(audit.Event{
+		Type:      audit.SessionIssue,
+		Username:  username,
+		Session:   audit.SessionRef(session),
+		RequestId: logging.RequestId(ctx),
+	})
+	return session, nil
+}
+
+func (mgr *sessionManager) Reauthenticate(ctx context.Context, session string, secret []byte) (string, error) {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	record, err := mgr.lookup(session)
+	if err != nil {
+		return "", err
+	}
+
+	updated := *record
+	updated.Secret = secret
+	updated.AuthenticatedAt = time.Now()
+	if err = mgr.store.Put(&updated); err != nil {
+		mgr.logger.ErrorContext(ctx, "Failed to persist session", "err", err)
+		return "", err
+	}
+	mgr.logger.InfoContext(ctx, "Reauthenticated session", "user", record.Username, "session_ref", audit.SessionRef(session))
+	mgr.audit.Record(audit.Event{
+		Type:      audit.SessionReauth,
+		Username:  record.Username,
+		Session:   audit.SessionRef(session),
+		RequestId: logging.RequestId(ctx),
+	})
+	return session, nil
 }
 
-func (mgr *sessionManager) RemoveSession(session string) {
-	if !mgr.IsActive(session) {
-		return
+func (mgr *sessionManager) RemoveSession(ctx context.Context, session string) error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
+
+	return mgr.removeSession(ctx, session, revokeLogout)
+}
+
+func (mgr *sessionManager) removeSession(ctx context.Context, session string, reason string) error {
+	record := mgr.store.Get(session)
+	if record == nil {
+		return nil
+	}
+
+	if err := mgr.store.Delete(session); err != nil {
+		mgr.logger.ErrorContext(ctx, "Failed to persist revocation", "err", err)
+		return err
 	}
+	mgr.logger.InfoContext(ctx, "Revoked session", "user", record.Username, "session_ref", audit.SessionRef(session), "reason", reason)
+	mgr.audit.Record(audit.Event{
+		Type:      audit.SessionRevoke,
+		Username:  record.Username,
+		Session:   audit.SessionRef(session),
+		Reason:    reason,
+		RequestId: logging.RequestId(ctx),
+	})
+	return nil
+}
+
+// ActiveSessions is only offered by the stateful manager, tokens are not
+// tracked anywhere.
+func (mgr *sessionManager) ActiveSessions() int {
+	return mgr.store.Count()
+}
+
+func (mgr *sessionManager) Close() error {
+	mgr.lock.Lock()
+	defer mgr.lock.Unlock()
 
-	delete(mgr.sessions, session)
-	log.Printf("[Session Control] Revoked Key: %s\n", session)
+	return mgr.store.Close()
 }
@@ -0,0 +1,34 @@
+package session
+
+import "time"
+
+type SessionRecord struct {
+	Id              string
+	Username        string
+	Secret          []byte
+	CreatedAt       time.Time
+	ExpiresAt       time.Time
+	AuthenticatedAt time.Time
+}
+
+// SessionStore holds the sessions behind a SessionManager. The manager
+// enforces expiry and per-user limits, stores only keep records.
+type SessionStore interface {
+	Put(record *SessionRecord) error
+	Get(id string) *SessionRecord
+	Delete(id string) error
+	// ListByUser returns the user's sessions, oldest first.
+	ListByUser(username string) []*SessionRecord
+	// Count includes expired sessions that have not been removed yet.
+	Count() int
+	Close() error
+}
+
+func (record *SessionRecord) isExpired(now time.Time) bool {
+	return expiredAt(record.ExpiresAt, now)
+}
+
+// A zero expiry never expires.
+func expiredAt(expiresAt time.Time, now time.Time) bool {
+	return !expiresAt.IsZero() && !now.Before(expiresAt)
+}
@@ -0,0 +1,46 @@
+package session
+
+import (
+	"sharpstorm/srp-auth/auth/keyfile"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/logging"
+)
+
+// TokenKeys are also used for the sealed handshake store, files written
+// before key files recorded their kind are token key files.
+var TokenKeys = keyfile.Kind{
+	Name:    "token",
+	KeySize: masterkey.KeySize,
+	Untyped: true,
+}
+
+type TokenKey = keyfile.Key
+
+// TokenKeyRing seals new tokens under its primary key and opens tokens
+// sealed under any key it still holds. Instances sharing the key file reload
+// it when it changes, so a rotation is picked up without a restart.
+type TokenKeyRing interface {
+	Primary() (*TokenKey, error)
+	Lookup(kid string) *TokenKey
+}
+
+func LoadTokenKeyRing(filePath string) (TokenKeyRing, error) {
+	return keyfile.Load(filePath, TokenKeys, logging.For(nil, "session"))
+}
+
+func ReadTokenKeys(filePath string) ([]*TokenKey, error) {
+	return keyfile.Read(filePath, TokenKeys)
+}
+
+// RotateTokenKeys adds a new primary key, creating the file if needed. Old
+// keys stay in the ring so tokens sealed under them remain valid until they
+// expire or the key is retired.
+func RotateTokenKeys(filePath string) (*TokenKey, error) {
+	return keyfile.Add(filePath, TokenKeys, true)
+}
+
+// RetireTokenKey removes a non-primary key. Tokens sealed under it stop
+// validating immediately.
+func RetireTokenKey(filePath string, kid string) error {
+	return keyfile.Retire(filePath, TokenKeys, kid, nil)
+}
@@ -0,0 +1,234 @@
+package session
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/logging"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const tokenVersion = "v1"
+
+var (
+	errMalformedToken = errors.New("malformed session token")
+	errUnknownKey     = errors.New("session token sealed under an unknown key")
+	errTokenTTL       = errors.New("session tokens need a positive TTL")
+)
+
+type tokenClaims struct {
+	Id        string `json:"jti"`
+	Username  string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+	AuthTime  int64  `json:"auth_time,omitempty"` // Last reauthentication, IssuedAt if unset
+	Secret    []byte `json:"key"`
+}
+
+// tokenSessionManager keeps no per-session state. The session id handed to
+// the client is the sealed token "v1.<kid>.<base64url ciphertext>", so any
+// instance holding the same key ring can validate it. Per-user session
+// limits cannot be enforced without shared state and are not applied.
+type tokenSessionManager struct {
+	keyRing TokenKeyRing
+	revoked RevocationList
+	ttl     time.Duration
+	audit   audit.Logger
+	logger  *slog.Logger
+}
+
+// NewTokenSessionManager needs a positive opts.TTL. Tokens always carry an
+// expiry, so unlike stored sessions they cannot live forever.
+func NewTokenSessionManager(keyRing TokenKeyRing, revoked RevocationList, opts SessionOptions) (SessionManager, error) {
+	if opts.TTL <= 0 {
+		return nil, errTokenTTL
+	}
+	return &tokenSessionManager{
+		keyRing: keyRing,
+		revoked: revoked,
+		ttl:     opts.TTL,
+		audit:   opts.auditLogger(),
+		logger:  logging.For(opts.Logger, "session"),
+	}, nil
+}
+
+func (mgr *tokenSessionManager) CreateSession(ctx context.Context, username string, secret []byte) (string, error) {
+	now := time.Now()
+	session, kid, err := mgr.seal(&tokenClaims{
+		Id:        uuid.NewString(),
+		Username:  username,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(mgr.ttl).Unix(),
+		Secret:    secret,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	mgr.logger.InfoContext(ctx, "Issued session token", "user", username, "session_ref", audit.SessionRef(session), "kid", kid)
+	mgr.audit.Record(audit.Event{
+		Type:      audit.SessionIssue,
+		Username:  username,
+		Session:   audit.SessionRef(session),
+		RequestId: logging.RequestId(ctx),
+	})
+	return session, nil
+}
+
+func (mgr *tokenSessionManager) IsActive(session string) bool {
+	return mgr.CheckSession(session) == nil
+}
+
+func (mgr *tokenSessionManager) CheckSession(session string) error {
+	_, err := mgr.lookup(session)
+	return err
+}
+
+// lookup reports tokens that cannot be opened as not found, whatever the
+// reason.
+func (mgr *tokenSessionManager) lookup(session string) (*tokenClaims, error) {
+	claims, err := mgr.validate(session)
+	if err != nil && !errors.Is(err, ErrSessionExpired) && !errors.Is(err, ErrSessionRevoked) {
+		return nil, ErrSessionNotFound
+	}
+	return claims, err
+}
+
+func (mgr *tokenSessionManager) GetSession(session string) (*Session, string, error) {
+	claims, err := mgr.lookup(session)
+	if err != nil {
+		return nil, "", err
+	}
+
+	authTime := claims.AuthTime
+	if authTime == 0 {
+		authTime = claims.IssuedAt
+	}
+	return &Session{
+		Id:              session,
+		Secret:          claims.Secret,
+		ExpiresAt:       time.Unix(claims.ExpiresAt, 0),
+		AuthenticatedAt: time.Unix(authTime, 0),
+	}, claims.Username, nil
+}
+
+// Reauthenticate issues a token with the same id and expiry, the new secret
+// and a new auth time. The old token stays valid with the old secret, but
+// no longer counts as recently authenticated, and revoking either revokes
+// both.
+func (mgr *tokenSessionManager) Reauthenticate(ctx context.Context, session string, secret []byte) (string, error) {
+	claims, err := mgr.validate(session)
+	if err != nil {
+		return "", err
+	}
+
+	claims.Secret = secret
+	claims.AuthTime = time.Now().Unix()
+	reissued, kid, err := mgr.seal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	mgr.logger.InfoContext(ctx, "Reauthenticated session token", "user", claims.Username, "session_ref", audit.SessionRef(reissued), "kid", kid)
+	mgr.audit.Record(audit.Event{
+		Type:      audit.SessionReauth,
+		Username:  claims.Username,
+		Session:   audit.SessionRef(reissued),
+		RequestId: logging.RequestId(ctx),
+	})
+	return reissued, nil
+}
+
+func (mgr *tokenSessionManager) RemoveSession(ctx context.Context, session string) error {
+	claims, err := mgr.open(session)
+	if err != nil {
+		return nil
+	}
+
+	if err = mgr.revoked.Revoke(claims.Id, time.Unix(claims.ExpiresAt, 0)); err != nil {
+		mgr.logger.ErrorContext(ctx, "Failed to revoke session token", "err", err)
+		return err
+	}
+	mgr.logger.InfoContext(ctx, "Revoked session token", "user", claims.Username, "session_ref", audit.SessionRef(session))
+	mgr.audit.Record(audit.Event{
+		Type:      audit.SessionRevoke,
+		Username:  claims.Username,
+		Session:   audit.SessionRef(session),
+		Reason:    revokeLogout,
+		RequestId: logging.RequestId(ctx),
+	})
+	return nil
+}
+
+func (mgr *tokenSessionManager) Close() error {
+	return mgr.revoked.Close()
+}
+
+func (mgr *tokenSessionManager) validate(session string) (*tokenClaims, error) {
+	claims, err := mgr.open(session)
+	if err != nil {
+		return nil, err
+	}
+
+	if !time.Now().Before(time.Unix(claims.ExpiresAt, 0)) {
+		return nil, ErrSessionExpired
+	}
+	if mgr.revoked.IsRevoked(claims.Id) {
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
+
+func (mgr *tokenSessionManager) seal(claims *tokenClaims) (string, string, error) {
+	key, err := mgr.keyRing.Primary()
+	if err != nil {
+		return "", "", err
+	}
+
+	plaintext, err := json.Marshal(claims)
+	if err != nil {
+		return "", "", err
+	}
+
+	header := tokenVersion + "." + key.Id
+	sealed, err := masterkey.Seal(key.Key, plaintext, []byte(header))
+	if err != nil {
+		return "", "", err
+	}
+	return header + "." + base64.RawURLEncoding.EncodeToString(sealed), key.Id, nil
+}
+
+func (mgr *tokenSessionManager) open(session string) (*tokenClaims, error) {
+	parts := strings.Split(session, ".")
+	if len(parts) != 3 || parts[0] != tokenVersion {
+		return nil, errMalformedToken
+	}
+
+	key := mgr.keyRing.Lookup(parts[1])
+	if key == nil {
+		return nil, errUnknownKey
+	}
+
+	sealed, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errMalformedToken
+	}
+
+	plaintext, err := masterkey.Open(key.Key, sealed, []byte(parts[0]+"."+parts[1]))
+	if err != nil {
+		return nil, err
+	}
+
+	var claims tokenClaims
+	if err = json.Unmarshal(plaintext, &claims); err != nil {
+		return nil, errMalformedToken
+	}
+	return &claims, nil
+}
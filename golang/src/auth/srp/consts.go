@@ -3,19 +3,13 @@ package srp
 import "math/big"
 
 type ConstantGroup struct {
-	N big.Int
-	G big.Int
+	Name string
+	N    big.Int
+	G    big.Int
 }
 
-type HashType int
-
-const (
-	SHA1 HashType = iota
-	SHA256
-	SHA512
-)
-
 var GROUP_1024 = ConstantGroup{
+	Name: "1024",
 	N: *MustHex2BigInt(`
 	EEAF0AB9 ADB38DD6 9C33F80A FA8FC5E8 60726187 75FF3C0B 9EA2314C
 	9C256576 D674DF74 96EA81D3 383B4813 D692C6E0 E0D5D8E2 50B98BE4
@@ -26,6 +20,7 @@ var GROUP_1024 = ConstantGroup{
 }
 
 var GROUP_1536 = ConstantGroup{
+	Name: "1536",
 	N: *MustHex2BigInt(`
 9DEF3CAF B939277A B1F12A86 17A47BBB DBA51DF4 99AC4C80 BEEEA961
 4B19CC4D 5F4F5F55 6E27CBDE 51C6A94B E4607A29 1558903B A0D0F843
@@ -38,6 +33,7 @@ F7CCB7AE 837C264A E3A9BEB8 7F8A2FE9 B8B5292E 5A021FFF 5E91479E
 }
 
 var GROUP_2048 = ConstantGroup{
+	Name: "2048",
 	N: *MustHex2BigInt(`
 AC6BDB41 324A9A9B F166DE5E 1389582F AF72B665 1987EE07 FC319294
 3DB56050 A37329CB B4A099ED 8193E075 7767A13D D52312AB 4B03310D
@@ -53,6 +49,7 @@ D5FAAAE8 2918A996 2F0B93B8 55F97993 EC975EEA A80D740A DBF4FF74
 }
 
 var GROUP_3072 = ConstantGroup{
+	Name: "3072",
 	N: *MustHex2BigInt(`
 FFFFFFFF FFFFFFFF C90FDAA2 2168C234 C4C6628B 80DC1CD1 29024E08
 8A67CC74 020BBEA6 3B139B22 514A0879 8E3404DD EF9519B3 CD3A431B
@@ -72,6 +69,7 @@ E0FD108E 4B82D120 A93AD2CA FFFFFFFF FFFFFFFF`),
 }
 
 var GROUP_4096 = ConstantGroup{
+	Name: "4096",
 	N: *MustHex2BigInt(`
 FFFFFFFF FFFFFFFF C90FDAA2 2168C234 C4C6628B 80DC1CD1 29024E08
 8A67CC74 020BBEA6 3B139B22 514A0879 8E3404DD EF9519B3 CD3A431B
@@ -96,6 +94,7 @@ FFFFFFFF FFFFFFFF`),
 }
 
 var GROUP_6144 = ConstantGroup{
+	Name: "6144",
 	N: *MustHex2BigInt(`
 FFFFFFFF FFFFFFFF C90FDAA2 2168C234 C4C6628B 80DC1CD1 29024E08
 8A67CC74 020BBEA6 3B139B22 514A0879 8E3404DD EF9519B3 CD3A431B
@@ -129,6 +128,7 @@ B7C5DA76 F550AA3D 8A1FBFF0 EB19CCB1 A313D55C DA56C9EC 2EF29632
 }
 
 var GROUP_8192 = ConstantGroup{
+	Name: "8192",
 	N: *MustHex2BigInt(`
 FFFFFFFF FFFFFFFF C90FDAA2 2168C234 C4C6628B 80DC1CD1 29024E08
 8A67CC74 020BBEA6 3B139B22 514A0879 8E3404DD EF9519B3 CD3A431B
@@ -170,6 +170,29 @@ FC026E47 9558E447 5677E9AA 9E3050E2 765694DF C81F56E8 80B96E71
 	G: *big.NewInt(19),
 }
 
+var builtinGroups = []*ConstantGroup{
+	&GROUP_1024,
+	&GROUP_1536,
+	&GROUP_2048,
+	&GROUP_3072,
+	&GROUP_4096,
+	&GROUP_6144,
+	&GROUP_8192,
+}
+
+func BuiltinGroups() []*ConstantGroup {
+	return builtinGroups
+}
+
+func GetBuiltinGroup(name string) *ConstantGroup {
+	for _, group := range builtinGroups {
+		if group.Name == name {
+			return group
+		}
+	}
+	return nil
+}
+
 func (constGrp *ConstantGroup) NByteLen() int {
 	return constGrp.N.BitLen() >> 3
 }
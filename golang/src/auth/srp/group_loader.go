@@ -0,0 +1,80 @@
+package srp
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+type groupFile struct {
+	Groups []groupDefinition `json:"groups"`
+}
+
+type groupDefinition struct {
+	Id string `json:"id"`
+	N  string `json:"n"`
+	G  string `json:"g"`
+}
+
+// LoadGroupFile reads custom group definitions from a JSON file of the form
+// {"groups": [{"id": "...", "n": "<hex>", "g": "<hex>"}]}.
+// Groups are parsed but not validated, see ValidateGroup.
+func LoadGroupFile(filePath string) ([]*ConstantGroup, error) {
+	dat, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	var container groupFile
+	err = json.Unmarshal(dat, &container)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	groups := make([]*ConstantGroup, 0, len(container.Groups))
+	for idx, def := range container.Groups {
+		group, err := def.toGroup()
+		if err != nil {
+			return nil, fmt.Errorf("group %d: %w", idx, err)
+		}
+		if seen[group.Name] {
+			return nil, fmt.Errorf("group %d: duplicate id %q", idx, group.Name)
+		}
+		seen[group.Name] = true
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+func (def *groupDefinition) toGroup() (*ConstantGroup, error) {
+	if def.Id == "" {
+		return nil, errors.New("missing id")
+	}
+	if GetBuiltinGroup(def.Id) != nil {
+		return nil, fmt.Errorf("id %q clashes with a builtin group", def.Id)
+	}
+
+	n, ok := parseGroupHex(def.N)
+	if !ok {
+		return nil, errors.New("invalid N")
+	}
+	g, ok := parseGroupHex(def.G)
+	if !ok {
+		return nil, errors.New("invalid g")
+	}
+
+	return &ConstantGroup{
+		Name: def.Id,
+		N:    *n,
+		G:    *g,
+	}, nil
+}
+
+// Unlike Hex2BigInt, odd length values such as a bare "2" for g are accepted.
+func parseGroupHex(input string) (*big.Int, bool) {
+	return big.NewInt(0).SetString(trimRE.ReplaceAllLiteralString(input, ""), 16)
+}
@@ -0,0 +1,66 @@
+package srp
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeGroupFile(t *testing.T, content string) string {
+	t.Helper()
+	filePath := filepath.Join(t.TempDir(), "groups.json")
+	if err := os.WriteFile(filePath, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return filePath
+}
+
+func TestLoadGroupFile(t *testing.T) {
+	n := GROUP_2048.N.Text(16)
+	// Whitespace and newlines in N are ignored, g may be a single digit
+	spaced := n[:64] + "\n  " + n[64:]
+	filePath := writeGroupFile(t, `{"groups": [{"id": "custom", "n": "`+strings.ReplaceAll(spaced, "\n", `\n`)+`", "g": "2"}]}`)
+
+	groups, err := LoadGroupFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 1 || groups[0].Name != "custom" {
+		t.Fatalf("got %v", groups)
+	}
+	if groups[0].N.Cmp(&GROUP_2048.N) != 0 || groups[0].G.Int64() != 2 {
+		t.Error("N or g not parsed")
+	}
+	if err = ValidateGroup(groups[0]); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLoadGroupFileErrors(t *testing.T) {
+	n := GROUP_2048.N.Text(16)
+	tests := map[string]struct {
+		content string
+		want    string
+	}{
+		"not json":     {`{"groups": [`, "unexpected end"},
+		"missing id":   {`{"groups": [{"n": "` + n + `", "g": "2"}]}`, "group 0: missing id"},
+		"builtin id":   {`{"groups": [{"id": "2048", "n": "` + n + `", "g": "2"}]}`, `group 0: id "2048" clashes with a builtin group`},
+		"invalid N":    {`{"groups": [{"id": "a", "n": "xyz", "g": "2"}]}`, "group 0: invalid N"},
+		"invalid g":    {`{"groups": [{"id": "a", "n": "` + n + `", "g": ""}]}`, "group 0: invalid g"},
+		"duplicate id": {`{"groups": [{"id": "a", "n": "` + n + `", "g": "2"}, {"id": "a", "n": "` + n + `", "g": "5"}]}`, `group 1: duplicate id "a"`},
+		"later group":  {`{"groups": [{"id": "a", "n": "` + n + `", "g": "2"}, {"id": "", "n": "", "g": ""}]}`, "group 1: missing id"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadGroupFile(writeGroupFile(t, test.content))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got %v, want %q", err, test.want)
+			}
+		})
+	}
+
+	if _, err := LoadGroupFile(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
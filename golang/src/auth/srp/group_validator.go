@@ -0,0 +1,95 @@
+package srp
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+)
+
+const (
+	MinGroupBits     = 1024
+	primalityRounds  = 4
+	validationKeyLen = sha256.Size
+)
+
+var (
+	errGroupTooSmall  = fmt.Errorf("N must be at least %d bits", MinGroupBits)
+	errGroupUnaligned = errors.New("N must be a whole number of bytes long")
+	errGroupNotPrime  = errors.New("N is not prime")
+	errGroupNotSafe   = errors.New("N is not a safe prime, (N-1)/2 is not prime")
+	errGeneratorRange = errors.New("g must lie in [2, N-2]")
+)
+
+type validationCache struct {
+	lock    sync.Mutex
+	results map[[validationKeyLen]byte]error
+}
+
+var groupValidationCache = &validationCache{
+	results: make(map[[validationKeyLen]byte]error),
+}
+
+// ValidateGroup checks that N is a safe prime of at least MinGroupBits,
+// padded and hashed as whole bytes, and that g lies in [2, N-2]. For a safe
+// prime every such g has order (N-1)/2 or N-1, so the discrete log is as
+// hard as N allows. The RFC 5054 groups use generators of the full group,
+// so membership of the order (N-1)/2 subgroup is not required. Primality
+// testing of large groups is slow, so results are cached per (N, g).
+func ValidateGroup(group *ConstantGroup) error {
+	key := groupValidationCache.keyFor(group)
+	if found, err := groupValidationCache.get(key); found {
+		return err
+	}
+
+	err := validateGroup(group)
+	groupValidationCache.put(key, err)
+	return err
+}
+
+func validateGroup(group *ConstantGroup) error {
+	N := &group.N
+	g := &group.G
+
+	if N.BitLen() < MinGroupBits {
+		return errGroupTooSmall
+	}
+	if N.BitLen()%8 != 0 {
+		return errGroupUnaligned
+	}
+	if N.Bit(0) == 0 || !N.ProbablyPrime(primalityRounds) {
+		return errGroupNotPrime
+	}
+
+	q := big.NewInt(0).Rsh(N, 1)
+	if !q.ProbablyPrime(primalityRounds) {
+		return errGroupNotSafe
+	}
+
+	nMinusOne := big.NewInt(0).Sub(N, big.NewInt(1))
+	if g.Cmp(big.NewInt(2)) < 0 || g.Cmp(nMinusOne) >= 0 {
+		return errGeneratorRange
+	}
+
+	return nil
+}
+
+func (cache *validationCache) keyFor(group *ConstantGroup) [validationKeyLen]byte {
+	return sha256.Sum256(append(pad(group.NByteLen()+1, group.N.Bytes()), group.G.Bytes()...))
+}
+
+func (cache *validationCache) get(key [validationKeyLen]byte) (bool, error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	err, found := cache.results[key]
+	return found, err
+}
+
+func (cache *validationCache) put(key [validationKeyLen]byte, err error) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	cache.results[key] = err
+}
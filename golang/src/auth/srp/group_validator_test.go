@@ -0,0 +1,90 @@
+package srp
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+func TestValidateBuiltinGroups(t *testing.T) {
+	for _, group := range BuiltinGroups() {
+		t.Run(group.Name, func(t *testing.T) {
+			if err := ValidateGroup(group); err != nil {
+				t.Errorf("builtin group rejected: %s", err)
+			}
+		})
+	}
+}
+
+// primeNotSafe returns the first prime above 2^(bits-1) for which (N-1)/2
+// is not prime.
+func primeNotSafe(bits int) *big.Int {
+	N := big.NewInt(0).Lsh(big.NewInt(1), uint(bits-1))
+	N.Add(N, big.NewInt(1))
+	for {
+		if N.ProbablyPrime(primalityRounds) && !big.NewInt(0).Rsh(N, 1).ProbablyPrime(primalityRounds) {
+			return N
+		}
+		N.Add(N, big.NewInt(2))
+	}
+}
+
+func TestValidateGroup(t *testing.T) {
+	N := &GROUP_2048.N
+	nMinus := func(d int64) *big.Int { return big.NewInt(0).Sub(N, big.NewInt(d)) }
+
+	// 2^1023 + 1 is divisible by 3
+	composite := big.NewInt(0).Lsh(big.NewInt(1), 1023)
+	composite.Add(composite, big.NewInt(1))
+	// One bit more than 1024, the other checks would pass for a prime
+	unaligned := big.NewInt(0).Lsh(big.NewInt(1), 1024)
+	unaligned.Add(unaligned, big.NewInt(1))
+
+	tests := []struct {
+		name string
+		N    *big.Int
+		g    *big.Int
+		want error
+	}{
+		{"g 2", N, big.NewInt(2), nil},
+		{"g N-2", N, nMinus(2), nil},
+		{"g 0", N, big.NewInt(0), errGeneratorRange},
+		{"g 1", N, big.NewInt(1), errGeneratorRange},
+		{"g N-1", N, nMinus(1), errGeneratorRange},
+		{"g N", N, big.NewInt(0).Set(N), errGeneratorRange},
+		{"composite", composite, big.NewInt(2), errGroupNotPrime},
+		{"even", nMinus(1), big.NewInt(2), errGroupNotPrime},
+		{"prime not safe", primeNotSafe(1024), big.NewInt(2), errGroupNotSafe},
+		{"below minimum", big.NewInt(23), big.NewInt(5), errGroupTooSmall},
+		{"below minimum by a byte", big.NewInt(0).Rsh(N, 1032), big.NewInt(2), errGroupTooSmall},
+		{"not whole bytes", unaligned, big.NewInt(2), errGroupUnaligned},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			group := &ConstantGroup{Name: test.name, N: *test.N, G: *test.g}
+			if err := ValidateGroup(group); !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
+
+// The cache is keyed by N and g, a cached result for one generator must not
+// be returned for another.
+func TestValidateGroupCache(t *testing.T) {
+	good := &ConstantGroup{Name: "good", N: GROUP_1536.N, G: *big.NewInt(3)}
+	bad := &ConstantGroup{Name: "bad", N: GROUP_1536.N, G: *big.NewInt(1)}
+
+	for range 2 {
+		if err := ValidateGroup(good); err != nil {
+			t.Errorf("good group: %v", err)
+		}
+		if err := ValidateGroup(bad); !errors.Is(err, errGeneratorRange) {
+			t.Errorf("bad group: got %v, want %v", err, errGeneratorRange)
+		}
+	}
+
+	if found, err := groupValidationCache.get(groupValidationCache.keyFor(bad)); !found || !errors.Is(err, errGeneratorRange) {
+		t.Errorf("bad group cached as %v, %v", found, err)
+	}
+}
@@ -0,0 +1,99 @@
+package srp
+
+import (
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha3"
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+)
+
+type HashType int
+
+// Values are persisted by name, not by number, so new entries may be added
+// anywhere but names must never change.
+const (
+	SHA1 HashType = iota
+	SHA256
+	SHA512
+	SHA384
+	SHA512_256
+	SHA3_256
+	SHA3_512
+)
+
+var ErrUnknownHash = errors.New("unknown hash type")
+
+type hashInfo struct {
+	name string
+	impl crypto.Hash
+}
+
+var hashRegistry = map[HashType]hashInfo{
+	SHA1:       {name: "SHA-1", impl: crypto.SHA1},
+	SHA256:     {name: "SHA-256", impl: crypto.SHA256},
+	SHA384:     {name: "SHA-384", impl: crypto.SHA384},
+	SHA512:     {name: "SHA-512", impl: crypto.SHA512},
+	SHA512_256: {name: "SHA-512/256", impl: crypto.SHA512_256},
+	SHA3_256:   {name: "SHA3-256", impl: crypto.SHA3_256},
+	SHA3_512:   {name: "SHA3-512", impl: crypto.SHA3_512},
+}
+
+func HashTypeByName(name string) (HashType, error) {
+	for hashType, info := range hashRegistry {
+		if info.name == name {
+			return hashType, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: %q", ErrUnknownHash, name)
+}
+
+func HashNames() []string {
+	names := make([]string, 0, len(hashRegistry))
+	for hashType := SHA1; hashType <= SHA3_512; hashType++ {
+		names = append(names, hashRegistry[hashType].name)
+	}
+	return names
+}
+
+func (hashType HashType) String() string {
+	info, found := hashRegistry[hashType]
+	if !found {
+		return fmt.Sprintf("HashType(%d)", int(hashType))
+	}
+	return info.name
+}
+
+func (hashType HashType) Validate() error {
+	info, found := hashRegistry[hashType]
+	if !found || !info.impl.Available() {
+		return fmt.Errorf("%w: %s", ErrUnknownHash, hashType)
+	}
+	return nil
+}
+
+func (hashType HashType) Size() int {
+	return hashRegistry[hashType].impl.Size()
+}
+
+// IsDigestSize reports whether some known hash has digests of size bytes.
+func IsDigestSize(size int) bool {
+	for _, info := range hashRegistry {
+		if info.impl.Size() == size {
+			return true
+		}
+	}
+	return false
+}
+
+func hash(hashType HashType, inputs ...[]byte) []byte {
+	h := hashRegistry[hashType].impl.New()
+
+	for ix := range inputs {
+		h.Write(inputs[ix])
+	}
+
+	return h.Sum(nil)
+}
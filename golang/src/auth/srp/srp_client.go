@@ -0,0 +1,123 @@
+package srp
+
+import (
+	"crypto/subtle"
+	"errors"
+	"math/big"
+)
+
+// SRPClient is the client side of the exchange, for Go programs logging in
+// to the server. A only depends on the group, the hash and KDF are set
+// with the server's public key from the handshake response, which must
+// happen before anything else but PublicKey.
+type SRPClient interface {
+	PublicKey() []byte
+	SetServerPublicKey(hashType HashType, kdf string, salt []byte, B []byte) error
+	// BindChannel mixes the channel binding value into both proofs, see
+	// SRPVerifier.BindChannel.
+	BindChannel(binding []byte)
+	GetClientProof() []byte
+	IsServerProofValid(proof []byte) bool
+	GetSessionSecret() []byte
+}
+
+type srpClient struct {
+	group    *ConstantGroup
+	engine   SRPEngine
+	I        string
+	password string
+
+	a *big.Int // Client private key
+	A []byte   // Client public key
+	s []byte
+	B []byte
+
+	sessionSecret     []byte
+	sessionSecretHash []byte
+	binding           []byte
+	clientProof       []byte
+	serverProof       []byte
+}
+
+func NewSRPClient(group *ConstantGroup, username string, password string) (SRPClient, error) {
+	engine, err := NewSRPEngine(group, SHA512)
+	if err != nil {
+		return nil, err
+	}
+	secret := engine.RandomSalt()
+	if secret == nil {
+		return nil, errors.New("failed to generate ephemeral client secret a")
+	}
+
+	a := toBigInt(secret)
+	return &srpClient{
+		group:    group,
+		engine:   engine,
+		I:        username,
+		password: password,
+		a:        a,
+		A:        engine.ComputePow(a).Bytes(),
+	}, nil
+}
+
+func (client *srpClient) PublicKey() []byte {
+	return client.A
+}
+
+func (client *srpClient) SetServerPublicKey(hashType HashType, kdf string, salt []byte, B []byte) error {
+	engine, err := NewSRPEngine(client.group, hashType)
+	if err != nil {
+		return err
+	}
+	client.engine = engine
+
+	serverPublic := toBigInt(B)
+	// The client MUST abort the authentication attempt if B % N is zero.
+	if client.engine.ModN(serverPublic).Sign() == 0 {
+		return errors.New("aborted due to MOD 0")
+	}
+	u := toBigInt(client.engine.Hash(client.engine.Pad(client.A), client.engine.Pad(B)))
+	if u.Sign() == 0 {
+		return errors.New("aborted due to u = 0")
+	}
+
+	hashedCreds, err := client.engine.GetHashedCredsKDF(kdf, salt, client.I, client.password)
+	if err != nil {
+		return err
+	}
+	x := toBigInt(hashedCreds)
+
+	// S = (B - k * g^x) ^ (a + u * x) % N
+	base := big.NewInt(0).Mul(client.engine.GetK(), client.engine.ComputePow(x))
+	base = client.engine.ModN(base.Sub(serverPublic, base))
+	exponent := big.NewInt(0).Mul(u, x)
+	exponent = exponent.Add(exponent, client.a)
+
+	client.s = salt
+	client.B = B
+	client.sessionSecret = client.engine.ComputePow2(base, exponent).Bytes()
+	client.sessionSecretHash = client.engine.Hash(client.sessionSecret)
+	client.computeProofs()
+	return nil
+}
+
+func (client *srpClient) BindChannel(binding []byte) {
+	client.binding = binding
+	client.computeProofs()
+}
+
+func (client *srpClient) computeProofs() {
+	client.clientProof, client.serverProof = computeProofs(client.engine, client.I, client.s, client.A, client.B, client.sessionSecretHash, client.binding)
+}
+
+func (client *srpClient) GetClientProof() []byte {
+	return client.clientProof
+}
+
+func (client *srpClient) IsServerProofValid(proof []byte) bool {
+	return subtle.ConstantTimeCompare(proof, client.serverProof) == 1
+}
+
+func (client *srpClient) GetSessionSecret() []byte {
+	return client.sessionSecret
+}
@@ -1,14 +1,30 @@
 package srp
 
 import (
+	"fmt"
 	"math/big"
 )
 
+// How x is derived from the salt and credentials.
+const (
+	KDFDefault = ""        // x = H(s | I ":" P)
+	KDFRFC5054 = "rfc5054" // x = H(s | H(I ":" P)), as used by GnuTLS and OpenSSL
+)
+
+func ValidateKDF(kdf string) error {
+	if kdf != KDFDefault && kdf != KDFRFC5054 {
+		return fmt.Errorf("unknown kdf %q", kdf)
+	}
+	return nil
+}
+
 type SRPEngine interface {
 	Pad(input []byte) []byte
 	Hash(inputs ...[]byte) []byte
 	GetHashedCreds(salt []byte, username string, password string) []byte
 	GetVerifier(salt []byte, username string, password string) []byte
+	GetVerifierKDF(kdf string, salt []byte, username string, password string) ([]byte, error)
+	GetHashedCredsKDF(kdf string, salt []byte, username string, password string) ([]byte, error)
 	GetK() *big.Int
 	ComputePow(value *big.Int) *big.Int
 	ComputePow2(v1 *big.Int, v2 *big.Int) *big.Int
@@ -18,23 +34,31 @@ type SRPEngine interface {
 
 	NByteLen() int
 	RandomSalt() []byte
+	HashType() HashType
+	Group() *ConstantGroup
 }
 
 type srpEngine struct {
 	nByteLength int
 	hashType    HashType
+	group       *ConstantGroup
 
 	N *big.Int
 	g *big.Int
 }
 
-func NewSRPEngine(ivGroup *ConstantGroup, hashType HashType) SRPEngine {
+func NewSRPEngine(ivGroup *ConstantGroup, hashType HashType) (SRPEngine, error) {
+	if err := hashType.Validate(); err != nil {
+		return nil, err
+	}
+
 	return &srpEngine{
 		nByteLength: ivGroup.NByteLen(),
 		hashType:    hashType,
+		group:       ivGroup,
 		N:           &ivGroup.N,
 		g:           &ivGroup.G,
-	}
+	}, nil
 }
 
 func (engine *srpEngine) Pad(input []byte) []byte {
@@ -54,6 +78,29 @@ func (engine *srpEngine) GetVerifier(salt []byte, username string, password stri
 	return big.NewInt(0).Exp(engine.g, hashedCreds, engine.N).Bytes()
 }
 
+// GetVerifierKDF computes the verifier with x derived as named by kdf,
+// see ValidateKDF.
+func (engine *srpEngine) GetVerifierKDF(kdf string, salt []byte, username string, password string) ([]byte, error) {
+	hashedCreds, err := engine.GetHashedCredsKDF(kdf, salt, username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	return big.NewInt(0).Exp(engine.g, toBigInt(hashedCreds), engine.N).Bytes(), nil
+}
+
+// GetHashedCredsKDF derives x as named by kdf, see ValidateKDF.
+func (engine *srpEngine) GetHashedCredsKDF(kdf string, salt []byte, username string, password string) ([]byte, error) {
+	switch kdf {
+	case KDFDefault:
+		return engine.GetHashedCreds(salt, username, password), nil
+	case KDFRFC5054:
+		return engine.Hash(salt, engine.Hash(engine.representCredentials(username, password))), nil
+	default:
+		return nil, fmt.Errorf("unknown kdf %q", kdf)
+	}
+}
+
 func (engine *srpEngine) representCredentials(username string, password string) []byte {
 	return []byte(username + ":" + password)
 }
@@ -71,6 +118,14 @@ func (engine *srpEngine) RandomSalt() []byte {
 	return salt
 }
 
+func (engine *srpEngine) HashType() HashType {
+	return engine.hashType
+}
+
+func (engine *srpEngine) Group() *ConstantGroup {
+	return engine.group
+}
+
 func (engine *srpEngine) GetK() *big.Int {
 	nBytes := engine.N.Bytes()
 	gBytes := engine.g.Bytes()
@@ -18,8 +18,10 @@ type srpVerifier struct {
 	b *big.Int // Server private key
 	B *big.Int // Server public key
 
-	A *big.Int // Client public key
-	u *big.Int // Random scrambling parameter
+	A       *big.Int // Client public key
+	rawA    []byte   // Client public key as sent, hashed into the proofs
+	u       *big.Int // Random scrambling parameter
+	binding []byte   // Channel binding mixed into both proofs, if any
 
 	sessionSecret       []byte
 	sessionSecretHash   []byte
@@ -30,9 +32,15 @@ type srpVerifier struct {
 type SRPVerifier interface {
 	InitPublicKey() ([]byte, error)
 	SetClientPublicKey(A []byte) error
+	// BindChannel mixes a channel binding value, such as the TLS exporter,
+	// into the client and server proofs. Call it after SetClientPublicKey.
+	BindChannel(binding []byte)
 	IsClientProofValid(proof []byte) bool
 	GetSessionSecret() []byte
 	GetServerProof() []byte
+	// ExportEphemeral returns the server private key b so that the
+	// handshake can be resumed later, see RestoreVerifierFor.
+	ExportEphemeral() []byte
 
 	RandomSalt() []byte
 }
@@ -65,6 +73,7 @@ func (srp *srpVerifier) InitPublicKey() ([]byte, error) {
 
 func (srp *srpVerifier) SetClientPublicKey(A []byte) error {
 	srp.A = big.NewInt(0).SetBytes(A)
+	srp.rawA = A
 	srp.u = toBigInt(srp.engine.Hash(srp.engine.Pad(srp.A.Bytes()), srp.engine.Pad(srp.B.Bytes())))
 
 	// The host MUST abort the authentication attempt if A % N is zero.
@@ -79,18 +88,17 @@ func (srp *srpVerifier) SetClientPublicKey(A []byte) error {
 	srp.sessionSecret = srp.engine.ComputePow2(temp1, srp.b).Bytes()
 	srp.sessionSecretHash = srp.engine.Hash(srp.sessionSecret)
 
-	srp.expectedClientProof = srp.engine.Hash(
-		srp.engine.GetParamsHash(),
-		srp.engine.Hash([]byte(srp.I)),
-		srp.s,
-		A,
-		srp.B.Bytes(),
-		srp.sessionSecretHash,
-	)
+	srp.computeProofs()
+	return nil
+}
 
-	srp.serverProof = srp.engine.Hash(A, srp.expectedClientProof, srp.sessionSecretHash)
+func (srp *srpVerifier) BindChannel(binding []byte) {
+	srp.binding = binding
+	srp.computeProofs()
+}
 
-	return nil
+func (srp *srpVerifier) computeProofs() {
+	srp.expectedClientProof, srp.serverProof = computeProofs(srp.engine, srp.I, srp.s, srp.rawA, srp.B.Bytes(), srp.sessionSecretHash, srp.binding)
 }
 
 func (srp *srpVerifier) IsClientProofValid(proof []byte) bool {
@@ -105,6 +113,13 @@ func (srp *srpVerifier) GetServerProof() []byte {
 	return srp.serverProof
 }
 
+func (srp *srpVerifier) ExportEphemeral() []byte {
+	if srp.b == nil {
+		return nil
+	}
+	return srp.b.Bytes()
+}
+
 func (srp *srpVerifier) isModZero(value *big.Int) bool {
 	return srp.engine.ModN(value).Sign() == 0
 }
@@ -112,3 +127,19 @@ func (srp *srpVerifier) isModZero(value *big.Int) bool {
 func (srp *srpVerifier) RandomSalt() []byte {
 	return srp.engine.RandomSalt()
 }
+
+// computeProofs derives M1 and M2 as in RFC 5054, with the channel binding
+// appended to both hash inputs when one is set.
+func computeProofs(engine SRPEngine, I string, s []byte, A []byte, B []byte, K []byte, binding []byte) ([]byte, []byte) {
+	clientInputs := [][]byte{engine.GetParamsHash(), engine.Hash([]byte(I)), s, A, B, K}
+	if binding != nil {
+		clientInputs = append(clientInputs, binding)
+	}
+	clientProof := engine.Hash(clientInputs...)
+
+	serverInputs := [][]byte{A, clientProof, K}
+	if binding != nil {
+		serverInputs = append(serverInputs, binding)
+	}
+	return clientProof, engine.Hash(serverInputs...)
+}
@@ -1,17 +1,25 @@
 package srp
 
+import "errors"
+
 type srpVerifierFactory struct {
 	engine SRPEngine
 }
 
 type SRPVerifierFactory interface {
 	GetVerifierFor(username string, salt []byte, verifier []byte) SRPVerifier
+	RestoreVerifierFor(username string, salt []byte, verifier []byte, b []byte, A []byte) (SRPVerifier, error)
 }
 
-func NewSRPVerifierFactory(ivGroup *ConstantGroup, hashType HashType) SRPVerifierFactory {
-	return &srpVerifierFactory{
-		engine: NewSRPEngine(ivGroup, hashType),
+func NewSRPVerifierFactory(ivGroup *ConstantGroup, hashType HashType) (SRPVerifierFactory, error) {
+	engine, err := NewSRPEngine(ivGroup, hashType)
+	if err != nil {
+		return nil, err
 	}
+
+	return &srpVerifierFactory{
+		engine: engine,
+	}, nil
 }
 
 func NewSRPVerifierFactoryFromEngine(engine SRPEngine) SRPVerifierFactory {
@@ -26,3 +34,27 @@ func (factory *srpVerifierFactory) GetVerifierFor(
 	verifier []byte) SRPVerifier {
 	return newSRPVerifier(factory.engine, username, salt, verifier)
 }
+
+// RestoreVerifierFor rebuilds a verifier from a previously exported server
+// private key b and the client public key A, as if InitPublicKey and
+// SetClientPublicKey had been called on it.
+func (factory *srpVerifierFactory) RestoreVerifierFor(
+	username string,
+	salt []byte,
+	verifier []byte,
+	b []byte,
+	A []byte) (SRPVerifier, error) {
+	if len(b) == 0 {
+		return nil, errors.New("missing ephemeral server secret b")
+	}
+
+	restored := newSRPVerifier(factory.engine, username, salt, verifier).(*srpVerifier)
+	restored.b = toBigInt(b)
+	if _, err := restored.InitPublicKey(); err != nil {
+		return nil, err
+	}
+	if err := restored.SetClientPublicKey(A); err != nil {
+		return nil, err
+	}
+	return restored, nil
+}
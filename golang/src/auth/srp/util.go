@@ -1,7 +1,6 @@
 package srp
 
 import (
-	"crypto"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -42,29 +41,6 @@ func pad(size int, input []byte) []byte {
 	return out
 }
 
-func hash(hashType HashType, inputs ...[]byte) []byte {
-	h := newHash(hashType).New()
-
-	for ix := range inputs {
-		h.Write(inputs[ix])
-	}
-
-	return h.Sum(nil)
-}
-
-func newHash(hashType HashType) crypto.Hash {
-	switch hashType {
-	case SHA1:
-		return crypto.SHA1
-	case SHA256:
-		return crypto.SHA256
-	case SHA512:
-		return crypto.SHA512
-	default:
-		return 0
-	}
-}
-
 func RandomSalt(len int) ([]byte, error) {
 	out := make([]byte, len)
 	_, err := io.ReadFull(rand.Reader, out)
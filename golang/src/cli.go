@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(args []string) int
+}
+
+var commands = []command{
+	{name: "audit", usage: "audit verify [file]", run: runAuditCommand},
+	{name: "config", usage: "config check [file]", run: runConfigCommand},
+	{name: "credentials", usage: "credentials <encrypt|decrypt|rewrap|repepper|import|export> [flags]", run: runCredentialsCommand},
+	{name: "group", usage: "group validate <file>", run: runGroupCommand},
+	{name: "token-keys", usage: "token-keys <list|rotate|retire <kid>> [-file path]", run: runTokenKeysCommand},
+	{name: "pepper-keys", usage: "pepper-keys <list|rotate|retire <kid>>", run: runPepperKeysCommand},
+	{name: "identity-keys", usage: "identity-keys <list|add|promote <kid>|retire <kid>> [-file path]", run: runIdentityKeysCommand},
+	{name: "user", usage: "user <add|passwd|del|list|show|disable|enable|verify-password|legacy> [-json] [username]", run: runUserCommand},
+}
+
+func runCommand(name string, args []string) int {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd.run(args)
+		}
+	}
+
+	printUsage()
+	return 2
+}
+
+// parseFlags parses flags before and after the positional arguments, so
+// `user add alice -json` works like `user add -json alice`. Arguments after
+// "--" are never taken as flags.
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := []string{}
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		rest := flags.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if consumed := len(args) - len(rest); consumed > 0 && args[consumed-1] == "--" {
+			return append(positional, rest...), nil
+		}
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage:")
+	fmt.Fprintln(os.Stderr, "  srp-auth")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  srp-auth %s\n", cmd.usage)
+	}
+}
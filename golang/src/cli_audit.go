@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/config"
+)
+
+func runAuditCommand(args []string) int {
+	if len(args) < 1 || len(args) > 2 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "Usage: srp-auth audit verify [file]")
+		return 2
+	}
+
+	cfg, err := loadConfig(config.PathFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	key, err := cfg.AuditHMACKey()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "No audit key, set auditKey or auditKeyFile: %s\n", err)
+		return 2
+	}
+
+	filePath := cfg.AuditFile
+	if len(args) == 2 {
+		filePath = args[1]
+	} else if filePath == "" {
+		fmt.Fprintln(os.Stderr, "No audit file, set auditFile or pass a file")
+		return 2
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open %s: %s\n", filePath, err)
+		return 1
+	}
+	defer file.Close()
+
+	result, err := audit.Verify(file, key)
+	if err != nil {
+		fmt.Printf("%s: TAMPERED after %d good events, %s\n", filePath, result.Events, err)
+		return 1
+	}
+	fmt.Printf("%s: OK, %d events, last hash %s\n", filePath, result.Events, result.LastHash)
+	return 0
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/config"
+)
+
+func runConfigCommand(args []string) int {
+	if len(args) < 1 || len(args) > 2 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "Usage: srp-auth config check [file]")
+		return 2
+	}
+
+	filePath := config.PathFromEnv()
+	if len(args) == 2 {
+		filePath = args[1]
+	}
+
+	cfg, err := loadConfig(filePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	printable := *cfg
+	redact(&printable.SeedAdmin.Password)
+	redact(&printable.CredentialsMasterKey)
+	redact(&printable.CredentialsPreviousMasterKey)
+	redact(&printable.Session.MasterKey)
+	redact(&printable.AuditKey)
+	redact(&printable.DecoyKey)
+	out, _ := json.MarshalIndent(printable, "", "  ")
+	fmt.Println(string(out))
+	if err = cfg.ValidateServing(); err != nil {
+		fmt.Fprintf(os.Stderr, "Warning, the server would not start from this directory: %s\n", err)
+	}
+	fmt.Fprintln(os.Stderr, "Config OK")
+	return 0
+}
+
+func loadConfig(filePath string) (*config.Config, error) {
+	cfg, err := config.Load(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return cfg, nil
+}
+
+func redact(secret *string) {
+	if *secret != "" {
+		*secret = "<redacted>"
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/config"
+)
+
+const credentialsUsage = `Usage: srp-auth credentials <command> [flags]
+
+Commands:
+  encrypt   seal every record under a fresh data key wrapped by the master key
+  decrypt   write every record back in the clear
+  rewrap    [-new-key-file <file>] wrap the data key under the configured master
+            key, or under the key in -new-key-file, leaving records as they are
+  repepper  move every verifier onto the primary key of pepperFile
+  import    [-format tpasswd|srpvfile|legacy] [-conf tpasswd.conf] [-replace] <file>
+            add users from a GnuTLS tpasswd or OpenSSL srpvfile verifier file,
+            or from "username:hash" lines of bcrypt or PBKDF2 hashes
+  export    [-format tpasswd|srpvfile] [-conf tpasswd.conf] <file>
+            write the users such a file can hold, those imported from one
+
+The master key is credentialsMasterKey or credentialsMasterKeyFile from the
+active config. To rotate it online, make the new key the master key and the
+old one credentialsPreviousMasterKey, restart the servers, run rewrap, then
+drop the previous key. Run repepper after rotating the pepper key and before
+retiring the old one.`
+
+func runCredentialsCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, credentialsUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("credentials "+args[0], flag.ContinueOnError)
+	newKeyFile := flags.String("new-key-file", "", "master key file to rewrap under")
+	transfer := &transferOptions{}
+	flags.StringVar(&transfer.format, "format", formatTPasswd, "verifier file format, tpasswd, srpvfile or legacy")
+	flags.StringVar(&transfer.confPath, "conf", "", "tpasswd.conf, defaults to tpasswd.conf next to the tpasswd file")
+	flags.BoolVar(&transfer.replace, "replace", false, "overwrite existing users on import")
+	isTransfer := args[0] == "import" || args[0] == "export"
+	positional, err := parseFlags(flags, args[1:])
+	if err != nil || (isTransfer && len(positional) != 1) || (!isTransfer && len(positional) != 0) {
+		fmt.Fprintln(os.Stderr, credentialsUsage)
+		return 2
+	}
+
+	cfg, err := loadConfig(config.PathFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	switch args[0] {
+	case "repepper":
+		return repepperCredentials(cfg)
+	case "import", "export":
+		transfer.filePath = positional[0]
+		return transferCredentials(cfg, args[0], transfer)
+	}
+
+	masterKey, _ := cfg.CredentialsKey()
+	if masterKey == nil {
+		fmt.Fprintln(os.Stderr, "No master key, set credentialsMasterKey or credentialsMasterKeyFile")
+		return 2
+	}
+
+	switch {
+	case args[0] == "encrypt":
+		err = credentials.EncryptCredentialFile(cfg.CredentialsPath, masterKey)
+	case args[0] == "decrypt":
+		err = credentials.DecryptCredentialFile(cfg.CredentialsPath, masterKey)
+	case args[0] == "rewrap" && *newKeyFile != "":
+		var newKey []byte
+		if newKey, err = masterkey.LoadFile(*newKeyFile); err == nil {
+			err = credentials.RewrapCredentialFile(cfg.CredentialsPath, newKey, masterKey)
+		}
+	case args[0] == "rewrap":
+		var previousKey []byte
+		if previousKey, err = cfg.CredentialsPreviousKey(); err == nil && previousKey == nil {
+			err = errors.New("no previous key, set credentialsPreviousMasterKey or use -new-key-file")
+		} else if err == nil {
+			err = credentials.RewrapCredentialFile(cfg.CredentialsPath, masterKey, previousKey)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, credentialsUsage)
+		return 2
+	}
+
+	if errors.Is(err, os.ErrNotExist) {
+		err = fmt.Errorf("no credential file at %s", cfg.CredentialsPath)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials %s: %s\n", args[0], err)
+		return 1
+	}
+	fmt.Printf("Credential file %s: %s done\n", cfg.CredentialsPath, args[0])
+	return 0
+}
+
+func repepperCredentials(cfg *config.Config) int {
+	if cfg.PepperFile == "" {
+		fmt.Fprintln(os.Stderr, "No pepper, set pepperFile")
+		return 2
+	}
+
+	auditLog, err := cfg.AuditLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials repepper: %s\n", err)
+		return 1
+	}
+	defer auditLog.Close()
+
+	credsManager, _, err := openCredentialManager(cfg, auditLog)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials repepper: %s\n", err)
+		return 1
+	}
+
+	changed, err := credsManager.Repepper()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials repepper: %s\n", err)
+		return 1
+	}
+	fmt.Printf("Repeppered %d users\n", changed)
+	return 0
+}
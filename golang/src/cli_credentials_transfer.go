@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/config"
+)
+
+const (
+	formatTPasswd  = "tpasswd"
+	formatSRPVFile = "srpvfile"
+	formatLegacy   = "legacy" // "username:hash" lines, import only
+)
+
+type transferOptions struct {
+	format   string
+	filePath string
+	confPath string
+	replace  bool
+}
+
+func transferCredentials(cfg *config.Config, direction string, opts *transferOptions) int {
+	switch {
+	case opts.format == formatLegacy && direction == "export":
+		fmt.Fprintln(os.Stderr, "Legacy hashes can only be imported")
+		return 2
+	case opts.format != formatTPasswd && opts.format != formatSRPVFile && opts.format != formatLegacy:
+		fmt.Fprintf(os.Stderr, "Unknown format %q, expected %s, %s or %s\n", opts.format, formatTPasswd, formatSRPVFile, formatLegacy)
+		return 2
+	}
+	if opts.confPath == "" {
+		opts.confPath = filepath.Join(filepath.Dir(opts.filePath), "tpasswd.conf")
+	}
+
+	auditLog, err := cfg.AuditLogger()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials %s: %s\n", direction, err)
+		return 1
+	}
+	defer auditLog.Close()
+
+	credsManager, groups, err := openCredentialManager(cfg, auditLog)
+	if err == nil {
+		if direction == "import" {
+			err = importCredentials(credsManager, groups, opts)
+		} else {
+			err = exportCredentials(credsManager, groups, opts)
+		}
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "credentials %s: %s\n", direction, err)
+		return 1
+	}
+	return 0
+}
+
+func importCredentials(credsManager credentials.CredentialManager, groups []*srp.ConstantGroup, opts *transferOptions) error {
+	in, err := os.Open(opts.filePath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var users credentials.UserCredList
+	switch opts.format {
+	case formatTPasswd:
+		var conf *os.File
+		if conf, err = os.Open(opts.confPath); err != nil {
+			return err
+		}
+		defer conf.Close()
+		users, err = credentials.ReadTPasswd(in, conf, groups)
+	case formatSRPVFile:
+		users, err = credentials.ReadSRPVFile(in, groups)
+	case formatLegacy:
+		users, err = credentials.ReadLegacyHashes(in)
+	}
+	if err != nil {
+		return err
+	}
+
+	written, err := credsManager.ImportUsers(users, opts.replace)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Imported %d of %d users from %s\n", written, len(users), opts.filePath)
+	if written < len(users) {
+		fmt.Println("Existing users were kept, pass -replace to overwrite them")
+	}
+	return nil
+}
+
+func exportCredentials(credsManager credentials.CredentialManager, groups []*srp.ConstantGroup, opts *transferOptions) error {
+	users, err := credsManager.ExportUsers()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(opts.filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	var skipped []string
+	if opts.format == formatTPasswd {
+		var conf *os.File
+		if conf, err = os.OpenFile(opts.confPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644); err != nil {
+			return err
+		}
+		defer conf.Close()
+		skipped, err = credentials.WriteTPasswd(users, groups, out, conf)
+	} else {
+		skipped, err = credentials.WriteSRPVFile(users, groups, out)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Exported %d users to %s\n", len(users)-len(skipped), opts.filePath)
+	for _, username := range skipped {
+		fmt.Printf("Skipped %s, %s files only hold RFC 5054 SHA-1 verifiers and names without separators\n", username, opts.format)
+	}
+	return nil
+}
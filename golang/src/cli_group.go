@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/srp"
+)
+
+func runGroupCommand(args []string) int {
+	if len(args) != 2 || args[0] != "validate" {
+		fmt.Fprintln(os.Stderr, "Usage: srp-auth group validate <file>")
+		return 2
+	}
+
+	groups, err := srp.LoadGroupFile(args[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load %s: %s\n", args[1], err)
+		return 1
+	}
+
+	failed := 0
+	for _, group := range groups {
+		if err := srp.ValidateGroup(group); err != nil {
+			fmt.Printf("%-16s FAIL  %d bits: %s\n", group.Name, group.NBitLen(), err)
+			failed++
+			continue
+		}
+		fmt.Printf("%-16s OK    %d bits\n", group.Name, group.NBitLen())
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/identity"
+	"sharpstorm/srp-auth/config"
+)
+
+const identityKeysUsage = `Usage: srp-auth identity-keys <command> [-file path]
+
+Commands:
+  list           list the keys and their public keys, never printing private keys
+  add            add a key, published for pinning but only signing if it is the first
+  promote <kid>  sign handshakes with a key, the previous one stays published
+  retire <kid>   remove a key, clients that only pinned it stop trusting the server
+
+Rotate by adding a key, waiting until clients pinned it, promoting it and
+later retiring the old one. The key file defaults to srp.identityKeyFile
+from the active config.`
+
+func runIdentityKeysCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, identityKeysUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("identity-keys "+args[0], flag.ContinueOnError)
+	filePath := flags.String("file", "", "identity key file")
+	positional, err := parseFlags(flags, args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, identityKeysUsage)
+		return 2
+	}
+
+	if *filePath == "" {
+		cfg, err := config.Load(config.PathFromEnv())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		*filePath = cfg.SRP.IdentityKeyFile
+	}
+	if *filePath == "" {
+		fmt.Fprintln(os.Stderr, "No identity key file, set srp.identityKeyFile or pass -file")
+		return 2
+	}
+
+	switch {
+	case args[0] == "list" && len(positional) == 0:
+		err = listIdentityKeys(*filePath)
+	case args[0] == "add" && len(positional) == 0:
+		var key *identity.IdentityKey
+		if key, err = identity.AddKey(*filePath); err == nil {
+			fmt.Printf("New key %s %s\n", key.Id, base64.StdEncoding.EncodeToString(identity.PublicKeyOf(key)))
+		}
+	case args[0] == "promote" && len(positional) == 1:
+		if err = identity.PromoteKey(*filePath, positional[0]); err == nil {
+			fmt.Printf("New primary key %s\n", positional[0])
+		}
+	case args[0] == "retire" && len(positional) == 1:
+		if err = identity.RetireKey(*filePath, positional[0]); err == nil {
+			fmt.Printf("Retired key %s\n", positional[0])
+		}
+	default:
+		fmt.Fprintln(os.Stderr, identityKeysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "identity-keys %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func listIdentityKeys(filePath string) error {
+	keys, err := identity.ReadKeys(filePath)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		role := ""
+		if key.Primary {
+			role = "primary"
+		}
+		fmt.Printf("%-20s %s  %s  %s\n", key.Id, key.CreatedAt.Format("2006-01-02 15:04:05"), base64.StdEncoding.EncodeToString(identity.PublicKeyOf(key)), role)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/keyfile"
+	"sharpstorm/srp-auth/config"
+)
+
+const pepperKeysUsage = `Usage: srp-auth pepper-keys <command>
+
+Commands:
+  list          list the keys in the ring, never printing key material
+  rotate        add a new primary key, new and changed passwords use it
+  retire <kid>  remove a key no user is peppered under any more
+
+To rotate, run rotate, wait for running servers to pick up the new key, run
+credentials repepper, then retire the old key. The key file is pepperFile
+and the users are read from credentialsPath of the active config.`
+
+func runPepperKeysCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, pepperKeysUsage)
+		return 2
+	}
+
+	// Not validated, the pepper file may not exist yet
+	cfg, err := config.Load(config.PathFromEnv())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if cfg.PepperFile == "" {
+		fmt.Fprintln(os.Stderr, "No pepper, set pepperFile")
+		return 2
+	}
+
+	switch {
+	case args[0] == "list" && len(args) == 1:
+		err = listPepperKeys(cfg.PepperFile)
+	case args[0] == "rotate" && len(args) == 1:
+		var key *keyfile.Key
+		if key, err = credentials.RotatePepperKeys(cfg.PepperFile); err == nil {
+			fmt.Printf("New primary key %s\n", key.Id)
+		}
+	case args[0] == "retire" && len(args) == 2:
+		var serializer credentials.CredentialSerializer
+		if serializer, err = cfg.CredentialSerializer(); err == nil {
+			err = credentials.RetirePepperKey(cfg.PepperFile, args[1], serializer)
+		}
+		if err == nil {
+			fmt.Printf("Retired key %s\n", args[1])
+		}
+	default:
+		fmt.Fprintln(os.Stderr, pepperKeysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "pepper-keys %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func listPepperKeys(filePath string) error {
+	keys, err := credentials.ReadPepperKeys(filePath)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		role := ""
+		if key.Primary {
+			role = "primary"
+		}
+		fmt.Printf("%-20s %s  %s\n", key.Id, key.CreatedAt.Format("2006-01-02 15:04:05"), role)
+	}
+	return nil
+}
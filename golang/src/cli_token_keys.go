@@ -0,0 +1,85 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/auth/session"
+	"sharpstorm/srp-auth/config"
+)
+
+const tokenKeysUsage = `Usage: srp-auth token-keys <command> [-file path]
+
+Commands:
+  list          list the keys in the ring, never printing key material
+  rotate        add a new primary key, older keys keep validating tokens
+  retire <kid>  remove a key, tokens sealed under it stop validating
+
+The key file defaults to session.tokenKeyFile from the active config.`
+
+func runTokenKeysCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, tokenKeysUsage)
+		return 2
+	}
+
+	flags := flag.NewFlagSet("token-keys "+args[0], flag.ContinueOnError)
+	filePath := flags.String("file", "", "token key file")
+	positional, err := parseFlags(flags, args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, tokenKeysUsage)
+		return 2
+	}
+
+	if *filePath == "" {
+		cfg, err := config.Load(config.PathFromEnv())
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		*filePath = cfg.Session.TokenKeyFile
+	}
+	if *filePath == "" {
+		fmt.Fprintln(os.Stderr, "No token key file, set session.tokenKeyFile or pass -file")
+		return 2
+	}
+
+	switch {
+	case args[0] == "list" && len(positional) == 0:
+		err = listTokenKeys(*filePath)
+	case args[0] == "rotate" && len(positional) == 0:
+		var key *session.TokenKey
+		if key, err = session.RotateTokenKeys(*filePath); err == nil {
+			fmt.Printf("New primary key %s\n", key.Id)
+		}
+	case args[0] == "retire" && len(positional) == 1:
+		if err = session.RetireTokenKey(*filePath, positional[0]); err == nil {
+			fmt.Printf("Retired key %s\n", positional[0])
+		}
+	default:
+		fmt.Fprintln(os.Stderr, tokenKeysUsage)
+		return 2
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "token-keys %s: %s\n", args[0], err)
+		return 1
+	}
+	return 0
+}
+
+func listTokenKeys(filePath string) error {
+	keys, err := session.ReadTokenKeys(filePath)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		role := ""
+		if key.Primary {
+			role = "primary"
+		}
+		fmt.Printf("%-20s %s  %s\n", key.Id, key.CreatedAt.Format("2006-01-02 15:04:05"), role)
+	}
+	return nil
+}
@@ -0,0 +1,398 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/config"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+const userUsage = `Usage: srp-auth user <command> [-json] [username]
+
+Flags may come before or after the username. With -json, errors are
+printed as {"command": ..., "error": ...} on stdout.
+
+Commands:
+  add <username>              create a user, password read from the terminal or stdin
+  passwd <username>           replace a user's password
+  del <username>              delete a user
+  list                        list all users
+  show <username>             show a user's record, without the verifier
+  disable <username>          reject logins for a user
+  enable <username>           allow logins for a user again
+  verify-password <username>  check a password against the stored verifier
+  legacy                      list users still on an imported bcrypt or PBKDF2 hash`
+
+type passwordPrompt int
+
+const (
+	noPassword passwordPrompt = iota
+	newPassword
+	currentPassword
+)
+
+type userCommand struct {
+	needsUser bool
+	password  passwordPrompt
+	run       func(ctx *userContext, username string) (any, error)
+}
+
+var userCommands = map[string]userCommand{
+	"add":             {needsUser: true, password: newPassword, run: userAdd},
+	"passwd":          {needsUser: true, password: newPassword, run: userPasswd},
+	"del":             {needsUser: true, run: userDelete},
+	"list":            {run: userList},
+	"show":            {needsUser: true, run: userShow},
+	"disable":         {needsUser: true, run: userSetDisabled(true)},
+	"enable":          {needsUser: true, run: userSetDisabled(false)},
+	"verify-password": {needsUser: true, password: currentPassword, run: userVerifyPassword},
+	"legacy":          {run: userLegacyReport},
+}
+
+type userContext struct {
+	credsManager credentials.CredentialManager
+	groups       []*srp.ConstantGroup
+	password     string
+}
+
+type userInfoOutput struct {
+	Username  string    `json:"username"`
+	Group     string    `json:"group"`
+	Hash      string    `json:"hash"`
+	Disabled  bool      `json:"disabled"`
+	Legacy    bool      `json:"legacy,omitempty"`
+	PepperId  string    `json:"pepperId,omitempty"`
+	CreatedAt time.Time `json:"createdAt,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt,omitempty"`
+}
+
+type userResultOutput struct {
+	Username string `json:"username"`
+	Action   string `json:"action"`
+}
+
+type legacyReportOutput struct {
+	Remaining []string `json:"remaining"`
+	Total     int      `json:"total"`
+}
+
+type verifyPasswordOutput struct {
+	Username string `json:"username"`
+	Valid    bool   `json:"valid"`
+}
+
+var (
+	errPasswordMismatch = errors.New("password does not match")
+	errEmptyPassword    = errors.New("password is empty")
+)
+
+func runUserCommand(args []string) int {
+	if len(args) < 1 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	cmd, found := userCommands[args[0]]
+	flags := flag.NewFlagSet("user "+args[0], flag.ContinueOnError)
+	jsonOut := flags.Bool("json", false, "print machine readable JSON")
+	if !found {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+	positional, err := parseFlags(flags, args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	username := ""
+	if cmd.needsUser {
+		if len(positional) != 1 {
+			fmt.Fprintln(os.Stderr, userUsage)
+			return 2
+		}
+		username = positional[0]
+	} else if len(positional) != 0 {
+		fmt.Fprintln(os.Stderr, userUsage)
+		return 2
+	}
+
+	result, err := runWithCredentialStore(cmd, username)
+	if err != nil {
+		printUserError(args[0], err, *jsonOut)
+		return 1
+	}
+
+	printUserResult(result, *jsonOut)
+	if verifyResult, ok := result.(verifyPasswordOutput); ok && !verifyResult.Valid {
+		return 1
+	}
+	return 0
+}
+
+func runWithCredentialStore(cmd userCommand, username string) (any, error) {
+	cfg, err := loadConfig(config.PathFromEnv())
+	if err != nil {
+		return nil, err
+	}
+
+	auditLog, err := cfg.AuditLogger()
+	if err != nil {
+		return nil, err
+	}
+	defer auditLog.Close()
+
+	credsManager, groups, err := openCredentialManager(cfg, auditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	// Mutations take the file lock themselves, so a slow typist does not
+	// block a running server from saving.
+	ctx := &userContext{
+		credsManager: credsManager,
+		groups:       groups,
+	}
+	switch cmd.password {
+	case newPassword:
+		ctx.password, err = readPassword(fmt.Sprintf("New password for %s: ", username), true)
+	case currentPassword:
+		ctx.password, err = readPassword(fmt.Sprintf("Password for %s: ", username), false)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return cmd.run(ctx, username)
+}
+
+// openCredentialManager records changes to auditLog, close it once done to
+// write them out.
+func openCredentialManager(cfg *config.Config, auditLog audit.Logger) (credentials.CredentialManager, []*srp.ConstantGroup, error) {
+	groups, _ := cfg.Groups()
+	group, _ := cfg.Group(groups)
+	hashType, _ := cfg.HashType()
+	engine, err := srp.NewSRPEngine(group, hashType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serializer, err := cfg.CredentialSerializer()
+	if err != nil {
+		return nil, nil, err
+	}
+	pepper, err := cfg.Pepper()
+	if err != nil {
+		return nil, nil, err
+	}
+	credsManager, err := credentials.NewCredentialManager(serializer, engine, credentials.CredentialOptions{
+		Pepper: pepper,
+		Audit:  auditLog,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return credsManager, groups, nil
+}
+
+func userAdd(ctx *userContext, username string) (any, error) {
+	if err := ctx.credsManager.AddUser(username, ctx.password); err != nil {
+		return nil, err
+	}
+	return userResultOutput{Username: username, Action: "added"}, nil
+}
+
+func userPasswd(ctx *userContext, username string) (any, error) {
+	if err := ctx.credsManager.UpdateUser(username, ctx.password); err != nil {
+		return nil, err
+	}
+	return userResultOutput{Username: username, Action: "password changed"}, nil
+}
+
+func userDelete(ctx *userContext, username string) (any, error) {
+	if err := ctx.credsManager.DeleteUser(username); err != nil {
+		return nil, err
+	}
+	return userResultOutput{Username: username, Action: "deleted"}, nil
+}
+
+func userSetDisabled(disabled bool) func(*userContext, string) (any, error) {
+	action := "enabled"
+	if disabled {
+		action = "disabled"
+	}
+
+	return func(ctx *userContext, username string) (any, error) {
+		if err := ctx.credsManager.SetDisabled(username, disabled); err != nil {
+			return nil, err
+		}
+		return userResultOutput{Username: username, Action: action}, nil
+	}
+}
+
+func userList(ctx *userContext, _ string) (any, error) {
+	users := []userInfoOutput{}
+	for _, username := range ctx.credsManager.ListUsers() {
+		info, err := userInfoFor(ctx, username)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, info)
+	}
+	return users, nil
+}
+
+func userShow(ctx *userContext, username string) (any, error) {
+	return userInfoFor(ctx, username)
+}
+
+func userVerifyPassword(ctx *userContext, username string) (any, error) {
+	valid, err := ctx.credsManager.VerifyPassword(username, ctx.password, ctx.groups)
+	if err != nil {
+		return nil, err
+	}
+	return verifyPasswordOutput{Username: username, Valid: valid}, nil
+}
+
+func userLegacyReport(ctx *userContext, _ string) (any, error) {
+	return legacyReportOutput{
+		Remaining: ctx.credsManager.LegacyUsers(),
+		Total:     len(ctx.credsManager.ListUsers()),
+	}, nil
+}
+
+func userInfoFor(ctx *userContext, username string) (userInfoOutput, error) {
+	userInfo, err := ctx.credsManager.GetUserInfo(username)
+	if err != nil {
+		return userInfoOutput{}, err
+	}
+
+	group, hashType, err := userInfo.Params(ctx.groups)
+	groupName, hashName := userInfo.Group, userInfo.Hash
+	if userInfo.IsLegacy() {
+		groupName, hashName = "-", "-"
+	} else if err == nil {
+		groupName, hashName = group.Name, hashType.String()
+	}
+
+	return userInfoOutput{
+		Username:  username,
+		Group:     groupName,
+		Hash:      hashName,
+		Disabled:  userInfo.Disabled,
+		Legacy:    userInfo.IsLegacy(),
+		PepperId:  userInfo.PepperId,
+		CreatedAt: userInfo.CreatedAt,
+		UpdatedAt: userInfo.UpdatedAt,
+	}, nil
+}
+
+type userErrorOutput struct {
+	Command string `json:"command"`
+	Error   string `json:"error"`
+}
+
+// printUserError writes the error as JSON on stdout with -json, where the
+// result would have gone, and as text on stderr otherwise.
+func printUserError(command string, err error, jsonOut bool) {
+	if jsonOut {
+		out, _ := json.MarshalIndent(userErrorOutput{Command: command, Error: err.Error()}, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+	fmt.Fprintf(os.Stderr, "user %s: %s\n", command, err)
+}
+
+func printUserResult(result any, jsonOut bool) {
+	if jsonOut {
+		out, _ := json.MarshalIndent(result, "", "  ")
+		fmt.Println(string(out))
+		return
+	}
+
+	switch value := result.(type) {
+	case userResultOutput:
+		fmt.Printf("%s: %s\n", value.Username, value.Action)
+	case verifyPasswordOutput:
+		if value.Valid {
+			fmt.Printf("%s: password OK\n", value.Username)
+		} else {
+			fmt.Printf("%s: password does not match\n", value.Username)
+		}
+	case userInfoOutput:
+		printUserInfo(value)
+	case []userInfoOutput:
+		for _, info := range value {
+			printUserInfo(info)
+		}
+	case legacyReportOutput:
+		for _, username := range value.Remaining {
+			fmt.Println(username)
+		}
+		fmt.Printf("%d of %d users have not been migrated from a legacy hash\n", len(value.Remaining), value.Total)
+	}
+}
+
+func printUserInfo(info userInfoOutput) {
+	status := "enabled"
+	if info.Disabled {
+		status = "disabled"
+	}
+	if info.Legacy {
+		status += ", legacy hash"
+	}
+	fmt.Printf("%-24s %-8s %-12s %s\n", info.Username, info.Group, info.Hash, status)
+}
+
+// readPassword prompts without echo when stdin is a terminal, otherwise it
+// reads a single line so that passwords can be piped in by scripts.
+func readPassword(prompt string, confirm bool) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", fmt.Errorf("failed to read password from stdin: %w", err)
+		}
+		return nonEmptyPassword(strings.TrimRight(line, "\r\n"))
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	password, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+
+	if confirm {
+		fmt.Fprint(os.Stderr, "Repeat password: ")
+		repeated, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return "", err
+		}
+		if string(repeated) != string(password) {
+			return "", errPasswordMismatch
+		}
+	}
+	return nonEmptyPassword(string(password))
+}
+
+// nonEmptyPassword catches a bare Enter before the length policy does, with
+// a clearer message.
+func nonEmptyPassword(password string) (string, error) {
+	if password == "" {
+		return "", errEmptyPassword
+	}
+	return password, nil
+}
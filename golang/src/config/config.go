@@ -0,0 +1,265 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sharpstorm/srp-auth/logging"
+	"sharpstorm/srp-auth/srpauth"
+	"time"
+)
+
+const ConfigPathEnv = "SRP_CONFIG"
+
+type Config struct {
+	ListenAddr      string          `json:"listenAddr"`
+	TLS             TLSConfig       `json:"tls"`
+	Admin           AdminConfig     `json:"admin"`
+	Log             LogConfig       `json:"log"`
+	CredentialsPath string          `json:"credentialsPath"`
+	FrontendDir     string          `json:"frontendDir"`
+	CORSOrigins     []string        `json:"corsOrigins"`
+	SeedAdmin       SeedUserConfig  `json:"seedAdmin"`
+	SRP             SRPConfig       `json:"srp"`
+	Handshake       HandshakeConfig `json:"handshake"`
+	Session         SessionConfig   `json:"session"`
+	LegacyLogin     LegacyConfig    `json:"legacyLogin"`
+	Timeouts        TimeoutConfig   `json:"timeouts"`
+
+	// Setting either encrypts the credential file at rest
+	CredentialsMasterKey     string `json:"credentialsMasterKey"` // Base64, prefer credentialsMasterKeyFile outside development
+	CredentialsMasterKeyFile string `json:"credentialsMasterKeyFile"`
+	// Still accepted while the file is rewrapped onto the key above
+	CredentialsPreviousMasterKey     string `json:"credentialsPreviousMasterKey"`
+	CredentialsPreviousMasterKeyFile string `json:"credentialsPreviousMasterKeyFile"`
+	// Managed with `srp-auth pepper-keys`, new verifiers are peppered under its primary key
+	PepperFile string `json:"pepperFile"`
+	// HMAC chained JSON lines, check with `srp-auth audit verify`. Empty disables auditing
+	AuditFile    string `json:"auditFile"`
+	AuditKey     string `json:"auditKey"` // Base64, prefer auditKeyFile outside development
+	AuditKeyFile string `json:"auditKeyFile"`
+	// Keeps decoy handshakes the same across instances and restarts, never rotate it
+	DecoyKey     string `json:"decoyKey"` // Base64, prefer decoyKeyFile outside development
+	DecoyKeyFile string `json:"decoyKeyFile"`
+}
+
+// TLSConfig serves listenAddr over TLS when CertFile and KeyFile are set.
+// Both files are reloaded when they change.
+type TLSConfig struct {
+	CertFile string `json:"certFile"` // PEM, the leaf certificate first
+	KeyFile  string `json:"keyFile"`
+	// Development only: create a self-signed certificate and key in
+	// CertFile and KeyFile if CertFile does not exist
+	SelfSigned bool `json:"selfSigned"`
+}
+
+// AdminConfig is a second listener for operators, serving /metrics. Keep it
+// off the public network.
+type AdminConfig struct {
+	ListenAddr   string `json:"listenAddr"`   // Empty disables the admin listener
+	TLS          bool   `json:"tls"`          // Serve with the tls certificate as well
+	ClientCAFile string `json:"clientCAFile"` // Requires tls, clients must present a certificate signed by one of these CAs
+}
+
+type LogConfig struct {
+	Format string            `json:"format"` // "text" or "json"
+	Level  string            `json:"level"`  // "debug", "info", "warn" or "error"
+	Levels map[string]string `json:"levels"` // Per subsystem, e.g. {"handshake": "debug"}
+}
+
+type SeedUserConfig struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+type SRPConfig struct {
+	Group     string `json:"group"`
+	GroupFile string `json:"groupFile"`
+	Hash      string `json:"hash"`
+	// Smallest N srp.group may have. Users created under smaller groups
+	// can still log in
+	MinGroupBits int `json:"minGroupBits"`
+	// "off", "optional" or "required", binds proofs to the TLS connection
+	ChannelBinding string `json:"channelBinding"`
+	// Ed25519 keys handshake responses are signed with, create one with
+	// `srp-auth identity-keys add`. Empty leaves responses unsigned
+	IdentityKeyFile string `json:"identityKeyFile"`
+}
+
+const (
+	HandshakeStoreMemory = "memory"
+	HandshakeStoreSealed = "sealed"
+)
+
+type HandshakeConfig struct {
+	Validity Duration `json:"validity"`
+	Limit    int      `json:"limit"` // Per user, memory store only
+	Store    string   `json:"store"` // "memory" or "sealed"
+
+	// Sealed store
+	KeyFile    string `json:"keyFile"`    // Token key file format, defaults to session.tokenKeyFile
+	ReplayFile string `json:"replayFile"` // Empty tracks used handshakes in memory, per instance
+}
+
+const (
+	SessionModeStateful = "stateful"
+	SessionModeToken    = "token"
+
+	SessionStoreMemory = "memory"
+	SessionStoreFile   = "file"
+)
+
+type SessionConfig struct {
+	Mode       string   `json:"mode"` // "stateful" or "token"
+	MaxPerUser int      `json:"maxPerUser"`
+	TTL        Duration `json:"ttl"`
+	// How long after a login or reauthentication routes behind
+	// RequireRecentAuth accept the session
+	RecentAuthWindow Duration `json:"recentAuthWindow"`
+
+	// Stateful mode
+	Store         string `json:"store"` // "memory" or "file"
+	File          string `json:"file"`
+	MasterKey     string `json:"masterKey"` // Base64, prefer masterKeyFile outside development
+	MasterKeyFile string `json:"masterKeyFile"`
+
+	// Token mode
+	TokenKeyFile   string `json:"tokenKeyFile"`
+	RevocationFile string `json:"revocationFile"` // Empty keeps revocations in memory, per instance
+}
+
+func (cfg *Config) TLSEnabled() bool {
+	return cfg.TLS.CertFile != "" || cfg.TLS.KeyFile != ""
+}
+
+// HandshakeKeyFile is the key ring sealed handshakes are encrypted under.
+func (cfg *Config) HandshakeKeyFile() string {
+	if cfg.Handshake.KeyFile != "" {
+		return cfg.Handshake.KeyFile
+	}
+	return cfg.Session.TokenKeyFile
+}
+
+// LegacyConfig controls the endpoint that upgrades users imported with a
+// bcrypt or PBKDF2 hash. It receives plain passwords, so it only accepts
+// TLS connections unless AllowInsecure is set, e.g. behind a TLS
+// terminating proxy.
+type LegacyConfig struct {
+	Enabled       bool `json:"enabled"`
+	AllowInsecure bool `json:"allowInsecure"`
+	// Attempts allowed per username and per remote address in each window
+	MaxAttemptsPerUser    int      `json:"maxAttemptsPerUser"`
+	MaxAttemptsPerAddress int      `json:"maxAttemptsPerAddress"`
+	AttemptWindow         Duration `json:"attemptWindow"`
+}
+
+type TimeoutConfig struct {
+	Read       Duration `json:"read"`
+	ReadHeader Duration `json:"readHeader"`
+	Write      Duration `json:"write"`
+	Idle       Duration `json:"idle"`
+	Shutdown   Duration `json:"shutdown"` // Upper bound on draining handshakes and in-flight requests
+}
+
+// Duration accepts time.ParseDuration strings such as "10s" in config files.
+type Duration time.Duration
+
+func Default() *Config {
+	return &Config{
+		ListenAddr:      ":8000",
+		CredentialsPath: "./users.json",
+		FrontendDir:     "../../frontend",
+		CORSOrigins:     []string{"foo.com"},
+		Log: LogConfig{
+			Format: logging.FormatText,
+			Level:  "info",
+		},
+		SRP: SRPConfig{
+			Group:          "3072",
+			Hash:           "SHA-512",
+			MinGroupBits:   2048,
+			ChannelBinding: srpauth.ChannelBindingOptional,
+		},
+		Handshake: HandshakeConfig{
+			Validity: Duration(10 * time.Second),
+			Limit:    3,
+			Store:    HandshakeStoreMemory,
+		},
+		Session: SessionConfig{
+			Mode:       SessionModeStateful,
+			MaxPerUser: 3,
+			TTL:        Duration(24 * time.Hour),
+			Store:      SessionStoreMemory,
+			File:       "./sessions.json",
+
+			RecentAuthWindow: Duration(5 * time.Minute),
+		},
+		LegacyLogin: LegacyConfig{
+			MaxAttemptsPerUser:    5,
+			MaxAttemptsPerAddress: 50,
+			AttemptWindow:         Duration(15 * time.Minute),
+		},
+		Timeouts: TimeoutConfig{
+			Read:       Duration(10 * time.Second),
+			ReadHeader: Duration(5 * time.Second),
+			Write:      Duration(10 * time.Second),
+			Idle:       Duration(2 * time.Minute),
+			Shutdown:   Duration(30 * time.Second),
+		},
+	}
+}
+
+// Load builds the config from defaults, then the JSON file at filePath (if
+// not empty), then SRP_* environment variables.
+func Load(filePath string) (*Config, error) {
+	cfg := Default()
+
+	if filePath != "" {
+		dat, err := os.ReadFile(filePath)
+		if err != nil {
+			return nil, err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(dat))
+		decoder.DisallowUnknownFields()
+		if err = decoder.Decode(cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+	}
+
+	if err := cfg.applyEnv(os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// PathFromEnv returns the config file path from SRP_CONFIG, if set.
+func PathFromEnv() string {
+	return os.Getenv(ConfigPathEnv)
+}
+
+func (d Duration) Duration() time.Duration {
+	return time.Duration(d)
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(dat []byte) error {
+	var value string
+	if err := json.Unmarshal(dat, &value); err != nil {
+		return errors.New("duration must be a string such as \"10s\"")
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+
+	*d = Duration(parsed)
+	return nil
+}
@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type envBinding struct {
+	name  string
+	apply func(cfg *Config, value string) error
+}
+
+// Environment variables take precedence over the config file, matching the
+// golang/.env file exported by the makefile.
+var envBindings = []envBinding{
+	{"SRP_LISTEN_ADDR", func(cfg *Config, v string) error { cfg.ListenAddr = v; return nil }},
+	{"SRP_TLS_CERT_FILE", func(cfg *Config, v string) error { cfg.TLS.CertFile = v; return nil }},
+	{"SRP_TLS_KEY_FILE", func(cfg *Config, v string) error { cfg.TLS.KeyFile = v; return nil }},
+	{"SRP_TLS_SELF_SIGNED", func(cfg *Config, v string) error { return parseBool(&cfg.TLS.SelfSigned, v) }},
+	{"SRP_ADMIN_LISTEN_ADDR", func(cfg *Config, v string) error { cfg.Admin.ListenAddr = v; return nil }},
+	{"SRP_ADMIN_TLS", func(cfg *Config, v string) error { return parseBool(&cfg.Admin.TLS, v) }},
+	{"SRP_ADMIN_CLIENT_CA_FILE", func(cfg *Config, v string) error { cfg.Admin.ClientCAFile = v; return nil }},
+	{"SRP_LOG_FORMAT", func(cfg *Config, v string) error { cfg.Log.Format = v; return nil }},
+	{"SRP_LOG_LEVEL", func(cfg *Config, v string) error { cfg.Log.Level = v; return nil }},
+	{"SRP_LOG_LEVELS", func(cfg *Config, v string) error { return parseLevels(&cfg.Log.Levels, v) }},
+	{"SRP_CREDENTIALS_PATH", func(cfg *Config, v string) error { cfg.CredentialsPath = v; return nil }},
+	{"SRP_CREDENTIALS_MASTER_KEY", func(cfg *Config, v string) error { cfg.CredentialsMasterKey = v; return nil }},
+	{"SRP_CREDENTIALS_MASTER_KEY_FILE", func(cfg *Config, v string) error { cfg.CredentialsMasterKeyFile = v; return nil }},
+	{"SRP_CREDENTIALS_PREVIOUS_MASTER_KEY", func(cfg *Config, v string) error { cfg.CredentialsPreviousMasterKey = v; return nil }},
+	{"SRP_CREDENTIALS_PREVIOUS_MASTER_KEY_FILE", func(cfg *Config, v string) error { cfg.CredentialsPreviousMasterKeyFile = v; return nil }},
+	{"SRP_PEPPER_FILE", func(cfg *Config, v string) error { cfg.PepperFile = v; return nil }},
+	{"SRP_AUDIT_FILE", func(cfg *Config, v string) error { cfg.AuditFile = v; return nil }},
+	{"SRP_AUDIT_KEY", func(cfg *Config, v string) error { cfg.AuditKey = v; return nil }},
+	{"SRP_AUDIT_KEY_FILE", func(cfg *Config, v string) error { cfg.AuditKeyFile = v; return nil }},
+	{"SRP_DECOY_KEY", func(cfg *Config, v string) error { cfg.DecoyKey = v; return nil }},
+	{"SRP_DECOY_KEY_FILE", func(cfg *Config, v string) error { cfg.DecoyKeyFile = v; return nil }},
+	{"SRP_FRONTEND_DIR", func(cfg *Config, v string) error { cfg.FrontendDir = v; return nil }},
+	{"SRP_CORS_ORIGINS", func(cfg *Config, v string) error { cfg.CORSOrigins = splitList(v); return nil }},
+	{"SRP_SEED_ADMIN_USERNAME", func(cfg *Config, v string) error { cfg.SeedAdmin.Username = v; return nil }},
+	{"SRP_SEED_ADMIN_PASSWORD", func(cfg *Config, v string) error { cfg.SeedAdmin.Password = v; return nil }},
+	{"SRP_GROUP", func(cfg *Config, v string) error { cfg.SRP.Group = v; return nil }},
+	{"SRP_GROUP_FILE", func(cfg *Config, v string) error { cfg.SRP.GroupFile = v; return nil }},
+	{"SRP_MIN_GROUP_BITS", func(cfg *Config, v string) error { return parseInt(&cfg.SRP.MinGroupBits, v) }},
+	{"SRP_HASH", func(cfg *Config, v string) error { cfg.SRP.Hash = v; return nil }},
+	{"SRP_CHANNEL_BINDING", func(cfg *Config, v string) error { cfg.SRP.ChannelBinding = v; return nil }},
+	{"SRP_IDENTITY_KEY_FILE", func(cfg *Config, v string) error { cfg.SRP.IdentityKeyFile = v; return nil }},
+	{"SRP_HANDSHAKE_VALIDITY", func(cfg *Config, v string) error { return parseDuration(&cfg.Handshake.Validity, v) }},
+	{"SRP_HANDSHAKE_LIMIT", func(cfg *Config, v string) error { return parseInt(&cfg.Handshake.Limit, v) }},
+	{"SRP_HANDSHAKE_STORE", func(cfg *Config, v string) error { cfg.Handshake.Store = v; return nil }},
+	{"SRP_HANDSHAKE_KEY_FILE", func(cfg *Config, v string) error { cfg.Handshake.KeyFile = v; return nil }},
+	{"SRP_HANDSHAKE_REPLAY_FILE", func(cfg *Config, v string) error { cfg.Handshake.ReplayFile = v; return nil }},
+	{"SRP_SESSION_MAX_PER_USER", func(cfg *Config, v string) error { return parseInt(&cfg.Session.MaxPerUser, v) }},
+	{"SRP_SESSION_MODE", func(cfg *Config, v string) error { cfg.Session.Mode = v; return nil }},
+	{"SRP_SESSION_TOKEN_KEY_FILE", func(cfg *Config, v string) error { cfg.Session.TokenKeyFile = v; return nil }},
+	{"SRP_SESSION_REVOCATION_FILE", func(cfg *Config, v string) error { cfg.Session.RevocationFile = v; return nil }},
+	{"SRP_SESSION_TTL", func(cfg *Config, v string) error { return parseDuration(&cfg.Session.TTL, v) }},
+	{"SRP_SESSION_RECENT_AUTH_WINDOW", func(cfg *Config, v string) error { return parseDuration(&cfg.Session.RecentAuthWindow, v) }},
+	{"SRP_SESSION_STORE", func(cfg *Config, v string) error { cfg.Session.Store = v; return nil }},
+	{"SRP_SESSION_FILE", func(cfg *Config, v string) error { cfg.Session.File = v; return nil }},
+	{"SRP_SESSION_MASTER_KEY", func(cfg *Config, v string) error { cfg.Session.MasterKey = v; return nil }},
+	{"SRP_SESSION_MASTER_KEY_FILE", func(cfg *Config, v string) error { cfg.Session.MasterKeyFile = v; return nil }},
+	{"SRP_LEGACY_LOGIN_ENABLED", func(cfg *Config, v string) error { return parseBool(&cfg.LegacyLogin.Enabled, v) }},
+	{"SRP_LEGACY_LOGIN_ALLOW_INSECURE", func(cfg *Config, v string) error { return parseBool(&cfg.LegacyLogin.AllowInsecure, v) }},
+	{"SRP_LEGACY_LOGIN_MAX_ATTEMPTS_PER_USER", func(cfg *Config, v string) error { return parseInt(&cfg.LegacyLogin.MaxAttemptsPerUser, v) }},
+	{"SRP_LEGACY_LOGIN_MAX_ATTEMPTS_PER_ADDRESS", func(cfg *Config, v string) error { return parseInt(&cfg.LegacyLogin.MaxAttemptsPerAddress, v) }},
+	{"SRP_LEGACY_LOGIN_ATTEMPT_WINDOW", func(cfg *Config, v string) error { return parseDuration(&cfg.LegacyLogin.AttemptWindow, v) }},
+	{"SRP_READ_TIMEOUT", func(cfg *Config, v string) error { return parseDuration(&cfg.Timeouts.Read, v) }},
+	{"SRP_READ_HEADER_TIMEOUT", func(cfg *Config, v string) error { return parseDuration(&cfg.Timeouts.ReadHeader, v) }},
+	{"SRP_WRITE_TIMEOUT", func(cfg *Config, v string) error { return parseDuration(&cfg.Timeouts.Write, v) }},
+	{"SRP_IDLE_TIMEOUT", func(cfg *Config, v string) error { return parseDuration(&cfg.Timeouts.Idle, v) }},
+	{"SRP_SHUTDOWN_TIMEOUT", func(cfg *Config, v string) error { return parseDuration(&cfg.Timeouts.Shutdown, v) }},
+}
+
+func (cfg *Config) applyEnv(lookup func(string) (string, bool)) error {
+	for _, binding := range envBindings {
+		value, found := lookup(binding.name)
+		if !found {
+			continue
+		}
+		if err := binding.apply(cfg, value); err != nil {
+			return fmt.Errorf("%s: %w", binding.name, err)
+		}
+	}
+	return nil
+}
+
+func splitList(value string) []string {
+	out := []string{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+func parseDuration(target *Duration, value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*target = Duration(parsed)
+	return nil
+}
+
+func parseInt(target *int, value string) error {
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+func parseBool(target *bool, value string) error {
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	*target = parsed
+	return nil
+}
+
+// parseLevels reads "subsystem=level" pairs such as "handshake=debug,session=warn".
+func parseLevels(target *map[string]string, value string) error {
+	levels := map[string]string{}
+	for _, item := range splitList(value) {
+		subsystem, level, found := strings.Cut(item, "=")
+		if !found {
+			return fmt.Errorf("expected subsystem=level, got %q", item)
+		}
+		levels[strings.TrimSpace(subsystem)] = strings.TrimSpace(level)
+	}
+	*target = levels
+	return nil
+}
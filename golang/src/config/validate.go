@@ -0,0 +1,393 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/identity"
+	"sharpstorm/srp-auth/auth/keyfile"
+	"sharpstorm/srp-auth/auth/masterkey"
+	"sharpstorm/srp-auth/auth/session"
+	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/logging"
+	"sharpstorm/srp-auth/srpauth"
+	"sharpstorm/srp-auth/tlsutil"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	minHandshakeValidity = time.Second
+)
+
+// Validate reports every problem with the config at once so that a broken
+// deployment can be fixed in one pass.
+func (cfg *Config) Validate() error {
+	errs := []error{}
+	fail := func(field string, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		fail("listenAddr", "expected host:port such as \":8000\", got %q", cfg.ListenAddr)
+	}
+	if cfg.Admin.ListenAddr != "" {
+		if _, _, err := net.SplitHostPort(cfg.Admin.ListenAddr); err != nil {
+			fail("admin.listenAddr", "expected host:port such as \"127.0.0.1:9090\", got %q", cfg.Admin.ListenAddr)
+		} else if cfg.Admin.ListenAddr == cfg.ListenAddr {
+			fail("admin.listenAddr", "must differ from listenAddr")
+		}
+	}
+	cfg.validateTLS(fail)
+	if _, err := cfg.LoggingOptions(); err != nil {
+		fail("log", "%s", err)
+	}
+	if cfg.CredentialsPath == "" {
+		fail("credentialsPath", "must not be empty")
+	}
+	masterKey, err := cfg.CredentialsKey()
+	if err != nil {
+		fail("credentialsMasterKey", "%s", err)
+	}
+	if previousKey, err := cfg.CredentialsPreviousKey(); err != nil {
+		fail("credentialsPreviousMasterKey", "%s", err)
+	} else if previousKey != nil && masterKey == nil {
+		fail("credentialsPreviousMasterKey", "needs credentialsMasterKey or credentialsMasterKeyFile")
+	}
+	if cfg.PepperFile != "" {
+		if _, err := credentials.ReadPepperKeys(cfg.PepperFile); err != nil {
+			fail("pepperFile", "%s, create one with `srp-auth pepper-keys rotate`", err)
+		}
+	}
+	if cfg.AuditFile != "" {
+		if info, err := os.Stat(filepath.Dir(cfg.AuditFile)); err != nil || !info.IsDir() {
+			fail("auditFile", "directory of %q does not exist", cfg.AuditFile)
+		}
+		if _, err := cfg.AuditHMACKey(); err != nil {
+			fail("auditKey", "%s, the audit log needs auditKey or auditKeyFile", err)
+		}
+	}
+	if _, err := cfg.DecoyHMACKey(); err != nil {
+		fail("decoyKey", "%s", err)
+	}
+	for _, origin := range cfg.CORSOrigins {
+		if origin == "" || strings.ContainsAny(origin, " ,") {
+			fail("corsOrigins", "invalid origin %q", origin)
+		}
+	}
+
+	if cfg.SeedAdmin.Username == "" && cfg.SeedAdmin.Password != "" {
+		fail("seedAdmin.username", "must be set when seedAdmin.password is set")
+	}
+	if cfg.SeedAdmin.Username != "" && credentials.ValidatePassword(cfg.SeedAdmin.Password) != nil {
+		fail("seedAdmin.password", "must be at least %d characters", credentials.MinPasswordLength)
+	}
+
+	if _, err := cfg.HashType(); err != nil {
+		fail("srp.hash", "%s, supported hashes are %s", err, strings.Join(srp.HashNames(), ", "))
+	}
+	if groups, err := cfg.Groups(); err != nil {
+		fail("srp.groupFile", "%s", err)
+	} else if _, err := cfg.Group(groups); err != nil {
+		fail("srp.group", "%s", err)
+	}
+	if cfg.SRP.MinGroupBits < srp.MinGroupBits {
+		fail("srp.minGroupBits", "must be at least %d", srp.MinGroupBits)
+	}
+
+	switch cfg.SRP.ChannelBinding {
+	case srpauth.ChannelBindingOff, srpauth.ChannelBindingOptional:
+	case srpauth.ChannelBindingRequired:
+		if !cfg.TLSEnabled() {
+			fail("srp.channelBinding", "%q needs tls.certFile and tls.keyFile, it cannot work behind a TLS terminating proxy", srpauth.ChannelBindingRequired)
+		}
+	default:
+		fail("srp.channelBinding", "must be %q, %q or %q, got %q", srpauth.ChannelBindingOff, srpauth.ChannelBindingOptional, srpauth.ChannelBindingRequired, cfg.SRP.ChannelBinding)
+	}
+	if cfg.SRP.IdentityKeyFile != "" {
+		keys, err := identity.ReadKeys(cfg.SRP.IdentityKeyFile)
+		if err == nil && !slices.ContainsFunc(keys, func(key *identity.IdentityKey) bool { return key.Primary }) {
+			err = keyfile.ErrNoPrimaryKey
+		}
+		if err != nil {
+			fail("srp.identityKeyFile", "%s, create one with `srp-auth identity-keys add`", err)
+		}
+	}
+
+	if cfg.Handshake.Validity.Duration() < minHandshakeValidity {
+		fail("handshake.validity", "must be at least %s", minHandshakeValidity)
+	}
+	if cfg.Handshake.Limit < 1 {
+		fail("handshake.limit", "must be at least 1")
+	}
+	switch cfg.Handshake.Store {
+	case HandshakeStoreMemory:
+	case HandshakeStoreSealed:
+		if cfg.HandshakeKeyFile() == "" {
+			fail("handshake.keyFile", "must be set for the sealed store unless session.tokenKeyFile is")
+		} else if _, err := session.ReadTokenKeys(cfg.HandshakeKeyFile()); err != nil {
+			fail("handshake.keyFile", "%s, create one with `srp-auth token-keys rotate`", err)
+		}
+	default:
+		fail("handshake.store", "must be %q or %q, got %q", HandshakeStoreMemory, HandshakeStoreSealed, cfg.Handshake.Store)
+	}
+	if cfg.Session.MaxPerUser < 1 {
+		fail("session.maxPerUser", "must be at least 1")
+	}
+	if cfg.Session.TTL < 0 {
+		fail("session.ttl", "must not be negative, use 0 for sessions that never expire")
+	}
+	if cfg.Session.RecentAuthWindow <= 0 {
+		fail("session.recentAuthWindow", "must be positive")
+	}
+	switch cfg.Session.Mode {
+	case SessionModeStateful:
+		cfg.validateSessionStore(fail)
+	case SessionModeToken:
+		if cfg.Session.TTL <= 0 {
+			fail("session.ttl", "must be positive in token mode, tokens cannot be revoked forever")
+		}
+		if cfg.Session.TokenKeyFile == "" {
+			fail("session.tokenKeyFile", "must be set in token mode, create one with `srp-auth token-keys rotate`")
+		} else if _, err := session.ReadTokenKeys(cfg.Session.TokenKeyFile); err != nil {
+			fail("session.tokenKeyFile", "%s, create one with `srp-auth token-keys rotate`", err)
+		}
+	default:
+		fail("session.mode", "must be %q or %q, got %q", SessionModeStateful, SessionModeToken, cfg.Session.Mode)
+	}
+
+	if cfg.LegacyLogin.MaxAttemptsPerUser < 1 {
+		fail("legacyLogin.maxAttemptsPerUser", "must be at least 1")
+	}
+	if cfg.LegacyLogin.MaxAttemptsPerAddress < 1 {
+		fail("legacyLogin.maxAttemptsPerAddress", "must be at least 1")
+	}
+	if cfg.LegacyLogin.AttemptWindow.Duration() <= 0 {
+		fail("legacyLogin.attemptWindow", "must be positive")
+	}
+
+	timeouts := []struct {
+		field string
+		value Duration
+	}{
+		{"timeouts.read", cfg.Timeouts.Read},
+		{"timeouts.readHeader", cfg.Timeouts.ReadHeader},
+		{"timeouts.write", cfg.Timeouts.Write},
+		{"timeouts.idle", cfg.Timeouts.Idle},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value < 0 {
+			fail(timeout.field, "must not be negative, use 0 to disable")
+		}
+	}
+	if cfg.Timeouts.Shutdown.Duration() < cfg.Handshake.Validity.Duration() {
+		fail("timeouts.shutdown", "must be at least handshake.validity (%s) so outstanding handshakes can drain", cfg.Handshake.Validity.Duration())
+	}
+
+	return errors.Join(errs...)
+}
+
+// ValidateServing checks what only the server needs, on top of Validate.
+// The admin CLI can run from any directory, where relative paths such as
+// frontendDir need not resolve.
+func (cfg *Config) ValidateServing() error {
+	if info, err := os.Stat(cfg.FrontendDir); err != nil || !info.IsDir() {
+		return fmt.Errorf("frontendDir: %q is not a directory", cfg.FrontendDir)
+	}
+	return nil
+}
+
+func (cfg *Config) validateTLS(fail func(field string, format string, args ...any)) {
+	if cfg.TLSEnabled() {
+		switch {
+		case cfg.TLS.CertFile == "" || cfg.TLS.KeyFile == "":
+			fail("tls", "certFile and keyFile must be set together")
+		case cfg.TLS.SelfSigned:
+			if info, err := os.Stat(filepath.Dir(cfg.TLS.CertFile)); err != nil || !info.IsDir() {
+				fail("tls.certFile", "directory of %q does not exist", cfg.TLS.CertFile)
+			}
+		default:
+			if _, err := tls.LoadX509KeyPair(cfg.TLS.CertFile, cfg.TLS.KeyFile); err != nil {
+				fail("tls.certFile", "%s", err)
+			}
+		}
+	} else if cfg.TLS.SelfSigned {
+		fail("tls.selfSigned", "needs tls.certFile and tls.keyFile to write to")
+	}
+
+	if cfg.Admin.TLS && !cfg.TLSEnabled() {
+		fail("admin.tls", "needs tls.certFile and tls.keyFile")
+	}
+	if cfg.Admin.ClientCAFile != "" {
+		if !cfg.Admin.TLS {
+			fail("admin.clientCAFile", "needs admin.tls")
+		}
+		if _, err := tlsutil.LoadCertPool(cfg.Admin.ClientCAFile); err != nil {
+			fail("admin.clientCAFile", "%s", err)
+		}
+	}
+}
+
+func (cfg *Config) validateSessionStore(fail func(field string, format string, args ...any)) {
+	switch cfg.Session.Store {
+	case SessionStoreMemory:
+	case SessionStoreFile:
+		if cfg.Session.File == "" {
+			fail("session.file", "must be set for the file session store")
+		}
+		if _, err := cfg.SessionMasterKey(); err != nil {
+			fail("session.masterKey", "%s, the file session store needs session.masterKey or session.masterKeyFile", err)
+		}
+	default:
+		fail("session.store", "must be %q or %q, got %q", SessionStoreMemory, SessionStoreFile, cfg.Session.Store)
+	}
+}
+
+// CredentialsKey returns nil when the credential file is not encrypted.
+func (cfg *Config) CredentialsKey() ([]byte, error) {
+	if cfg.CredentialsMasterKey == "" && cfg.CredentialsMasterKeyFile == "" {
+		return nil, nil
+	}
+	return masterkey.Load(cfg.CredentialsMasterKey, cfg.CredentialsMasterKeyFile)
+}
+
+// CredentialsPreviousKey returns nil when no key rotation is in progress.
+func (cfg *Config) CredentialsPreviousKey() ([]byte, error) {
+	if cfg.CredentialsPreviousMasterKey == "" && cfg.CredentialsPreviousMasterKeyFile == "" {
+		return nil, nil
+	}
+	return masterkey.Load(cfg.CredentialsPreviousMasterKey, cfg.CredentialsPreviousMasterKeyFile)
+}
+
+// CredentialSerializer reads the credential file, decrypting it if a
+// master key is configured.
+func (cfg *Config) CredentialSerializer() (credentials.CredentialSerializer, error) {
+	masterKey, err := cfg.CredentialsKey()
+	if err != nil {
+		return nil, err
+	}
+	if masterKey == nil {
+		return credentials.GetCredentialSerializer(cfg.CredentialsPath), nil
+	}
+	previousKey, err := cfg.CredentialsPreviousKey()
+	if err != nil {
+		return nil, err
+	}
+	if previousKey == nil {
+		return credentials.GetEncryptedCredentialSerializer(cfg.CredentialsPath, masterKey), nil
+	}
+	return credentials.GetEncryptedCredentialSerializer(cfg.CredentialsPath, masterKey, previousKey), nil
+}
+
+// Pepper returns nil when verifiers are not peppered.
+func (cfg *Config) Pepper() (credentials.Pepper, error) {
+	if cfg.PepperFile == "" {
+		return nil, nil
+	}
+
+	return credentials.LoadPepper(cfg.PepperFile)
+}
+
+// DecoyHMACKey returns the key decoy handshakes are made with, or nil if
+// none is configured. It must stay the same across instances and restarts,
+// so it is never rotated together with the other keys.
+func (cfg *Config) DecoyHMACKey() ([]byte, error) {
+	if cfg.DecoyKey == "" && cfg.DecoyKeyFile == "" {
+		return nil, nil
+	}
+	return masterkey.Load(cfg.DecoyKey, cfg.DecoyKeyFile)
+}
+
+func (cfg *Config) LoggingOptions() (logging.Options, error) {
+	opts := logging.Options{
+		Format: cfg.Log.Format,
+		Levels: make(map[string]slog.Level),
+	}
+	if opts.Format != logging.FormatText && opts.Format != logging.FormatJSON {
+		return opts, fmt.Errorf("format must be %q or %q, got %q", logging.FormatText, logging.FormatJSON, opts.Format)
+	}
+
+	var err error
+	if opts.Level, err = logging.ParseLevel(cfg.Log.Level); err != nil {
+		return opts, fmt.Errorf("level: %w", err)
+	}
+	for subsystem, name := range cfg.Log.Levels {
+		if !slices.Contains(logging.Subsystems, subsystem) {
+			return opts, fmt.Errorf("unknown subsystem %q in levels, expected one of %s", subsystem, strings.Join(logging.Subsystems, ", "))
+		}
+		if opts.Levels[subsystem], err = logging.ParseLevel(name); err != nil {
+			return opts, fmt.Errorf("levels.%s: %w", subsystem, err)
+		}
+	}
+	return opts, nil
+}
+
+// AuditLogger returns audit.Discard when no audit file is configured.
+func (cfg *Config) AuditLogger() (audit.Logger, error) {
+	if cfg.AuditFile == "" {
+		return audit.Discard, nil
+	}
+	key, err := cfg.AuditHMACKey()
+	if err != nil {
+		return nil, err
+	}
+	return audit.NewFileLogger(cfg.AuditFile, key)
+}
+
+// AuditHMACKey returns the key the audit chain is signed with.
+func (cfg *Config) AuditHMACKey() ([]byte, error) {
+	return masterkey.Load(cfg.AuditKey, cfg.AuditKeyFile)
+}
+
+func (cfg *Config) SessionMasterKey() ([]byte, error) {
+	return masterkey.Load(cfg.Session.MasterKey, cfg.Session.MasterKeyFile)
+}
+
+func (cfg *Config) HashType() (srp.HashType, error) {
+	return srp.HashTypeByName(cfg.SRP.Hash)
+}
+
+// Groups returns every group known to the server, the builtin RFC 5054
+// groups followed by any validated custom groups from srp.groupFile.
+func (cfg *Config) Groups() ([]*srp.ConstantGroup, error) {
+	groups := append([]*srp.ConstantGroup{}, srp.BuiltinGroups()...)
+	if cfg.SRP.GroupFile == "" {
+		return groups, nil
+	}
+
+	custom, err := srp.LoadGroupFile(cfg.SRP.GroupFile)
+	if err != nil {
+		return nil, err
+	}
+	for _, group := range custom {
+		if err := srp.ValidateGroup(group); err != nil {
+			return nil, fmt.Errorf("group %q is unsafe: %w", group.Name, err)
+		}
+	}
+
+	return append(groups, custom...), nil
+}
+
+// Group picks the configured srp.group out of groups, refusing groups
+// smaller than srp.minGroupBits.
+func (cfg *Config) Group(groups []*srp.ConstantGroup) (*srp.ConstantGroup, error) {
+	names := make([]string, 0, len(groups))
+	for _, group := range groups {
+		if group.Name == cfg.SRP.Group {
+			if group.NBitLen() < cfg.SRP.MinGroupBits {
+				return nil, fmt.Errorf("group %q has %d bits, srp.minGroupBits requires %d", group.Name, group.NBitLen(), cfg.SRP.MinGroupBits)
+			}
+			return group, nil
+		}
+		names = append(names, group.Name)
+	}
+
+	return nil, fmt.Errorf("unknown group %q, available groups are %s", cfg.SRP.Group, strings.Join(names, ", "))
+}
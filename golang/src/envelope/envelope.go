@@ -0,0 +1,167 @@
+package envelope
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// ContentType marks request and response bodies that are envelopes.
+const ContentType = "application/vnd.srp-auth.envelope+json"
+
+const (
+	Version = 2
+
+	keyInfoPrefix   = "srp-auth envelope v2 "
+	clientDirection = "client"
+	serverDirection = "server"
+	keySize         = 32
+)
+
+var (
+	ErrVersion  = errors.New("unsupported envelope version")
+	ErrDecrypt  = errors.New("envelope does not decrypt under the session key")
+	ErrSequence = errors.New("envelope sequence number must be positive")
+	ErrStale    = errors.New("envelope is too old or from the future")
+)
+
+// Envelope carries an encrypted body. Type is the content type of the
+// plaintext, Seq numbers the requests of a session and Time is when a
+// request was sealed, in Unix seconds. A response carries the Seq of the
+// request it answers. Nonce is random for every envelope.
+type Envelope struct {
+	Version int    `json:"v"`
+	Seq     uint64 `json:"seq"`
+	Time    int64  `json:"ts,omitempty"`
+	Nonce   []byte `json:"nonce"`
+	Type    string `json:"type,omitempty"`
+	Data    []byte `json:"data"`
+}
+
+// Fresh checks that a request was sealed within maxAge of now, in either
+// direction to allow for clock skew. Replay protection only has to
+// remember a request for that long.
+func (env *Envelope) Fresh(now time.Time, maxAge time.Duration) error {
+	sealedAt := time.Unix(env.Time, 0)
+	if sealedAt.Before(now.Add(-maxAge)) || sealedAt.After(now.Add(maxAge)) {
+		return ErrStale
+	}
+	return nil
+}
+
+// SessionKeys holds one AES-256-GCM key per direction, derived with HKDF
+// from the SRP session secret S that client and server share after a
+// login, bound to the session id the server issued for that login. Nonces
+// are random, so a key never depends on the sender keeping count.
+type SessionKeys struct {
+	client cipher.AEAD
+	server cipher.AEAD
+}
+
+func NewSessionKeys(sessionId string, sessionSecret []byte) (*SessionKeys, error) {
+	client, err := newAEAD(sessionId, sessionSecret, clientDirection)
+	if err != nil {
+		return nil, err
+	}
+	server, err := newAEAD(sessionId, sessionSecret, serverDirection)
+	if err != nil {
+		return nil, err
+	}
+	return &SessionKeys{client: client, server: server}, nil
+}
+
+func newAEAD(sessionId string, sessionSecret []byte, direction string) (cipher.AEAD, error) {
+	info := appendField([]byte(keyInfoPrefix+direction), sessionId)
+	key, err := hkdf.Key(sha256.New, sessionSecret, nil, string(info), keySize)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// SealRequest encrypts a request body to method and path, so that it
+// cannot be replayed against another route.
+func (keys *SessionKeys) SealRequest(seq uint64, sealedAt time.Time, method string, path string, contentType string, plaintext []byte) (*Envelope, error) {
+	env := &Envelope{Version: Version, Seq: seq, Time: sealedAt.Unix(), Type: contentType}
+	return env, seal(keys.client, env, plaintext, requestData(method, path))
+}
+
+func (keys *SessionKeys) OpenRequest(env *Envelope, method string, path string) ([]byte, error) {
+	return open(keys.client, env, requestData(method, path))
+}
+
+// SealResponse encrypts a response body to the request it answers and its
+// status code.
+func (keys *SessionKeys) SealResponse(seq uint64, method string, path string, status int, contentType string, plaintext []byte) (*Envelope, error) {
+	env := &Envelope{Version: Version, Seq: seq, Type: contentType}
+	return env, seal(keys.server, env, plaintext, responseData(method, path, status))
+}
+
+func (keys *SessionKeys) OpenResponse(env *Envelope, method string, path string, status int) ([]byte, error) {
+	return open(keys.server, env, responseData(method, path, status))
+}
+
+func requestData(method string, path string) []string {
+	return []string{clientDirection, method, path}
+}
+
+func responseData(method string, path string, status int) []string {
+	return []string{serverDirection, method, path, strconv.Itoa(status)}
+}
+
+func seal(aead cipher.AEAD, env *Envelope, plaintext []byte, data []string) error {
+	if env.Seq == 0 {
+		return ErrSequence
+	}
+
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return err
+	}
+	env.Data = aead.Seal(nil, env.Nonce, plaintext, additionalData(env, data))
+	return nil
+}
+
+func open(aead cipher.AEAD, env *Envelope, data []string) ([]byte, error) {
+	if env.Version != Version {
+		return nil, ErrVersion
+	}
+	if env.Seq == 0 {
+		return nil, ErrSequence
+	}
+	if len(env.Nonce) != aead.NonceSize() {
+		return nil, ErrDecrypt
+	}
+
+	plaintext, err := aead.Open(nil, env.Nonce, env.Data, additionalData(env, data))
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return plaintext, nil
+}
+
+// additionalData authenticates everything about the envelope that is not
+// encrypted, each field prefixed with its length.
+func additionalData(env *Envelope, data []string) []byte {
+	out := binary.BigEndian.AppendUint64([]byte(keyInfoPrefix), env.Seq)
+	out = binary.BigEndian.AppendUint64(out, uint64(env.Time))
+	for _, field := range append(data, env.Type) {
+		out = appendField(out, field)
+	}
+	return out
+}
+
+func appendField(out []byte, field string) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(field)))
+	return append(out, field...)
+}
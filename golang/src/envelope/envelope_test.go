@@ -0,0 +1,158 @@
+package envelope
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+var testSecret = bytes.Repeat([]byte{3}, 32)
+
+func newTestKeys(t *testing.T, sessionId string, secret []byte) *SessionKeys {
+	t.Helper()
+	keys, err := NewSessionKeys(sessionId, secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys
+}
+
+func TestRoundTrip(t *testing.T) {
+	keys := newTestKeys(t, "session", testSecret)
+	now := time.Now()
+
+	req, err := keys.SealRequest(1, now, http.MethodPost, "/notes?id=1", "application/json", []byte(`{"a":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req.Version != Version || req.Seq != 1 || req.Time != now.Unix() || bytes.Contains(req.Data, []byte(`"a"`)) {
+		t.Fatalf("unexpected envelope %+v", req)
+	}
+	plaintext, err := keys.OpenRequest(req, http.MethodPost, "/notes?id=1")
+	if err != nil || string(plaintext) != `{"a":1}` {
+		t.Fatalf("got %q, %v", plaintext, err)
+	}
+
+	resp, err := keys.SealResponse(1, http.MethodPost, "/notes?id=1", http.StatusCreated, "text/plain", []byte("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err = keys.OpenResponse(resp, http.MethodPost, "/notes?id=1", http.StatusCreated)
+	if err != nil || string(plaintext) != "ok" {
+		t.Fatalf("got %q, %v", plaintext, err)
+	}
+
+	// Nonces are random, the same request seals differently
+	again, _ := keys.SealRequest(1, now, http.MethodPost, "/notes?id=1", "application/json", []byte(`{"a":1}`))
+	if bytes.Equal(again.Nonce, req.Nonce) || bytes.Equal(again.Data, req.Data) {
+		t.Error("sealed the same request twice under one nonce")
+	}
+}
+
+// Every field outside the ciphertext is authenticated, along with the route
+// and, for responses, the status.
+func TestTampering(t *testing.T) {
+	keys := newTestKeys(t, "session", testSecret)
+	const method, path = http.MethodPost, "/notes"
+
+	requestTests := map[string]struct {
+		tamper       func(env *Envelope)
+		method, path string
+		want         error
+	}{
+		"seq":         {func(env *Envelope) { env.Seq++ }, method, path, ErrDecrypt},
+		"zero seq":    {func(env *Envelope) { env.Seq = 0 }, method, path, ErrSequence},
+		"ts":          {func(env *Envelope) { env.Time-- }, method, path, ErrDecrypt},
+		"type":        {func(env *Envelope) { env.Type = "text/html" }, method, path, ErrDecrypt},
+		"data":        {func(env *Envelope) { env.Data[0] ^= 1 }, method, path, ErrDecrypt},
+		"nonce":       {func(env *Envelope) { env.Nonce[0] ^= 1 }, method, path, ErrDecrypt},
+		"short nonce": {func(env *Envelope) { env.Nonce = env.Nonce[1:] }, method, path, ErrDecrypt},
+		"version":     {func(env *Envelope) { env.Version = 1 }, method, path, ErrVersion},
+		"method":      {func(*Envelope) {}, http.MethodPut, path, ErrDecrypt},
+		"path":        {func(*Envelope) {}, method, "/notes?id=2", ErrDecrypt},
+	}
+	for name, test := range requestTests {
+		t.Run("request "+name, func(t *testing.T) {
+			env, err := keys.SealRequest(7, time.Now(), method, path, "application/json", []byte("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.tamper(env)
+			if _, err = keys.OpenRequest(env, test.method, test.path); !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	responseTests := map[string]struct {
+		tamper func(env *Envelope)
+		status int
+	}{
+		"seq":    {func(env *Envelope) { env.Seq++ }, http.StatusOK},
+		"type":   {func(env *Envelope) { env.Type = "text/html" }, http.StatusOK},
+		"status": {func(*Envelope) {}, http.StatusNotFound},
+	}
+	for name, test := range responseTests {
+		t.Run("response "+name, func(t *testing.T) {
+			env, err := keys.SealResponse(7, method, path, http.StatusOK, "application/json", []byte("{}"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			test.tamper(env)
+			if _, err = keys.OpenResponse(env, method, path, test.status); !errors.Is(err, ErrDecrypt) {
+				t.Errorf("got %v, want %v", err, ErrDecrypt)
+			}
+		})
+	}
+}
+
+// A request cannot be opened as a response or under another session's keys.
+func TestKeysAreSeparate(t *testing.T) {
+	keys := newTestKeys(t, "session", testSecret)
+	env, err := keys.SealRequest(1, time.Now(), http.MethodPost, "/notes", "", []byte("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = keys.OpenResponse(env, http.MethodPost, "/notes", http.StatusOK); !errors.Is(err, ErrDecrypt) {
+		t.Errorf("opened a request as a response: %v", err)
+	}
+	others := map[string]*SessionKeys{
+		"session id": newTestKeys(t, "other", testSecret),
+		"secret":     newTestKeys(t, "session", bytes.Repeat([]byte{4}, 32)),
+	}
+	for name, other := range others {
+		if _, err = other.OpenRequest(env, http.MethodPost, "/notes"); !errors.Is(err, ErrDecrypt) {
+			t.Errorf("opened under another %s: %v", name, err)
+		}
+	}
+	if _, err = keys.SealRequest(0, time.Now(), http.MethodPost, "/notes", "", nil); !errors.Is(err, ErrSequence) {
+		t.Errorf("sealed seq 0: %v", err)
+	}
+}
+
+func TestFresh(t *testing.T) {
+	now := time.Now()
+	const maxAge = 5 * time.Minute
+	tests := map[string]struct {
+		sealedAt time.Time
+		want     error
+	}{
+		"now":            {now, nil},
+		"just in window": {now.Add(-maxAge + time.Second), nil},
+		"clock skew":     {now.Add(maxAge - time.Second), nil},
+		"stale":          {now.Add(-maxAge - time.Second), ErrStale},
+		"future":         {now.Add(maxAge + time.Second), ErrStale},
+		"zero":           {time.Unix(0, 0), ErrStale},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			env := &Envelope{Time: test.sealedAt.Unix()}
+			if err := env.Fresh(now, maxAge); !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -1,10 +1,16 @@
 module sharpstorm/srp-auth
 
-go 1.18
+go 1.24
 
 require (
 	github.com/google/uuid v1.3.1
 	github.com/julienschmidt/httprouter v1.3.0
 )
 
-require github.com/rs/cors v1.10.0 // indirect
+require (
+	github.com/rs/cors v1.10.0
+	golang.org/x/crypto v0.38.0
+	golang.org/x/term v0.32.0
+)
+
+require golang.org/x/sys v0.33.0 // indirect
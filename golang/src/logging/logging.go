@@ -0,0 +1,117 @@
+// Package logging builds the log/slog logger shared by every subsystem.
+// Each subsystem logs through For(logger, name), which tags its records and
+// applies the level configured for it. Records logged with a context carry
+// the request id stored by WithRequestId.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+)
+
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+
+	SubsystemKey = "subsystem"
+	RequestIdKey = "request_id"
+)
+
+// Subsystems lists the names levels can be set for.
+var Subsystems = []string{"server", "admin", "http", "credentials", "handshake", "session", "audit"}
+
+type Options struct {
+	Format string // FormatText or FormatJSON
+	Level  slog.Level
+	Levels map[string]slog.Level // Per subsystem, overriding Level
+}
+
+func New(w io.Writer, opts Options) *slog.Logger {
+	handlerOpts := &slog.HandlerOptions{
+		// Filtering is done by levelHandler, which knows the subsystem
+		Level:       slog.LevelDebug,
+		ReplaceAttr: redact,
+	}
+
+	var inner slog.Handler
+	if opts.Format == FormatJSON {
+		inner = slog.NewJSONHandler(w, handlerOpts)
+	} else {
+		inner = slog.NewTextHandler(w, handlerOpts)
+	}
+
+	return slog.New(&levelHandler{
+		inner:  inner,
+		level:  opts.Level,
+		levels: opts.Levels,
+	})
+}
+
+// For tags logger with a subsystem. A nil logger means slog.Default().
+func For(logger *slog.Logger, subsystem string) *slog.Logger {
+	if logger == nil {
+		logger = slog.Default()
+	}
+	return logger.With(SubsystemKey, subsystem)
+}
+
+func ParseLevel(name string) (slog.Level, error) {
+	var level slog.Level
+	err := level.UnmarshalText([]byte(name))
+	return level, err
+}
+
+type levelHandler struct {
+	inner  slog.Handler
+	level  slog.Level
+	levels map[string]slog.Level
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+func (h *levelHandler) Handle(ctx context.Context, record slog.Record) error {
+	if requestId := RequestId(ctx); requestId != "" {
+		record = record.Clone()
+		record.AddAttrs(slog.String(RequestIdKey, requestId))
+	}
+	return h.inner.Handle(ctx, record)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.inner = h.inner.WithAttrs(attrs)
+	for _, attr := range attrs {
+		if attr.Key != SubsystemKey {
+			continue
+		}
+		if level, found := h.levels[attr.Value.String()]; found {
+			next.level = level
+		}
+	}
+	return &next
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	next := *h
+	next.inner = h.inner.WithGroup(name)
+	return &next
+}
+
+type contextKey int
+
+const requestIdContextKey contextKey = iota
+
+func WithRequestId(ctx context.Context, requestId string) context.Context {
+	return context.WithValue(ctx, requestIdContextKey, requestId)
+}
+
+func RequestId(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	requestId, _ := ctx.Value(requestIdContextKey).(string)
+	return requestId
+}
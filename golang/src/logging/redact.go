@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"log/slog"
+	"math/big"
+	"strings"
+)
+
+const Redacted = "[redacted]"
+
+// Keys are compared lowercased with "_" and "-" removed, by suffix, so
+// "client_proof" and "sessionId" are caught as well.
+var sensitiveKeySuffixes = []string{
+	"password", "secret", "salt", "verifier", "proof", "token", "key", "sessionid", "legacyhash",
+}
+
+// redact runs on every attribute before it is written. Besides sensitive
+// keys, byte slices and big integers are always dropped: salts, verifiers,
+// proofs, ephemeral keys and session secrets are all one or the other, so
+// they cannot leak under an innocent key. Log session ids as "session_ref"
+// with audit.SessionRef instead.
+func redact(_ []string, attr slog.Attr) slog.Attr {
+	if isSensitiveKey(attr.Key) {
+		return slog.String(attr.Key, Redacted)
+	}
+	if attr.Value.Kind() == slog.KindAny {
+		switch attr.Value.Any().(type) {
+		case []byte, *big.Int:
+			return slog.String(attr.Key, Redacted)
+		}
+	}
+	return attr
+}
+
+func isSensitiveKey(key string) bool {
+	normalized := strings.ToLower(strings.NewReplacer("_", "", "-", "").Replace(key))
+	if normalized == "session" {
+		return true
+	}
+	for _, suffix := range sensitiveKeySuffixes {
+		if strings.HasSuffix(normalized, suffix) {
+			return true
+		}
+	}
+	return false
+}
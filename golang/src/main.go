@@ -1,197 +1,327 @@
 package main
 
 import (
-	"crypto"
-	"encoding/json"
-	"io/ioutil"
+	"context"
+	"errors"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"sharpstorm/srp-auth/auth"
+	"sharpstorm/srp-auth/auth/audit"
 	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/identity"
 	"sharpstorm/srp-auth/auth/session"
 	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/config"
+	"sharpstorm/srp-auth/logging"
+	"sharpstorm/srp-auth/metrics"
+	"sharpstorm/srp-auth/srpauth"
+	"sharpstorm/srp-auth/tlsutil"
+	"syscall"
+	"time"
 
-	"github.com/google/uuid"
-	"github.com/julienschmidt/httprouter"
 	"github.com/rs/cors"
 )
 
-type Handlers struct {
-	credsManager     credentials.CredentialManager
-	handshakeManager auth.HandshakeManager
-	sessionManager   session.SessionManager
-}
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1], os.Args[2:]))
+	}
 
-type HandshakeRequest struct {
-	Username     string `json:"username"`
-	ClientPublic []byte `json:"clientpublic"`
+	runServer()
 }
 
-type HandshakeResponse struct {
-	Salt      []byte `json:"salt"`
-	PublicKey []byte `json:"publickey"`
-	Hid       string `json:"hid"`
-}
+func runServer() {
+	cfg, err := loadConfig(config.PathFromEnv())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err = cfg.ValidateServing(); err != nil {
+		log.Fatalf("invalid config:\n%s", err)
+	}
 
-type VerifyRequest struct {
-	Username    string `json:"username"`
-	Hid         string `json:"hid"`
-	ClientProof []byte `json:"clientproof"`
-}
+	logOpts, _ := cfg.LoggingOptions()
+	logger := logging.New(os.Stderr, logOpts)
+	slog.SetDefault(logger)
+	serverLog := logging.For(logger, "server")
 
-type VerifyResponse struct {
-	Result      bool   `json:"result"`
-	ServerProof []byte `json:"serverproof"`
-	SessionId   string `json:"sessionid"`
-}
+	groups, _ := cfg.Groups()
+	group, _ := cfg.Group(groups)
+	hashType, _ := cfg.HashType()
 
-type WhoAmIRequest struct {
-	SessionId string `json:"sessionid"`
-}
+	srpEngine, err := srp.NewSRPEngine(group, hashType)
+	if err != nil {
+		fatal(serverLog, err)
+	}
+	serializer, err := cfg.CredentialSerializer()
+	if err != nil {
+		fatal(serverLog, err)
+	}
+	pepper, err := cfg.Pepper()
+	if err != nil {
+		fatal(serverLog, err)
+	}
+	auditLog, err := cfg.AuditLogger()
+	if err != nil {
+		fatal(serverLog, err)
+	}
+	credsManager, err := credentials.NewCredentialManager(serializer, srpEngine, credentials.CredentialOptions{
+		Pepper: pepper,
+		Audit:  auditLog,
+		Logger: logger,
+	})
+	if err != nil {
+		fatal(serverLog, err)
+	}
 
-type WhoAmIResponse struct {
-	Proof []byte `json:"proof"`
-}
+	if cfg.SeedAdmin.Username != "" {
+		err = credsManager.AddUser(cfg.SeedAdmin.Username, cfg.SeedAdmin.Password)
+		if err != nil && !errors.Is(err, credentials.ErrUserExists) {
+			fatal(serverLog, err)
+		}
+	}
 
-func main() {
-	srpEngine := srp.NewSRPEngine(auth.SRP_GROUP, auth.SRP_HASH)
-	credsManager := credentials.GetCredentialManager("./users.json", srpEngine)
-	sessionManager := session.NewSessionManager()
+	sessionManager, err := newSessionManager(cfg, auditLog, logger)
+	if err != nil {
+		fatal(serverLog, err)
+	}
 
-	credsManager.AddUser("admin", "password1234")
+	handshakeStore, err := newHandshakeStore(cfg, serverLog)
+	if err != nil {
+		fatal(serverLog, err)
+	}
+
+	decoyKey, err := cfg.DecoyHMACKey()
+	if err != nil {
+		fatal(serverLog, err)
+	}
+	if decoyKey == nil {
+		serverLog.Warn("Decoy handshakes differ between instances and restarts, set decoyKey or decoyKeyFile to keep them the same")
+	}
 
-	handlers := Handlers{
-		credsManager:     credsManager,
-		handshakeManager: auth.NewHandshakeManager(credsManager),
-		sessionManager:   sessionManager,
+	if legacyUsers := credsManager.LegacyUsers(); len(legacyUsers) > 0 {
+		serverLog.Warn("Users still have legacy password hashes, see `srp-auth user legacy`", "count", len(legacyUsers))
 	}
 
-	router := httprouter.New()
-	router.GET("/", handlers.getRoot)
-	router.ServeFiles("/assets/*filepath", http.Dir("../../frontend/assets"))
-	router.POST("/api/auth/handshake", handlers.startHandshake)
-	router.POST("/api/auth/verify", handlers.verifyClient)
-	router.POST("/api/auth/whoami", handlers.whoAmI)
+	metricsRegistry := metrics.NewRegistry()
+	serverOpts := []srpauth.Option{
+		srpauth.WithCredentialManager(credsManager),
+		srpauth.WithSessionManager(sessionManager),
+		srpauth.WithGroups(groups...),
+		srpauth.WithHandshakeLimits(cfg.Handshake.Validity.Duration(), cfg.Handshake.Limit),
+		srpauth.WithHandshakeStore(handshakeStore),
+		srpauth.WithDecoyKey(decoyKey),
+		srpauth.WithChannelBinding(cfg.SRP.ChannelBinding),
+		srpauth.WithRecentAuthWindow(cfg.Session.RecentAuthWindow.Duration()),
+		srpauth.WithAuditLogger(auditLog),
+		srpauth.WithMetrics(metricsRegistry),
+		srpauth.WithLogger(logger),
+	}
+	if cfg.SRP.IdentityKeyFile != "" {
+		identityKeys, err := identity.LoadKeyRing(cfg.SRP.IdentityKeyFile)
+		if err != nil {
+			fatal(serverLog, err)
+		}
+		serverOpts = append(serverOpts, srpauth.WithIdentityKeys(identityKeys))
+	}
+	if cfg.LegacyLogin.Enabled {
+		serverOpts = append(serverOpts,
+			srpauth.WithLegacyLogin(cfg.LegacyLogin.AllowInsecure),
+			srpauth.WithLegacyLoginLimits(cfg.LegacyLogin.MaxAttemptsPerUser, cfg.LegacyLogin.MaxAttemptsPerAddress, cfg.LegacyLogin.AttemptWindow.Duration()),
+		)
+	}
+
+	authServer, err := srpauth.NewServer(serverOpts...)
+	if err != nil {
+		fatal(serverLog, err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", getRoot(cfg.FrontendDir))
+	mux.Handle("GET /assets/", http.StripPrefix("/assets/", http.FileServer(http.Dir(filepath.Join(cfg.FrontendDir, "assets")))))
+	mux.Handle("/api/auth/", authServer.Handler())
 
 	c := cors.New(cors.Options{
-		AllowedOrigins:   []string{"foo.com"},
+		AllowedOrigins:   cfg.CORSOrigins,
 		AllowedMethods:   []string{http.MethodGet, http.MethodPost, http.MethodDelete},
 		AllowCredentials: true,
 	})
 
-	log.Fatal(http.ListenAndServe(":8000", c.Handler(router)))
-}
-
-func (handlers *Handlers) getRoot(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	data, _ := os.ReadFile("../../frontend/index.html")
-	w.Write(data)
-}
-
-func (handlers *Handlers) startHandshake(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	jsonBody, err := ioutil.ReadAll(r.Body)
+	certSource, err := newCertSource(cfg, serverLog)
 	if err != nil {
-		w.WriteHeader(400)
-		return
+		fatal(serverLog, err)
 	}
 
-	var req HandshakeRequest
-	err = json.Unmarshal(jsonBody, &req)
-	if err != nil {
-		w.WriteHeader(400)
-		return
+	httpServer := &http.Server{
+		Addr:              cfg.ListenAddr,
+		Handler:           c.Handler(mux),
+		ReadTimeout:       cfg.Timeouts.Read.Duration(),
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader.Duration(),
+		WriteTimeout:      cfg.Timeouts.Write.Duration(),
+		IdleTimeout:       cfg.Timeouts.Idle.Duration(),
 	}
-
-	handshake, salt, pk := handlers.handshakeManager.GenerateHandshake(req.Username)
-	if handshake == nil || salt == nil || pk == nil {
-		w.WriteHeader(400)
-		return
+	if certSource != nil {
+		httpServer.TLSConfig = tlsutil.ServerConfig(certSource)
 	}
 
-	err = handshake.Verifier.SetClientPublicKey(req.ClientPublic)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- listenAndServe(httpServer)
+	}()
+
+	adminServer, err := newAdminServer(cfg, metricsRegistry, authServer, certSource)
 	if err != nil {
-		w.WriteHeader(400)
-		return
+		fatal(serverLog, err)
+	}
+	if adminServer != nil {
+		go func() {
+			if err := listenAndServe(adminServer); !errors.Is(err, http.ErrServerClosed) {
+				logging.For(logger, "admin").Error("Stopped serving", "err", err)
+			}
+		}()
 	}
 
-	result, _ := json.Marshal(HandshakeResponse{
-		Salt:      salt,
-		PublicKey: pk,
-		Hid:       handshake.HandshakeId,
-	})
+	// A listener that fails, e.g. on a port in use, still flushes state but
+	// exits non-zero so supervisors see the failure
+	exitCode := 0
+	select {
+	case err = <-serveErr:
+		serverLog.Error("Stopped serving", "err", err)
+		if !errors.Is(err, http.ErrServerClosed) {
+			exitCode = 1
+		}
+	case <-ctx.Done():
+		stop()
+		shutdown(serverLog, httpServer, authServer, cfg.Timeouts.Shutdown.Duration())
+	}
+	if adminServer != nil {
+		adminServer.Close()
+	}
+
+	if err = authServer.Close(); err != nil {
+		serverLog.Error("Failed to flush state", "err", err)
+		os.Exit(1)
+	}
+	serverLog.Info("Stopped")
+	os.Exit(exitCode)
+}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(result)
+func listenAndServe(server *http.Server) error {
+	if server.TLSConfig != nil {
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
 }
 
-func (handlers *Handlers) verifyClient(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	jsonBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(400)
-		return
+// newCertSource returns nil when TLS is not configured.
+func newCertSource(cfg *config.Config, serverLog *slog.Logger) (tlsutil.CertSource, error) {
+	if !cfg.TLSEnabled() {
+		return nil, nil
 	}
 
-	var req VerifyRequest
-	err = json.Unmarshal(jsonBody, &req)
-	if err != nil {
-		w.WriteHeader(400)
-		return
+	if cfg.TLS.SelfSigned {
+		host, _, _ := net.SplitHostPort(cfg.ListenAddr)
+		hostname, _ := os.Hostname()
+		created, err := tlsutil.EnsureSelfSigned(cfg.TLS.CertFile, cfg.TLS.KeyFile, []string{host, hostname})
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			serverLog.Warn("Created a self-signed certificate, do not use it in production", "file", cfg.TLS.CertFile)
+		}
 	}
+	return tlsutil.LoadCertFiles(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+}
 
-	handshake := handlers.handshakeManager.ConsumeHandshake(req.Username, req.Hid)
-	if handshake == nil {
-		w.WriteHeader(400)
-		return
+// shutdown keeps serving verify requests until outstanding handshakes are
+// done, then lets in-flight requests finish. Both share the timeout.
+func shutdown(serverLog *slog.Logger, httpServer *http.Server, authServer *srpauth.Server, timeout time.Duration) {
+	serverLog.Info("Shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	authServer.Drain(ctx)
+	if err := httpServer.Shutdown(ctx); err != nil {
+		serverLog.Warn("Forced shutdown", "err", err)
+		httpServer.Close()
 	}
+}
 
-	isValid := handshake.Verifier.IsClientProofValid(req.ClientProof)
-	result := false
-	serverProof := []byte{}
-	sessionId := ""
-	if isValid {
-		result = true
-		serverProof = handshake.Verifier.GetServerProof()
-		sessionId = uuid.NewString()
-		handlers.sessionManager.RegisterSession(req.Username, sessionId, handshake.Verifier.GetSessionSecret())
+func newHandshakeStore(cfg *config.Config, serverLog *slog.Logger) (auth.HandshakeStore, error) {
+	if cfg.Handshake.Store != config.HandshakeStoreSealed {
+		return auth.NewMemoryHandshakeStore(cfg.Handshake.Validity.Duration(), cfg.Handshake.Limit), nil
 	}
 
-	respBody, _ := json.Marshal(VerifyResponse{
-		Result:      result,
-		ServerProof: serverProof,
-		SessionId:   sessionId,
-	})
+	keyRing, err := session.LoadTokenKeyRing(cfg.HandshakeKeyFile())
+	if err != nil {
+		return nil, err
+	}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(respBody)
+	replay := session.NewMemoryRevocationList()
+	if cfg.Handshake.ReplayFile != "" {
+		if replay, err = session.NewFileRevocationList(cfg.Handshake.ReplayFile); err != nil {
+			return nil, err
+		}
+	} else {
+		serverLog.Warn("Used handshakes are not shared between instances, set handshake.replayFile")
+	}
+	return auth.NewSealedHandshakeStore(keyRing, replay), nil
 }
 
-func (handlers *Handlers) whoAmI(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
-	jsonBody, err := ioutil.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(400)
-		return
+func newSessionManager(cfg *config.Config, auditLog audit.Logger, logger *slog.Logger) (session.SessionManager, error) {
+	opts := session.SessionOptions{
+		MaxPerUser: cfg.Session.MaxPerUser,
+		TTL:        cfg.Session.TTL.Duration(),
+		Audit:      auditLog,
+		Logger:     logger,
 	}
 
-	var req WhoAmIRequest
-	err = json.Unmarshal(jsonBody, &req)
-	if err != nil {
-		w.WriteHeader(400)
-		return
+	if cfg.Session.Mode == config.SessionModeToken {
+		keyRing, err := session.LoadTokenKeyRing(cfg.Session.TokenKeyFile)
+		if err != nil {
+			return nil, err
+		}
+
+		revoked := session.NewMemoryRevocationList()
+		if cfg.Session.RevocationFile != "" {
+			if revoked, err = session.NewFileRevocationList(cfg.Session.RevocationFile); err != nil {
+				return nil, err
+			}
+		} else {
+			logging.For(logger, "server").Warn("Session revocations are not shared between instances, set session.revocationFile")
+		}
+		return session.NewTokenSessionManager(keyRing, revoked, opts)
 	}
 
-	if !handlers.sessionManager.IsActive(req.SessionId) {
-		w.WriteHeader(403)
-		return
+	store := session.NewMemoryStore()
+	if cfg.Session.Store == config.SessionStoreFile {
+		masterKey, err := cfg.SessionMasterKey()
+		if err != nil {
+			return nil, err
+		}
+		if store, err = session.NewFileStore(cfg.Session.File, masterKey); err != nil {
+			return nil, err
+		}
 	}
+	return session.NewSessionManager(store, opts), nil
+}
 
-	session, username := handlers.sessionManager.GetSession(req.SessionId)
-	hasher := crypto.SHA512.New()
-	hasher.Write([]byte(username))
-	hasher.Write(session.Secret)
-	respBody, _ := json.Marshal(WhoAmIResponse{
-		Proof: hasher.Sum(nil),
-	})
+// fatal is for errors that stop the server from starting.
+func fatal(serverLog *slog.Logger, err error) {
+	serverLog.Error("Failed to start", "err", err)
+	os.Exit(1)
+}
 
-	w.Header().Add("Content-Type", "application/json")
-	w.Write(respBody)
+func getRoot(frontendDir string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, _ := os.ReadFile(filepath.Join(frontendDir, "index.html"))
+		w.Write(data)
+	}
 }
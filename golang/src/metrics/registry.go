@@ -0,0 +1,164 @@
+// Package metrics is a small in-process implementation of counters, gauges
+// and histograms, exposed in the Prometheus text format. Nothing is pushed
+// anywhere, a scraper reads the current values from Registry.Handler.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets suit request latencies, in seconds.
+var DefaultBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
+
+type Registry struct {
+	families []*family
+	names    map[string]bool
+	lock     sync.Mutex
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		names: make(map[string]bool),
+	}
+}
+
+// sample is one series of a family, writing its own lines.
+type sample interface {
+	write(w *bufio.Writer, name string, labels string)
+}
+
+type family struct {
+	name       string
+	help       string
+	kind       string
+	labelNames []string
+
+	children map[string]sample
+	labels   map[string]string
+	lock     sync.Mutex
+}
+
+// register panics on a duplicate name, as that is a programming error.
+func (reg *Registry) register(name string, help string, kind string, labelNames []string) *family {
+	reg.lock.Lock()
+	defer reg.lock.Unlock()
+
+	if reg.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	reg.names[name] = true
+
+	f := &family{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		labelNames: labelNames,
+		children:   make(map[string]sample),
+		labels:     make(map[string]string),
+	}
+	reg.families = append(reg.families, f)
+	return f
+}
+
+func (f *family) child(values []string, create func() sample) sample {
+	if len(values) != len(f.labelNames) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labelNames), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if existing, found := f.children[key]; found {
+		return existing
+	}
+
+	pairs := make([]string, len(values))
+	for i, value := range values {
+		pairs[i] = f.labelNames[i] + "=" + quoteLabel(value)
+	}
+	created := create()
+	f.children[key] = created
+	f.labels[key] = strings.Join(pairs, ",")
+	return created
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.lock.Lock()
+	keys := make([]string, 0, len(f.children))
+	for key := range f.children {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	children := make([]sample, len(keys))
+	labels := make([]string, len(keys))
+	for i, key := range keys {
+		children[i], labels[i] = f.children[key], f.labels[key]
+	}
+	f.lock.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	for i, child := range children {
+		child.write(w, f.name, labels[i])
+	}
+}
+
+// Write writes every metric in the text exposition format.
+func (reg *Registry) Write(w io.Writer) error {
+	reg.lock.Lock()
+	families := append([]*family{}, reg.families...)
+	reg.lock.Unlock()
+
+	buffered := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(buffered)
+	}
+	return buffered.Flush()
+}
+
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		reg.Write(w)
+	})
+}
+
+func writeLine(w *bufio.Writer, name string, labels string, value float64) {
+	w.WriteString(name)
+	if labels != "" {
+		w.WriteString("{" + labels + "}")
+	}
+	w.WriteString(" " + formatValue(value) + "\n")
+}
+
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+func quoteLabel(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, "\n", `\n`)
+	return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+}
+
+func escapeHelp(help string) string {
+	help = strings.ReplaceAll(help, `\`, `\\`)
+	return strings.ReplaceAll(help, "\n", `\n`)
+}
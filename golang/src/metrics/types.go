@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"bufio"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+type Counter struct {
+	value atomic.Uint64
+}
+
+func (c *Counter) Inc() {
+	c.value.Add(1)
+}
+
+func (c *Counter) write(w *bufio.Writer, name string, labels string) {
+	writeLine(w, name, labels, float64(c.value.Load()))
+}
+
+type CounterVec struct {
+	family *family
+}
+
+func (reg *Registry) NewCounter(name string, help string) *Counter {
+	return reg.NewCounterVec(name, help).With()
+}
+
+func (reg *Registry) NewCounterVec(name string, help string, labelNames ...string) *CounterVec {
+	return &CounterVec{family: reg.register(name, help, "counter", labelNames)}
+}
+
+// With returns the counter for the label values, in the order the label
+// names were registered.
+func (v *CounterVec) With(values ...string) *Counter {
+	return v.family.child(values, func() sample { return &Counter{} }).(*Counter)
+}
+
+type Gauge struct {
+	value atomic.Int64
+}
+
+func (g *Gauge) Set(value int64) {
+	g.value.Store(value)
+}
+
+func (g *Gauge) Add(delta int64) {
+	g.value.Add(delta)
+}
+
+func (g *Gauge) write(w *bufio.Writer, name string, labels string) {
+	writeLine(w, name, labels, float64(g.value.Load()))
+}
+
+func (reg *Registry) NewGauge(name string, help string) *Gauge {
+	f := reg.register(name, help, "gauge", nil)
+	return f.child(nil, func() sample { return &Gauge{} }).(*Gauge)
+}
+
+type gaugeFunc func() float64
+
+func (fn gaugeFunc) write(w *bufio.Writer, name string, labels string) {
+	writeLine(w, name, labels, fn())
+}
+
+// NewGaugeFunc reports the value of fn at scrape time. It is called from
+// the scraping goroutine and must be safe for concurrent use.
+func (reg *Registry) NewGaugeFunc(name string, help string, fn func() float64) {
+	f := reg.register(name, help, "gauge", nil)
+	f.child(nil, func() sample { return gaugeFunc(fn) })
+}
+
+type Histogram struct {
+	upperBounds []float64
+	counts      []uint64 // Per bucket, made cumulative when written
+	count       uint64
+	sum         float64
+	lock        sync.Mutex
+}
+
+func (h *Histogram) Observe(value float64) {
+	bucket := sort.SearchFloat64s(h.upperBounds, value)
+
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if bucket < len(h.counts) {
+		h.counts[bucket]++
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *Histogram) write(w *bufio.Writer, name string, labels string) {
+	h.lock.Lock()
+	counts := append([]uint64{}, h.counts...)
+	count, sum := h.count, h.sum
+	h.lock.Unlock()
+
+	prefix := labels
+	if prefix != "" {
+		prefix += ","
+	}
+	cumulative := uint64(0)
+	for i, upperBound := range h.upperBounds {
+		cumulative += counts[i]
+		writeLine(w, name+"_bucket", prefix+"le="+quoteLabel(formatValue(upperBound)), float64(cumulative))
+	}
+	writeLine(w, name+"_bucket", prefix+"le="+quoteLabel(formatValue(math.Inf(1))), float64(count))
+	writeLine(w, name+"_sum", labels, sum)
+	writeLine(w, name+"_count", labels, float64(count))
+}
+
+type HistogramVec struct {
+	family  *family
+	buckets []float64
+}
+
+func (reg *Registry) NewHistogram(name string, help string, buckets []float64) *Histogram {
+	return reg.NewHistogramVec(name, help, buckets).With()
+}
+
+// NewHistogramVec takes sorted bucket upper bounds, without +Inf.
+func (reg *Registry) NewHistogramVec(name string, help string, buckets []float64, labelNames ...string) *HistogramVec {
+	if !sort.Float64sAreSorted(buckets) {
+		panic("metrics: buckets of " + name + " are not sorted")
+	}
+	return &HistogramVec{
+		family:  reg.register(name, help, "histogram", labelNames),
+		buckets: buckets,
+	}
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return v.family.child(values, func() sample {
+		return &Histogram{
+			upperBounds: v.buckets,
+			counts:      make([]uint64, len(v.buckets)),
+		}
+	}).(*Histogram)
+}
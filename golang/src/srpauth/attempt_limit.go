@@ -0,0 +1,99 @@
+package srpauth
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// attemptLimiter counts legacy logins per username and per remote address
+// in fixed windows. Behind a proxy every request shares the proxy's
+// address, size perAddress for that.
+type attemptLimiter struct {
+	lock       sync.Mutex
+	perUser    int
+	perAddress int
+	window     time.Duration
+	attempts   map[string]*attemptWindow
+	lastSweep  time.Time
+}
+
+type attemptWindow struct {
+	start time.Time
+	count int
+}
+
+func newAttemptLimiter(perUser int, perAddress int, window time.Duration) *attemptLimiter {
+	return &attemptLimiter{
+		perUser:    perUser,
+		perAddress: perAddress,
+		window:     window,
+		attempts:   make(map[string]*attemptWindow),
+	}
+}
+
+// allow counts an attempt for username from remoteAddr. Once either limit
+// is used up it returns an error telling the client how long to wait, and
+// the refused attempt is not counted.
+func (limiter *attemptLimiter) allow(username string, remoteAddr string) error {
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		remoteAddr = host
+	}
+	now := time.Now()
+
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.sweep(now)
+	user := limiter.current("user:"+username, now)
+	address := limiter.current("addr:"+remoteAddr, now)
+
+	var reset time.Time
+	if user.count >= limiter.perUser {
+		reset = user.start.Add(limiter.window)
+	}
+	if address.count >= limiter.perAddress && address.start.Add(limiter.window).After(reset) {
+		reset = address.start.Add(limiter.window)
+	}
+	if !reset.IsZero() {
+		return tooManyAttempts(reset.Sub(now))
+	}
+
+	user.count++
+	address.count++
+	return nil
+}
+
+func (limiter *attemptLimiter) current(key string, now time.Time) *attemptWindow {
+	attempts, found := limiter.attempts[key]
+	if !found || now.Sub(attempts.start) >= limiter.window {
+		attempts = &attemptWindow{start: now}
+		limiter.attempts[key] = attempts
+	}
+	return attempts
+}
+
+// sweep drops windows that have ended, at most once per window.
+func (limiter *attemptLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < limiter.window {
+		return
+	}
+	limiter.lastSweep = now
+	for key, attempts := range limiter.attempts {
+		if now.Sub(attempts.start) >= limiter.window {
+			delete(limiter.attempts, key)
+		}
+	}
+}
+
+func tooManyAttempts(wait time.Duration) *apiError {
+	return &apiError{
+		status:     http.StatusTooManyRequests,
+		code:       CodeTooManyAttempts,
+		message:    "too many attempts, try again later",
+		retryable:  true,
+		retryAfter: max(1, int(math.Ceil(wait.Seconds()))),
+	}
+}
@@ -0,0 +1,73 @@
+package srpauth
+
+import (
+	"crypto/tls"
+	"errors"
+	"net/http"
+	"slices"
+)
+
+// Channel binding policies, see WithChannelBinding.
+const (
+	ChannelBindingOff      = "off"
+	ChannelBindingOptional = "optional"
+	ChannelBindingRequired = "required"
+)
+
+// ChannelBindingTLSExporter binds the proofs to the TLS connection they are
+// sent on through the RFC 9266 tls-exporter value.
+const ChannelBindingTLSExporter = "tls-exporter"
+
+const (
+	tlsExporterLabel  = "EXPORTER-Channel-Binding"
+	tlsExporterLength = 32
+)
+
+var errNoChannelBinding = errors.New("channel binding is not available on this connection")
+
+// TLSExporter returns the RFC 9266 tls-exporter channel binding of a TLS
+// connection. It is only defined for TLS 1.3 and for TLS 1.2 with the
+// extended master secret extension.
+func TLSExporter(state *tls.ConnectionState) ([]byte, error) {
+	if state == nil {
+		return nil, errNoChannelBinding
+	}
+	return state.ExportKeyingMaterial(tlsExporterLabel, []byte{}, tlsExporterLength)
+}
+
+// negotiateChannelBinding picks the binding type for a handshake from those
+// the client offered. Requests reaching the server through a TLS
+// terminating proxy arrive without TLS and cannot be bound.
+func (srv *Server) negotiateChannelBinding(r *http.Request, offered []string) (string, error) {
+	if srv.channelBinding == ChannelBindingOff {
+		return "", nil
+	}
+
+	if slices.Contains(offered, ChannelBindingTLSExporter) {
+		if _, err := TLSExporter(r.TLS); err == nil {
+			return ChannelBindingTLSExporter, nil
+		}
+	}
+	if srv.channelBinding == ChannelBindingRequired {
+		return "", errChannelBindingRequired
+	}
+	return "", nil
+}
+
+// bindChannel binds the handshake's verifier to the connection the proof
+// arrived on.
+func bindChannel(r *http.Request, channelBinding string, verifier interface{ BindChannel([]byte) }) error {
+	switch channelBinding {
+	case "":
+		return nil
+	case ChannelBindingTLSExporter:
+		exporter, err := TLSExporter(r.TLS)
+		if err != nil {
+			return errChannelBindingRequired
+		}
+		verifier.BindChannel(exporter)
+		return nil
+	default:
+		return errChannelBindingRequired
+	}
+}
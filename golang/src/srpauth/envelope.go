@@ -0,0 +1,199 @@
+package srpauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sharpstorm/srp-auth/auth/session"
+	"sharpstorm/srp-auth/envelope"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	maxEnvelopeBytes      = 1 << 20
+	maxEnvelopeAge        = 5 * time.Minute
+	envelopeSweepInterval = time.Minute
+)
+
+type envelopeChannel struct {
+	secret []byte
+	keys   *envelope.SessionKeys
+}
+
+// envelopeChannels caches the keys of every session that sent an envelope
+// to this instance. Which envelopes were used is kept in the server's
+// replay cache instead, which may be shared.
+type envelopeChannels struct {
+	channels  map[string]*envelopeChannel
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+// RequireEnvelope protects a route with the SRP session key, for when TLS
+// terminates somewhere that should not see the data. It runs RequireSession
+// first. The request body must be an envelope.Envelope sealed for the
+// route, see srpclient.Client.Do; the handler sees the plaintext and its
+// response is sealed the same way. Each envelope is accepted once, within
+// maxEnvelopeAge of when it was sealed. Envelopes are only read from
+// request bodies, so opted in routes should not use GET.
+func (srv *Server) RequireEnvelope(next http.Handler) http.Handler {
+	return srv.RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionObj, _ := SessionFromContext(r.Context())
+		channel, err := srv.envelopes.get(sessionObj, srv.sessionManager)
+		if err != nil {
+			srv.writeError(w, r, err)
+			return
+		}
+
+		req, err := decodeEnvelope(w, r)
+		if err != nil {
+			srv.writeError(w, r, err)
+			return
+		}
+		plaintext, err := channel.keys.OpenRequest(req, r.Method, r.RequestURI)
+		if err != nil {
+			srv.writeError(w, r, errInvalidEnvelope)
+			return
+		}
+		if err = req.Fresh(time.Now(), maxEnvelopeAge); err != nil {
+			srv.writeError(w, r, errReplayedEnvelope)
+			return
+		}
+		unused, err := srv.envelopeReplay.Claim(envelopeReplayId(sessionObj.Id, req.Seq), time.Unix(req.Time, 0).Add(maxEnvelopeAge))
+		if err != nil {
+			srv.writeError(w, r, err)
+			return
+		} else if !unused {
+			srv.writeError(w, r, errReplayedEnvelope)
+			return
+		}
+
+		inner := r.Clone(r.Context())
+		inner.Body = io.NopCloser(bytes.NewReader(plaintext))
+		inner.ContentLength = int64(len(plaintext))
+		inner.Header.Set("Content-Type", req.Type)
+		recorder := &envelopeRecorder{header: make(http.Header)}
+		next.ServeHTTP(recorder, inner)
+
+		status := recorder.statusCode()
+		resp, err := channel.keys.SealResponse(req.Seq, r.Method, r.RequestURI, status, recorder.header.Get("Content-Type"), recorder.body.Bytes())
+		if err != nil {
+			srv.writeError(w, r, err)
+			return
+		}
+		body, _ := json.Marshal(resp)
+
+		for key, values := range recorder.header {
+			w.Header()[key] = values
+		}
+		w.Header().Set("Content-Type", envelope.ContentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.WriteHeader(status)
+		w.Write(body)
+	}))
+}
+
+func decodeEnvelope(w http.ResponseWriter, r *http.Request) (*envelope.Envelope, error) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != envelope.ContentType {
+		return nil, errEnvelopeRequired
+	}
+
+	var req envelope.Envelope
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxEnvelopeBytes))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			return nil, errRequestTooLarge
+		}
+		return nil, errInvalidEnvelope
+	}
+	return &req, nil
+}
+
+func (envelopes *envelopeChannels) get(sessionObj *session.Session, sessionManager session.SessionManager) (*envelopeChannel, error) {
+	envelopes.lock.Lock()
+	defer envelopes.lock.Unlock()
+
+	// Reauthentication replaces the secret of a stateful session, the
+	// channel starts over under the new keys.
+	if channel, found := envelopes.channels[sessionObj.Id]; found && subtle.ConstantTimeCompare(channel.secret, sessionObj.Secret) == 1 {
+		return channel, nil
+	}
+
+	keys, err := envelope.NewSessionKeys(sessionObj.Id, sessionObj.Secret)
+	if err != nil {
+		return nil, err
+	}
+	if envelopes.channels == nil {
+		envelopes.channels = make(map[string]*envelopeChannel)
+	}
+	envelopes.sweep(sessionManager)
+
+	channel := &envelopeChannel{secret: sessionObj.Secret, keys: keys}
+	envelopes.channels[sessionObj.Id] = channel
+	return channel, nil
+}
+
+// envelopeReplayId keeps token session ids, which are long, out of the
+// replay cache.
+func envelopeReplayId(sessionId string, seq uint64) string {
+	digest := sha256.Sum256(binary.BigEndian.AppendUint64([]byte(sessionId), seq))
+	return "envelope:" + hex.EncodeToString(digest[:])
+}
+
+// sweep drops the channels of sessions that ended, which can no longer
+// send envelopes anyway.
+func (envelopes *envelopeChannels) sweep(sessionManager session.SessionManager) {
+	if time.Since(envelopes.lastSweep) < envelopeSweepInterval {
+		return
+	}
+	envelopes.lastSweep = time.Now()
+
+	for sessionId := range envelopes.channels {
+		if !sessionManager.IsActive(sessionId) {
+			delete(envelopes.channels, sessionId)
+		}
+	}
+}
+
+// envelopeRecorder buffers the handler's response so it can be sealed.
+type envelopeRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (recorder *envelopeRecorder) Header() http.Header {
+	return recorder.header
+}
+
+func (recorder *envelopeRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+}
+
+func (recorder *envelopeRecorder) Write(dat []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.body.Write(dat)
+}
+
+func (recorder *envelopeRecorder) statusCode() int {
+	if recorder.status == 0 {
+		return http.StatusOK
+	}
+	return recorder.status
+}
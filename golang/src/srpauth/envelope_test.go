@@ -0,0 +1,152 @@
+package srpauth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sharpstorm/srp-auth/envelope"
+	"testing"
+	"time"
+)
+
+const envelopePath = "/notes?id=1"
+
+// newEnvelopeRoute serves an echo handler behind RequireEnvelope and logs
+// bob in with secret.
+func newEnvelopeRoute(t *testing.T, secret []byte) (*Server, *httptest.Server, string) {
+	t.Helper()
+	srv := newServer(t)
+	ts := httptest.NewServer(srv.RequireEnvelope(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", r.Header.Get("Content-Type"))
+		w.WriteHeader(http.StatusCreated)
+		w.Write(body)
+	})))
+	t.Cleanup(ts.Close)
+
+	sessionId, err := srv.SessionManager().CreateSession(context.Background(), "bob", secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv, ts, sessionId
+}
+
+func sendEnvelope(t *testing.T, ts *httptest.Server, sessionId string, env *envelope.Envelope) (*http.Response, []byte) {
+	t.Helper()
+	body, _ := json.Marshal(env)
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+envelopePath, bytes.NewReader(body))
+	req.Header.Set("Content-Type", envelope.ContentType)
+	req.Header.Set(SessionHeader, sessionId)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	return resp, respBody
+}
+
+func expectEnvelopeError(t *testing.T, resp *http.Response, body []byte, wantStatus int, wantCode string) {
+	t.Helper()
+	var apiErr ErrorResponse
+	json.Unmarshal(body, &apiErr)
+	expect(t, resp.StatusCode, apiErr.Code, wantStatus, wantCode)
+}
+
+func sealEnvelope(t *testing.T, keys *envelope.SessionKeys, seq uint64, sealedAt time.Time, path string) *envelope.Envelope {
+	t.Helper()
+	env, err := keys.SealRequest(seq, sealedAt, http.MethodPost, path, "application/json", []byte(`{"n":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return env
+}
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+	secret := bytes.Repeat([]byte{1}, 32)
+	_, ts, sessionId := newEnvelopeRoute(t, secret)
+	keys, _ := envelope.NewSessionKeys(sessionId, secret)
+
+	resp, body := sendEnvelope(t, ts, sessionId, sealEnvelope(t, keys, 1, time.Now(), envelopePath))
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Content-Type") != envelope.ContentType {
+		t.Fatalf("got %d %s: %s", resp.StatusCode, resp.Header.Get("Content-Type"), body)
+	}
+	var sealed envelope.Envelope
+	if err := json.Unmarshal(body, &sealed); err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := keys.OpenResponse(&sealed, http.MethodPost, envelopePath, http.StatusCreated)
+	if err != nil || string(plaintext) != `{"n":1}` || sealed.Type != "application/json" || sealed.Seq != 1 {
+		t.Errorf("got %q, %v, %+v", plaintext, err, sealed)
+	}
+}
+
+func TestEnvelopeRejects(t *testing.T) {
+	secret := bytes.Repeat([]byte{1}, 32)
+	_, ts, sessionId := newEnvelopeRoute(t, secret)
+	keys, _ := envelope.NewSessionKeys(sessionId, secret)
+
+	// Each envelope is used once, another seq goes through
+	env := sealEnvelope(t, keys, 1, time.Now(), envelopePath)
+	if resp, body := sendEnvelope(t, ts, sessionId, env); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("first use: %d %s", resp.StatusCode, body)
+	}
+	resp, body := sendEnvelope(t, ts, sessionId, env)
+	expectEnvelopeError(t, resp, body, http.StatusConflict, CodeReplayedEnvelope)
+	resp, body = sendEnvelope(t, ts, sessionId, sealEnvelope(t, keys, 1, time.Now(), envelopePath))
+	expectEnvelopeError(t, resp, body, http.StatusConflict, CodeReplayedEnvelope)
+	if resp, body = sendEnvelope(t, ts, sessionId, sealEnvelope(t, keys, 2, time.Now(), envelopePath)); resp.StatusCode != http.StatusCreated {
+		t.Errorf("next seq: %d %s", resp.StatusCode, body)
+	}
+
+	tests := map[string]struct {
+		env        *envelope.Envelope
+		wantStatus int
+		wantCode   string
+	}{
+		"stale":      {sealEnvelope(t, keys, 3, time.Now().Add(-maxEnvelopeAge-time.Minute), envelopePath), http.StatusConflict, CodeReplayedEnvelope},
+		"future":     {sealEnvelope(t, keys, 4, time.Now().Add(maxEnvelopeAge+time.Minute), envelopePath), http.StatusConflict, CodeReplayedEnvelope},
+		"other path": {sealEnvelope(t, keys, 5, time.Now(), "/notes?id=2"), http.StatusBadRequest, CodeInvalidEnvelope},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			resp, body := sendEnvelope(t, ts, sessionId, test.env)
+			expectEnvelopeError(t, resp, body, test.wantStatus, test.wantCode)
+		})
+	}
+
+	tampered := sealEnvelope(t, keys, 6, time.Now(), envelopePath)
+	tampered.Seq = 7
+	resp, body = sendEnvelope(t, ts, sessionId, tampered)
+	expectEnvelopeError(t, resp, body, http.StatusBadRequest, CodeInvalidEnvelope)
+
+	resp, body = sendEnvelope(t, ts, "", sealEnvelope(t, keys, 8, time.Now(), envelopePath))
+	expectEnvelopeError(t, resp, body, http.StatusUnauthorized, CodeInvalidSession)
+}
+
+// Reauthentication replaces the session secret, envelopes sealed under the
+// old keys are refused from then on.
+func TestEnvelopeKeysChangeOnReauth(t *testing.T) {
+	oldSecret := bytes.Repeat([]byte{1}, 32)
+	srv, ts, sessionId := newEnvelopeRoute(t, oldSecret)
+	oldKeys, _ := envelope.NewSessionKeys(sessionId, oldSecret)
+	if resp, body := sendEnvelope(t, ts, sessionId, sealEnvelope(t, oldKeys, 1, time.Now(), envelopePath)); resp.StatusCode != http.StatusCreated {
+		t.Fatalf("before reauth: %d %s", resp.StatusCode, body)
+	}
+
+	newSecret := bytes.Repeat([]byte{2}, 32)
+	sessionId, err := srv.SessionManager().Reauthenticate(context.Background(), sessionId, newSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newKeys, _ := envelope.NewSessionKeys(sessionId, newSecret)
+
+	resp, body := sendEnvelope(t, ts, sessionId, sealEnvelope(t, oldKeys, 2, time.Now(), envelopePath))
+	expectEnvelopeError(t, resp, body, http.StatusBadRequest, CodeInvalidEnvelope)
+	if resp, body = sendEnvelope(t, ts, sessionId, sealEnvelope(t, newKeys, 3, time.Now(), envelopePath)); resp.StatusCode != http.StatusCreated {
+		t.Errorf("after reauth: %d %s", resp.StatusCode, body)
+	}
+}
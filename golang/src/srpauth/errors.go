@@ -0,0 +1,113 @@
+package srpauth
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sharpstorm/srp-auth/auth"
+	"sharpstorm/srp-auth/auth/session"
+	"strconv"
+)
+
+// Error codes sent in ErrorResponse.Code. They are stable, clients should
+// switch on them rather than on the status or message.
+const (
+	CodeBadRequest          = "bad_request"
+	CodeUnsupportedMedia    = "unsupported_media_type"
+	CodeRequestTooLarge     = "request_too_large"
+	CodeNotFound            = "not_found"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeInvalidClientPublic = "invalid_client_public"
+	CodeInvalidHandshake    = "invalid_handshake"
+	CodeInvalidCredentials  = "invalid_credentials"
+	CodeInvalidSession      = "invalid_session"
+	CodeTLSRequired         = "tls_required"
+	CodeChannelBinding      = "channel_binding_required"
+	CodeInvalidEnvelope     = "invalid_envelope"
+	CodeReplayedEnvelope    = "replayed_envelope"
+	CodeReauthRequired      = "reauthentication_required"
+	CodeTooManyAttempts     = "too_many_attempts"
+	CodeUnavailable         = "unavailable"
+	CodeInternal            = "internal_error"
+)
+
+const unavailableRetryAfter = 5
+
+type apiError struct {
+	status     int
+	code       string
+	message    string
+	retryable  bool
+	retryAfter int // Seconds, 0 sends no Retry-After
+}
+
+func (apiErr *apiError) Error() string {
+	return apiErr.message
+}
+
+// Unknown users and wrong passwords both end in errInvalidCredentials, at
+// verify time, so that responses do not tell which usernames exist.
+var (
+	errBadRequest             = &apiError{status: http.StatusBadRequest, code: CodeBadRequest, message: "malformed request"}
+	errUnsupportedMediaType   = &apiError{status: http.StatusUnsupportedMediaType, code: CodeUnsupportedMedia, message: "requests must be application/json"}
+	errRequestTooLarge        = &apiError{status: http.StatusRequestEntityTooLarge, code: CodeRequestTooLarge, message: "request body too large"}
+	errNotFound               = &apiError{status: http.StatusNotFound, code: CodeNotFound, message: "no such endpoint"}
+	errMethodNotAllowed       = &apiError{status: http.StatusMethodNotAllowed, code: CodeMethodNotAllowed, message: "method not allowed for this endpoint"}
+	errInvalidClientPublic    = &apiError{status: http.StatusBadRequest, code: CodeInvalidClientPublic, message: "invalid client public key"}
+	errInvalidHandshake       = &apiError{status: http.StatusBadRequest, code: CodeInvalidHandshake, message: "handshake unknown, expired or already used, start a new one", retryable: true}
+	errInvalidCredentials     = &apiError{status: http.StatusUnauthorized, code: CodeInvalidCredentials, message: "invalid username or password"}
+	errInvalidSession         = &apiError{status: http.StatusUnauthorized, code: CodeInvalidSession, message: "session unknown, expired or revoked"}
+	errTLSRequired            = &apiError{status: http.StatusForbidden, code: CodeTLSRequired, message: "this endpoint requires TLS"}
+	errChannelBindingRequired = &apiError{status: http.StatusBadRequest, code: CodeChannelBinding, message: "offer tls-exporter channel binding and send both requests over TLS 1.3"}
+	errEnvelopeRequired       = &apiError{status: http.StatusUnsupportedMediaType, code: CodeUnsupportedMedia, message: "this route takes envelopes sealed with the session key"}
+	errInvalidEnvelope        = &apiError{status: http.StatusBadRequest, code: CodeInvalidEnvelope, message: "envelope is malformed or not sealed for this session and route"}
+	errReplayedEnvelope       = &apiError{status: http.StatusConflict, code: CodeReplayedEnvelope, message: "envelope already used or too old, send a new one"}
+	errReauthRequired         = &apiError{status: http.StatusForbidden, code: CodeReauthRequired, message: "confirm your password to continue"}
+	errUnavailable            = &apiError{status: http.StatusServiceUnavailable, code: CodeUnavailable, message: "not accepting logins, try again later", retryable: true, retryAfter: unavailableRetryAfter}
+	errInternal               = &apiError{status: http.StatusInternalServerError, code: CodeInternal, message: "internal error", retryable: true}
+)
+
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+	case errors.Is(err, auth.ErrDraining):
+		return errUnavailable
+	case errors.Is(err, auth.ErrInvalidClientPublic):
+		return errInvalidClientPublic
+	case errors.Is(err, auth.ErrHandshakeNotFound),
+		errors.Is(err, auth.ErrHandshakeExpired),
+		errors.Is(err, auth.ErrHandshakeReplayed),
+		errors.Is(err, auth.ErrMalformedHandshake):
+		return errInvalidHandshake
+	case errors.Is(err, session.ErrSessionNotFound),
+		errors.Is(err, session.ErrSessionExpired),
+		errors.Is(err, session.ErrSessionRevoked):
+		return errInvalidSession
+	default:
+		return errInternal
+	}
+}
+
+// writeError answers with the ErrorResponse for err. Errors without a code
+// of their own are logged and sent as internal errors.
+func (srv *Server) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	apiErr := toAPIError(err)
+	if apiErr == errInternal && err != errInternal {
+		srv.httpLogger.ErrorContext(r.Context(), "Failed to handle request", "path", r.URL.Path, "err", err)
+	}
+
+	if apiErr.retryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(apiErr.retryAfter))
+	}
+	body, _ := json.Marshal(ErrorResponse{
+		Code:       apiErr.code,
+		Message:    apiErr.message,
+		Retryable:  apiErr.retryable,
+		RetryAfter: apiErr.retryAfter,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.status)
+	w.Write(body)
+}
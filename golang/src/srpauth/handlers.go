@@ -0,0 +1,252 @@
+package srpauth
+
+import (
+	"crypto"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sharpstorm/srp-auth/auth"
+	"sharpstorm/srp-auth/auth/audit"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+func (srv *Server) startHandshake(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req HandshakeRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	channelBinding, err := srv.negotiateChannelBinding(r, req.ChannelBindings)
+	if err != nil {
+		srv.record(r, audit.HandshakeStart, req.Username, "no channel binding")
+		srv.writeError(w, r, err)
+		return
+	}
+
+	handshake, err := srv.handshakeManager.GenerateHandshake(r.Context(), req.Username, req.ClientPublic, channelBinding)
+	if err != nil {
+		if !errors.Is(err, auth.ErrDraining) {
+			srv.record(r, audit.HandshakeStart, req.Username, err.Error())
+		}
+		srv.writeError(w, r, err)
+		return
+	}
+
+	if handshake.Decoy {
+		srv.record(r, audit.HandshakeStart, req.Username, auth.ErrHandshakeUnavailable.Error())
+	} else {
+		srv.record(r, audit.HandshakeStart, req.Username, "")
+		if srv.hooks.OnHandshake != nil {
+			srv.hooks.OnHandshake(req.Username)
+		}
+	}
+
+	resp := HandshakeResponse{
+		Salt:           handshake.Salt,
+		PublicKey:      handshake.PublicKey,
+		Hid:            handshake.HandshakeId,
+		Group:          handshake.Group.Name,
+		Hash:           handshake.Hash.String(),
+		KDF:            handshake.KDF,
+		ChannelBinding: handshake.ChannelBinding,
+	}
+	if err = srv.signHandshake(req.Username, req.ClientPublic, &resp); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+	result, _ := json.Marshal(resp)
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(result)
+}
+
+func (srv *Server) verifyClient(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req VerifyRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	handshake, err := srv.verifyProof(r, req.Username, req.Hid, req.ClientProof)
+	if err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	sessionId, err := srv.sessionManager.CreateSession(r.Context(), req.Username, handshake.Verifier.GetSessionSecret())
+	if err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+	srv.record(r, audit.LoginSuccess, req.Username, "")
+	if srv.hooks.OnLoginSuccess != nil {
+		srv.hooks.OnLoginSuccess(req.Username)
+	}
+
+	respBody, _ := json.Marshal(VerifyResponse{
+		Result:      true,
+		ServerProof: handshake.Verifier.GetServerProof(),
+		SessionId:   sessionId,
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(respBody)
+}
+
+// verifyProof consumes the handshake and checks the client's proof for it,
+// recording why it failed. A proof of the wrong length leaves the handshake
+// in place, so the client can still retry it.
+func (srv *Server) verifyProof(r *http.Request, username string, hid string, clientProof []byte) (*auth.SrpHandshakeSession, error) {
+	if len(clientProof) != srv.handshakeManager.ProofSize(username) {
+		srv.record(r, audit.LoginFailure, username, "malformed client proof")
+		return nil, errBadRequest
+	}
+
+	handshake, err := srv.handshakeManager.ConsumeHandshake(r.Context(), username, hid)
+	if err != nil {
+		srv.record(r, audit.LoginFailure, username, "unknown or expired handshake")
+		return nil, err
+	}
+
+	if err = bindChannel(r, handshake.ChannelBinding, handshake.Verifier); err != nil {
+		srv.record(r, audit.LoginFailure, username, "no channel binding")
+		return nil, err
+	}
+
+	if !handshake.Verifier.IsClientProofValid(clientProof) {
+		if handshake.Decoy {
+			srv.record(r, audit.LoginFailure, username, auth.ErrHandshakeUnavailable.Error())
+		} else {
+			srv.record(r, audit.LoginFailure, username, "wrong password")
+		}
+		if srv.hooks.OnLoginFailure != nil {
+			srv.hooks.OnLoginFailure(username)
+		}
+		return nil, errInvalidCredentials
+	}
+	return handshake, nil
+}
+
+// reauthenticate checks a fresh proof from the user of an existing session,
+// for routes behind RequireRecentAuth. The handshake is started as for a
+// login, with the session's username.
+func (srv *Server) reauthenticate(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req ReauthRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	_, username, err := srv.sessionManager.GetSession(req.SessionId)
+	if err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	handshake, err := srv.verifyProof(r, username, req.Hid, req.ClientProof)
+	if err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	sessionId, err := srv.sessionManager.Reauthenticate(r.Context(), req.SessionId, handshake.Verifier.GetSessionSecret())
+	if err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	respBody, _ := json.Marshal(VerifyResponse{
+		Result:      true,
+		ServerProof: handshake.Verifier.GetServerProof(),
+		SessionId:   sessionId,
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(respBody)
+}
+
+func (srv *Server) whoAmI(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req WhoAmIRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	session, username, err := srv.sessionManager.GetSession(req.SessionId)
+	if err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	hasher := crypto.SHA512.New()
+	hasher.Write([]byte(username))
+	hasher.Write(session.Secret)
+	respBody, _ := json.Marshal(WhoAmIResponse{
+		Proof: hasher.Sum(nil),
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(respBody)
+}
+
+func (srv *Server) logout(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	var req LogoutRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	if err := srv.sessionManager.CheckSession(req.SessionId); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	if err := srv.sessionManager.RemoveSession(r.Context(), req.SessionId); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (srv *Server) upgradeLegacyUser(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	if r.TLS == nil && !srv.legacyLoginInsecure {
+		srv.writeError(w, r, errTLSRequired)
+		return
+	}
+
+	var req LegacyLoginRequest
+	if err := decodeRequest(w, r, &req); err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	if err := srv.legacyAttempts.allow(req.Username, r.RemoteAddr); err != nil {
+		srv.record(r, audit.LegacyLogin, req.Username, "too many attempts")
+		srv.writeError(w, r, err)
+		return
+	}
+
+	// Unknown, disabled and already upgraded users get the same answer as
+	// a wrong password, in about the same time.
+	upgraded, err := srv.credsManager.UpgradeLegacyUser(req.Username, req.Password)
+	if err != nil {
+		srv.writeError(w, r, err)
+		return
+	}
+
+	if !upgraded {
+		srv.record(r, audit.LegacyLogin, req.Username, "rejected")
+		srv.writeError(w, r, errInvalidCredentials)
+		return
+	}
+	srv.record(r, audit.LegacyLogin, req.Username, "")
+
+	respBody, _ := json.Marshal(LegacyLoginResponse{
+		Result: true,
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(respBody)
+}
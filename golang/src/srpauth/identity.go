@@ -0,0 +1,70 @@
+package srpauth
+
+import (
+	"crypto/ed25519"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const handshakeSignaturePrefix = "srp-auth handshake signature v1"
+
+// signHandshake signs the response with the identity key ring, if one is
+// set. The signature covers the username and A as well, so it cannot be
+// replayed in answer to another handshake.
+func (srv *Server) signHandshake(username string, clientPublic []byte, resp *HandshakeResponse) error {
+	if srv.identityKeys == nil {
+		return nil
+	}
+
+	kid, signature, err := srv.identityKeys.Sign(handshakeTranscript(username, clientPublic, resp))
+	if err != nil {
+		return err
+	}
+	resp.KeyId = kid
+	resp.Signature = signature
+	return nil
+}
+
+// VerifyHandshake reports whether resp, the answer to a handshake started
+// with username and clientPublic, is signed by publicKey. Clients pinning
+// the server's identity check it before computing their proof.
+func VerifyHandshake(publicKey ed25519.PublicKey, username string, clientPublic []byte, resp *HandshakeResponse) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(publicKey, handshakeTranscript(username, clientPublic, resp), resp.Signature)
+}
+
+// handshakeTranscript encodes everything the signature covers, each field
+// prefixed with its length. The key id is only a hint for finding the key
+// and is not covered.
+func handshakeTranscript(username string, clientPublic []byte, resp *HandshakeResponse) []byte {
+	out := []byte(handshakeSignaturePrefix)
+	for _, field := range [][]byte{
+		[]byte(username),
+		clientPublic,
+		resp.Salt,
+		resp.PublicKey,
+		[]byte(resp.Hid),
+		[]byte(resp.Group),
+		[]byte(resp.Hash),
+		[]byte(resp.KDF),
+		[]byte(resp.ChannelBinding),
+	} {
+		out = binary.BigEndian.AppendUint32(out, uint32(len(field)))
+		out = append(out, field...)
+	}
+	return out
+}
+
+func (srv *Server) identity(w http.ResponseWriter, r *http.Request, _ httprouter.Params) {
+	respBody, _ := json.Marshal(IdentityResponse{
+		Keys: srv.identityKeys.PublicKeys(),
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	w.Write(respBody)
+}
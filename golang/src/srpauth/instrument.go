@@ -0,0 +1,221 @@
+package srpauth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sharpstorm/srp-auth/auth"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/session"
+	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/metrics"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+type serverMetrics struct {
+	handshakes        *metrics.CounterVec
+	handshakeDuration *metrics.Histogram
+	verifications     *metrics.CounterVec
+	sessionsIssued    *metrics.Counter
+	sessionsRevoked   *metrics.Counter
+	userChanges       *metrics.CounterVec
+	requests          *metrics.CounterVec
+	requestDuration   *metrics.HistogramVec
+}
+
+func newServerMetrics(reg *metrics.Registry) *serverMetrics {
+	return &serverMetrics{
+		handshakes:        reg.NewCounterVec("srp_handshakes_total", "Handshakes started, by result.", "result"),
+		handshakeDuration: reg.NewHistogram("srp_handshake_duration_seconds", "Time taken to generate a handshake.", metrics.DefaultBuckets),
+		verifications:     reg.NewCounterVec("srp_verifications_total", "Client proofs checked, by result.", "result"),
+		sessionsIssued:    reg.NewCounter("srp_sessions_issued_total", "Sessions issued after a successful login."),
+		sessionsRevoked:   reg.NewCounter("srp_sessions_revoked_total", "Sessions ended by logout."),
+		userChanges:       reg.NewCounterVec("srp_user_changes_total", "Changes to stored users made by the server, by operation and result.", "op", "result"),
+		requests:          reg.NewCounterVec("srp_http_requests_total", "HTTP requests to the auth API, by route and status code.", "route", "code"),
+		requestDuration:   reg.NewHistogramVec("srp_http_request_duration_seconds", "HTTP request latency of the auth API, by route.", metrics.DefaultBuckets, "route"),
+	}
+}
+
+// instrument wraps the managers so that the handlers need no knowledge of
+// metrics. It must run before the handshake manager is created.
+func (srv *Server) instrument(reg *metrics.Registry) {
+	srv.metrics = newServerMetrics(reg)
+
+	credsManager := srv.credsManager
+	reg.NewGaugeFunc("srp_users", "Users in the credential store.", func() float64 {
+		return float64(len(credsManager.ListUsers()))
+	})
+	if counter, ok := srv.sessionManager.(interface{ ActiveSessions() int }); ok {
+		reg.NewGaugeFunc("srp_sessions_active", "Sessions held by this instance, including expired ones not yet removed.", func() float64 {
+			return float64(counter.ActiveSessions())
+		})
+	}
+
+	srv.credsManager = &instrumentedCredentialManager{CredentialManager: srv.credsManager, metrics: srv.metrics}
+	srv.sessionManager = &instrumentedSessionManager{SessionManager: srv.sessionManager, metrics: srv.metrics}
+}
+
+func (srv *Server) instrumentHandshakes(reg *metrics.Registry) {
+	handshakeManager := srv.handshakeManager
+	reg.NewGaugeFunc("srp_handshake_users_pending", "Users mid handshake on this instance, 0 for the sealed store.", func() float64 {
+		return float64(handshakeManager.Pending())
+	})
+	srv.handshakeManager = &instrumentedHandshakeManager{HandshakeManager: srv.handshakeManager, metrics: srv.metrics}
+}
+
+type instrumentedHandshakeManager struct {
+	auth.HandshakeManager
+	metrics *serverMetrics
+}
+
+func (mgr *instrumentedHandshakeManager) GenerateHandshake(ctx context.Context, username string, clientPublic []byte, channelBinding string) (*auth.SrpHandshakeSession, error) {
+	start := time.Now()
+	handshake, err := mgr.HandshakeManager.GenerateHandshake(ctx, username, clientPublic, channelBinding)
+	switch {
+	case err == nil && handshake.Decoy:
+		mgr.metrics.handshakeDuration.Observe(time.Since(start).Seconds())
+		mgr.metrics.handshakes.With("decoy").Inc()
+	case err == nil:
+		mgr.metrics.handshakeDuration.Observe(time.Since(start).Seconds())
+		mgr.metrics.handshakes.With("ok").Inc()
+	case errors.Is(err, auth.ErrDraining):
+		mgr.metrics.handshakes.With("draining").Inc()
+	default:
+		mgr.metrics.handshakes.With("rejected").Inc()
+	}
+	return handshake, err
+}
+
+func (mgr *instrumentedHandshakeManager) ConsumeHandshake(ctx context.Context, username string, handshakeId string) (*auth.SrpHandshakeSession, error) {
+	handshake, err := mgr.HandshakeManager.ConsumeHandshake(ctx, username, handshakeId)
+	if err != nil {
+		mgr.metrics.verifications.With("unknown_handshake").Inc()
+		return nil, err
+	}
+
+	handshake.Verifier = &instrumentedVerifier{SRPVerifier: handshake.Verifier, metrics: mgr.metrics}
+	return handshake, nil
+}
+
+type instrumentedVerifier struct {
+	srp.SRPVerifier
+	metrics *serverMetrics
+}
+
+func (verifier *instrumentedVerifier) IsClientProofValid(clientProof []byte) bool {
+	valid := verifier.SRPVerifier.IsClientProofValid(clientProof)
+	if valid {
+		verifier.metrics.verifications.With("success").Inc()
+	} else {
+		verifier.metrics.verifications.With("failure").Inc()
+	}
+	return valid
+}
+
+type instrumentedSessionManager struct {
+	session.SessionManager
+	metrics *serverMetrics
+}
+
+func (mgr *instrumentedSessionManager) CreateSession(ctx context.Context, username string, secret []byte) (string, error) {
+	sessionId, err := mgr.SessionManager.CreateSession(ctx, username, secret)
+	if err == nil {
+		mgr.metrics.sessionsIssued.Inc()
+	}
+	return sessionId, err
+}
+
+func (mgr *instrumentedSessionManager) RemoveSession(ctx context.Context, sessionId string) error {
+	err := mgr.SessionManager.RemoveSession(ctx, sessionId)
+	if err == nil {
+		mgr.metrics.sessionsRevoked.Inc()
+	}
+	return err
+}
+
+// Only the operations the server performs itself are counted, the admin
+// CLI runs in its own process.
+type instrumentedCredentialManager struct {
+	credentials.CredentialManager
+	metrics *serverMetrics
+}
+
+func (mgr *instrumentedCredentialManager) AddUser(username string, password string) error {
+	return mgr.count("add", mgr.CredentialManager.AddUser(username, password))
+}
+
+func (mgr *instrumentedCredentialManager) UpdateUser(username string, password string) error {
+	return mgr.count("update", mgr.CredentialManager.UpdateUser(username, password))
+}
+
+func (mgr *instrumentedCredentialManager) DeleteUser(username string) error {
+	return mgr.count("delete", mgr.CredentialManager.DeleteUser(username))
+}
+
+func (mgr *instrumentedCredentialManager) UpgradeLegacyUser(username string, password string) (bool, error) {
+	upgraded, err := mgr.CredentialManager.UpgradeLegacyUser(username, password)
+	switch {
+	case err != nil:
+		mgr.count("legacy_upgrade", err)
+	case upgraded:
+		mgr.metrics.userChanges.With("legacy_upgrade", "ok").Inc()
+	default:
+		mgr.metrics.userChanges.With("legacy_upgrade", "rejected").Inc()
+	}
+	return upgraded, err
+}
+
+func (mgr *instrumentedCredentialManager) count(op string, err error) error {
+	if err != nil {
+		mgr.metrics.userChanges.With(op, "error").Inc()
+	} else {
+		mgr.metrics.userChanges.With(op, "ok").Inc()
+	}
+	return err
+}
+
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (recorder *statusRecorder) WriteHeader(status int) {
+	if recorder.status == 0 {
+		recorder.status = status
+	}
+	recorder.ResponseWriter.WriteHeader(status)
+}
+
+func (recorder *statusRecorder) statusCode() int {
+	if recorder.status == 0 {
+		return http.StatusOK
+	}
+	return recorder.status
+}
+
+func (recorder *statusRecorder) Write(dat []byte) (int, error) {
+	if recorder.status == 0 {
+		recorder.status = http.StatusOK
+	}
+	return recorder.ResponseWriter.Write(dat)
+}
+
+// instrumentRoute counts requests and their latency under route, a fixed
+// name rather than the path so that label values stay bounded.
+func (srv *Server) instrumentRoute(route string, handle httprouter.Handle) httprouter.Handle {
+	if srv.metrics == nil {
+		return handle
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, params httprouter.Params) {
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		handle(recorder, r, params)
+
+		srv.metrics.requests.With(route, strconv.Itoa(recorder.statusCode())).Inc()
+		srv.metrics.requestDuration.With(route).Observe(time.Since(start).Seconds())
+	}
+}
@@ -0,0 +1,132 @@
+package srpauth
+
+import (
+	"context"
+	"net/http"
+	"sharpstorm/srp-auth/auth/session"
+	"sharpstorm/srp-auth/logging"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SessionHeader carries the session id issued by the verify endpoint.
+// "Authorization: Bearer <sessionid>" is accepted as well.
+const SessionHeader = "X-Session-Id"
+
+// RequestIdHeader carries the id requests are logged and audited under. It
+// is echoed in every response.
+const RequestIdHeader = "X-Request-Id"
+
+const maxRequestIdLen = 64
+
+type contextKey int
+
+const (
+	usernameContextKey contextKey = iota
+	sessionContextKey
+)
+
+// RequireSession rejects requests without an active session with 401 and
+// an invalid_session ErrorResponse, and otherwise makes the username and
+// session available through the request context, see UsernameFromContext
+// and SessionFromContext.
+func (srv *Server) RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionId := sessionIdFromRequest(r)
+		if sessionId == "" {
+			srv.writeError(w, r, errInvalidSession)
+			return
+		}
+		sessionObj, username, err := srv.sessionManager.GetSession(sessionId)
+		if err != nil {
+			srv.writeError(w, r, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), usernameContextKey, username)
+		ctx = context.WithValue(ctx, sessionContextKey, sessionObj)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// RequireRecentAuth guards sensitive routes, such as changing a password or
+// revoking sessions. It runs RequireSession and then rejects sessions whose
+// user has not proved their password within the recent auth window, see
+// WithRecentAuthWindow, with 403 and reauthentication_required. The client
+// then goes through the reauth endpoint and retries with the session id it
+// returns.
+func (srv *Server) RequireRecentAuth(next http.Handler) http.Handler {
+	return srv.RequireSession(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sessionObj, _ := SessionFromContext(r.Context())
+		if time.Since(sessionObj.AuthenticatedAt) > srv.recentAuthWindow {
+			srv.writeError(w, r, errReauthRequired)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// AssignRequestId keeps a well formed request id sent by a client or proxy
+// and generates one otherwise, then makes it available to loggers through
+// the request context. The auth API routes already run behind it; wrap
+// other handlers in it to correlate their logs as well.
+func (srv *Server) AssignRequestId(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if logging.RequestId(r.Context()) != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestId := r.Header.Get(RequestIdHeader)
+		if !isValidRequestId(requestId) {
+			requestId = uuid.NewString()
+		}
+		w.Header().Set(RequestIdHeader, requestId)
+
+		ctx := logging.WithRequestId(r.Context(), requestId)
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		srv.httpLogger.DebugContext(ctx, "Handled request", "method", r.Method, "path", r.URL.Path,
+			"status", recorder.statusCode(), "duration", time.Since(start))
+	})
+}
+
+// Ids end up in logs, so only short ids of unremarkable characters are
+// taken from the client.
+func isValidRequestId(requestId string) bool {
+	if requestId == "" || len(requestId) > maxRequestIdLen {
+		return false
+	}
+	for _, c := range requestId {
+		isAlnum := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+		if !isAlnum && c != '-' && c != '_' && c != '.' {
+			return false
+		}
+	}
+	return true
+}
+
+func UsernameFromContext(ctx context.Context) (string, bool) {
+	username, ok := ctx.Value(usernameContextKey).(string)
+	return username, ok
+}
+
+func SessionFromContext(ctx context.Context) (*session.Session, bool) {
+	sessionObj, ok := ctx.Value(sessionContextKey).(*session.Session)
+	return sessionObj, ok
+}
+
+func sessionIdFromRequest(r *http.Request) string {
+	if sessionId := r.Header.Get(SessionHeader); sessionId != "" {
+		return sessionId
+	}
+
+	authHeader := r.Header.Get("Authorization")
+	if token, found := strings.CutPrefix(authHeader, "Bearer "); found {
+		return strings.TrimSpace(token)
+	}
+	return ""
+}
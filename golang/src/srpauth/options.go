@@ -0,0 +1,159 @@
+package srpauth
+
+import (
+	"log/slog"
+	"sharpstorm/srp-auth/auth"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/identity"
+	"sharpstorm/srp-auth/auth/session"
+	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/metrics"
+	"time"
+)
+
+type Option func(*Server)
+
+// Hooks are called synchronously from the request goroutine, so they should
+// not block.
+type Hooks struct {
+	OnHandshake    func(username string)
+	OnLoginSuccess func(username string)
+	OnLoginFailure func(username string)
+}
+
+func WithCredentialManager(credsManager credentials.CredentialManager) Option {
+	return func(srv *Server) {
+		srv.credsManager = credsManager
+	}
+}
+
+func WithSessionManager(sessionManager session.SessionManager) Option {
+	return func(srv *Server) {
+		srv.sessionManager = sessionManager
+	}
+}
+
+// WithGroups lists every group stored credentials may have been created under.
+func WithGroups(groups ...*srp.ConstantGroup) Option {
+	return func(srv *Server) {
+		srv.handshakeOptions.Groups = groups
+	}
+}
+
+func WithHandshakeLimits(validity time.Duration, limit int) Option {
+	return func(srv *Server) {
+		srv.handshakeOptions.Validity = validity
+		srv.handshakeOptions.Limit = limit
+	}
+}
+
+// WithHandshakeStore replaces the per instance in-memory handshake store,
+// e.g. with auth.NewSealedHandshakeStore so verify can reach any instance.
+func WithHandshakeStore(store auth.HandshakeStore) Option {
+	return func(srv *Server) {
+		srv.handshakeOptions.Store = store
+	}
+}
+
+// WithDecoyKey keys the decoy handshakes given to users that cannot log
+// in. Pass the same secret to every instance and never rotate it, so a
+// decoy's salt does not change between instances or across restarts.
+func WithDecoyKey(key []byte) Option {
+	return func(srv *Server) {
+		srv.handshakeOptions.DecoyKey = key
+	}
+}
+
+// WithLegacyLogin serves the legacy-login endpoint, which upgrades users
+// imported with a password hash. Requests without TLS are refused unless
+// allowInsecure is set.
+func WithLegacyLogin(allowInsecure bool) Option {
+	return func(srv *Server) {
+		srv.legacyLogin = true
+		srv.legacyLoginInsecure = allowInsecure
+	}
+}
+
+// WithLegacyLoginLimits caps legacy login attempts per username and per
+// remote address within each window, since the endpoint checks plain
+// passwords. The defaults are 5 and 50 per 15 minutes.
+func WithLegacyLoginLimits(perUser int, perAddress int, window time.Duration) Option {
+	return func(srv *Server) {
+		srv.legacyAttempts = newAttemptLimiter(perUser, perAddress, window)
+	}
+}
+
+// WithChannelBinding sets whether SRP proofs are bound to the TLS
+// connection: ChannelBindingOptional (the default) binds them when the
+// client offers it, ChannelBindingRequired rejects handshakes that cannot be
+// bound and ChannelBindingOff never binds them.
+func WithChannelBinding(policy string) Option {
+	return func(srv *Server) {
+		srv.channelBinding = policy
+	}
+}
+
+// WithIdentityKeys signs handshake responses with the ring's primary key
+// and publishes its keys at GET <base path>/identity for clients to pin.
+func WithIdentityKeys(keyRing identity.KeyRing) Option {
+	return func(srv *Server) {
+		srv.identityKeys = keyRing
+	}
+}
+
+// WithEnvelopeReplayCache replaces the per instance in-memory record of
+// envelopes RequireEnvelope has accepted. Pass one shared between instances,
+// such as session.NewFileRevocationList, so a request cannot be replayed
+// against another instance or after a restart.
+func WithEnvelopeReplayCache(replay auth.ReplayCache) Option {
+	return func(srv *Server) {
+		srv.envelopeReplay = replay
+	}
+}
+
+// WithRecentAuthWindow sets how long after a login or reauthentication
+// RequireRecentAuth lets a session through, 5 minutes by default.
+func WithRecentAuthWindow(window time.Duration) Option {
+	return func(srv *Server) {
+		srv.recentAuthWindow = window
+	}
+}
+
+func WithHooks(hooks Hooks) Option {
+	return func(srv *Server) {
+		srv.hooks = hooks
+	}
+}
+
+// WithAuditLogger records handshakes and logins. Pass the same logger to
+// the session and credential managers to audit sessions and user changes.
+// Close closes the logger after the managers.
+func WithAuditLogger(auditLog audit.Logger) Option {
+	return func(srv *Server) {
+		srv.auditLog = auditLog
+	}
+}
+
+// WithMetrics registers the server's counters, gauges and latency
+// histograms on reg. Serve reg.Handler() somewhere the public cannot reach.
+func WithMetrics(reg *metrics.Registry) Option {
+	return func(srv *Server) {
+		srv.metricsRegistry = reg
+	}
+}
+
+// WithLogger is passed on to the handshake manager and the default session
+// manager, which tag its records with their subsystem.
+func WithLogger(logger *slog.Logger) Option {
+	return func(srv *Server) {
+		srv.logger = logger
+	}
+}
+
+// WithBasePath sets the path the API routes are served under, "/api/auth" by default.
+func WithBasePath(basePath string) Option {
+	return func(srv *Server) {
+		srv.basePath = basePath
+	}
+}
@@ -0,0 +1,98 @@
+package srpauth
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/srp"
+)
+
+const (
+	// Large enough for an 8192-bit client public key, base64 encoded.
+	maxRequestBytes = 16 << 10
+	// Sealed handshake ids carry the client public key and session tokens
+	// a sealed key, both stay well below these.
+	maxHandshakeIdLength = 4096
+	maxSessionIdLength   = 1024
+	maxPasswordLength    = 1024
+)
+
+// validatable requests check their fields after decoding. Checks that need
+// the user's group or hash are left to the handlers, which make them before
+// the handshake is consumed.
+type validatable interface {
+	validate() error
+}
+
+// decodeRequest reads a single JSON object of at most maxRequestBytes into
+// req, rejecting other content types, unknown fields and trailing data.
+func decodeRequest(w http.ResponseWriter, r *http.Request, req validatable) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "application/json" {
+		return errUnsupportedMediaType
+	}
+
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestBytes))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(req); err != nil {
+		return decodeError(err)
+	}
+	if _, err = decoder.Token(); !errors.Is(err, io.EOF) {
+		return decodeError(err)
+	}
+	return req.validate()
+}
+
+func decodeError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return errRequestTooLarge
+	}
+	return errBadRequest
+}
+
+func (req *HandshakeRequest) validate() error {
+	if credentials.ValidateUsername(req.Username) != nil || len(req.ClientPublic) == 0 {
+		return errBadRequest
+	}
+	return nil
+}
+
+func (req *VerifyRequest) validate() error {
+	if credentials.ValidateUsername(req.Username) != nil || req.Hid == "" || len(req.Hid) > maxHandshakeIdLength || !srp.IsDigestSize(len(req.ClientProof)) {
+		return errBadRequest
+	}
+	return nil
+}
+
+func (req *ReauthRequest) validate() error {
+	if validateSessionId(req.SessionId) != nil || req.Hid == "" || len(req.Hid) > maxHandshakeIdLength || !srp.IsDigestSize(len(req.ClientProof)) {
+		return errBadRequest
+	}
+	return nil
+}
+
+func (req *WhoAmIRequest) validate() error {
+	return validateSessionId(req.SessionId)
+}
+
+func (req *LogoutRequest) validate() error {
+	return validateSessionId(req.SessionId)
+}
+
+func (req *LegacyLoginRequest) validate() error {
+	if credentials.ValidateUsername(req.Username) != nil || req.Password == "" || len(req.Password) > maxPasswordLength {
+		return errBadRequest
+	}
+	return nil
+}
+
+func validateSessionId(sessionId string) error {
+	if sessionId == "" || len(sessionId) > maxSessionIdLength {
+		return errBadRequest
+	}
+	return nil
+}
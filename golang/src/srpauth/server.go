@@ -0,0 +1,193 @@
+package srpauth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sharpstorm/srp-auth/auth"
+	"sharpstorm/srp-auth/auth/audit"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/identity"
+	"sharpstorm/srp-auth/auth/session"
+	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/logging"
+	"sharpstorm/srp-auth/metrics"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+)
+
+const (
+	defaultBasePath          = "/api/auth"
+	defaultHandshakeValidity = 10 * time.Second
+	defaultHandshakeLimit    = 3
+	defaultSessionsPerUser   = 3
+	defaultSessionTTL        = 24 * time.Hour
+	defaultRecentAuthWindow  = 5 * time.Minute
+
+	defaultLegacyAttemptsPerUser    = 5
+	defaultLegacyAttemptsPerAddress = 50
+	defaultLegacyAttemptWindow      = 15 * time.Minute
+)
+
+type Server struct {
+	credsManager     credentials.CredentialManager
+	handshakeManager auth.HandshakeManager
+	sessionManager   session.SessionManager
+
+	handshakeOptions auth.HandshakeOptions
+	hooks            Hooks
+	auditLog         audit.Logger
+	metricsRegistry  *metrics.Registry
+	metrics          *serverMetrics
+	logger           *slog.Logger
+	httpLogger       *slog.Logger
+	basePath         string
+
+	envelopes      envelopeChannels
+	envelopeReplay auth.ReplayCache
+	identityKeys   identity.KeyRing
+
+	channelBinding      string
+	recentAuthWindow    time.Duration
+	legacyLogin         bool
+	legacyLoginInsecure bool
+	legacyAttempts      *attemptLimiter
+
+	handler http.Handler
+}
+
+func NewServer(opts ...Option) (*Server, error) {
+	srv := &Server{
+		handshakeOptions: auth.HandshakeOptions{
+			Groups:   srp.BuiltinGroups(),
+			Validity: defaultHandshakeValidity,
+			Limit:    defaultHandshakeLimit,
+		},
+		auditLog:         audit.Discard,
+		logger:           slog.Default(),
+		basePath:         defaultBasePath,
+		channelBinding:   ChannelBindingOptional,
+		recentAuthWindow: defaultRecentAuthWindow,
+		legacyAttempts:   newAttemptLimiter(defaultLegacyAttemptsPerUser, defaultLegacyAttemptsPerAddress, defaultLegacyAttemptWindow),
+	}
+
+	for _, opt := range opts {
+		opt(srv)
+	}
+
+	if srv.credsManager == nil {
+		return nil, errors.New("srpauth: a credential manager is required")
+	}
+	if !slices.Contains([]string{ChannelBindingOff, ChannelBindingOptional, ChannelBindingRequired}, srv.channelBinding) {
+		return nil, fmt.Errorf("srpauth: unknown channel binding policy %q", srv.channelBinding)
+	}
+	if srv.recentAuthWindow <= 0 {
+		return nil, errors.New("srpauth: the recent auth window must be positive")
+	}
+	if srv.legacyAttempts.perUser < 1 || srv.legacyAttempts.perAddress < 1 || srv.legacyAttempts.window <= 0 {
+		return nil, errors.New("srpauth: legacy login limits must be positive")
+	}
+	if srv.sessionManager == nil {
+		srv.sessionManager = session.NewSessionManager(session.NewMemoryStore(), session.SessionOptions{
+			MaxPerUser: defaultSessionsPerUser,
+			TTL:        defaultSessionTTL,
+			Audit:      srv.auditLog,
+			Logger:     srv.logger,
+		})
+	}
+	if srv.envelopeReplay == nil {
+		srv.envelopeReplay = session.NewMemoryRevocationList()
+	}
+	srv.basePath = strings.TrimSuffix(srv.basePath, "/")
+	srv.httpLogger = logging.For(srv.logger, "http")
+
+	if srv.metricsRegistry != nil {
+		srv.instrument(srv.metricsRegistry)
+	}
+	if srv.handshakeOptions.Logger == nil {
+		srv.handshakeOptions.Logger = srv.logger
+	}
+	srv.handshakeManager = auth.NewHandshakeManager(srv.credsManager, srv.handshakeOptions)
+	if srv.metricsRegistry != nil {
+		srv.instrumentHandshakes(srv.metricsRegistry)
+	}
+	srv.handler = srv.buildRouter()
+	return srv, nil
+}
+
+// Handler serves the SRP endpoints under the base path. Mount it on a mux
+// at the same path, e.g. mux.Handle("/api/auth/", srv.Handler()).
+func (srv *Server) Handler() http.Handler {
+	return srv.handler
+}
+
+// Drain stops issuing handshakes and waits for outstanding ones to be
+// verified or to expire. Keep serving requests until it returns, then shut
+// down the HTTP server and call Close.
+func (srv *Server) Drain(ctx context.Context) error {
+	return srv.handshakeManager.Drain(ctx)
+}
+
+// Close stops the managers' background work and flushes sessions,
+// credentials and audit events. The server must not be used afterwards.
+func (srv *Server) Close() error {
+	srv.handshakeManager.Close()
+	return errors.Join(
+		srv.sessionManager.Close(),
+		srv.credsManager.Close(),
+		srv.auditLog.Close(),
+	)
+}
+
+// Ready reports why the server should not be sent new logins, or nil. It
+// fails once Drain has been called.
+func (srv *Server) Ready() error {
+	if err := srv.credsManager.Ready(); err != nil {
+		return err
+	}
+	if srv.handshakeManager.IsDraining() {
+		return auth.ErrDraining
+	}
+	return nil
+}
+
+func (srv *Server) SessionManager() session.SessionManager {
+	return srv.sessionManager
+}
+
+func (srv *Server) record(r *http.Request, eventType string, username string, reason string) {
+	srv.auditLog.Record(audit.Event{
+		Type:      eventType,
+		Username:  username,
+		Remote:    r.RemoteAddr,
+		Reason:    reason,
+		RequestId: logging.RequestId(r.Context()),
+	})
+}
+
+func (srv *Server) buildRouter() http.Handler {
+	router := httprouter.New()
+	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.writeError(w, r, errNotFound)
+	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		srv.writeError(w, r, errMethodNotAllowed)
+	})
+	router.POST(srv.basePath+"/handshake", srv.instrumentRoute("handshake", srv.startHandshake))
+	router.POST(srv.basePath+"/verify", srv.instrumentRoute("verify", srv.verifyClient))
+	router.POST(srv.basePath+"/whoami", srv.instrumentRoute("whoami", srv.whoAmI))
+	router.POST(srv.basePath+"/logout", srv.instrumentRoute("logout", srv.logout))
+	router.POST(srv.basePath+"/reauth", srv.instrumentRoute("reauth", srv.reauthenticate))
+	if srv.identityKeys != nil {
+		router.GET(srv.basePath+"/identity", srv.instrumentRoute("identity", srv.identity))
+	}
+	if srv.legacyLogin {
+		router.POST(srv.basePath+"/legacy-login", srv.instrumentRoute("legacy_login", srv.upgradeLegacyUser))
+	}
+	return srv.AssignRequestId(router)
+}
@@ -0,0 +1,283 @@
+package srpauth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sharpstorm/srp-auth/auth/credentials"
+	"sharpstorm/srp-auth/auth/identity"
+	"sharpstorm/srp-auth/auth/srp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// postRoutes lists every POST route with a body it accepts apart from the
+// field under test.
+var postRoutes = map[string]string{
+	"/handshake":    `{"username":"bob","clientpublic":"AQ=="}`,
+	"/verify":       `{"username":"bob","hid":"x","clientproof":"` + strings.Repeat("A", 43) + `="}`,
+	"/reauth":       `{"sessionid":"x","hid":"x","clientproof":"` + strings.Repeat("A", 43) + `="}`,
+	"/whoami":       `{"sessionid":"x"}`,
+	"/logout":       `{"sessionid":"x"}`,
+	"/legacy-login": `{"username":"bob","password":"x"}`,
+}
+
+// legacyHash is "legacy-pw" at bcrypt's lowest cost.
+const legacyHash = "$2a$04$qGK6xQg.Q.ZMAydDmIrE4.4z7AnsPeSJaXU918kQu4vUVddBDQnl."
+
+func newTestServer(t *testing.T, opts ...Option) *httptest.Server {
+	t.Helper()
+	ts := httptest.NewServer(newServer(t, opts...).Handler())
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func newServer(t *testing.T, opts ...Option) *Server {
+	t.Helper()
+	dir := t.TempDir()
+
+	engine, err := srp.NewSRPEngine(&srp.GROUP_2048, srp.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	credsManager, err := credentials.NewCredentialManager(credentials.GetCredentialSerializer(filepath.Join(dir, "users.json")), engine, credentials.CredentialOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = credsManager.AddUser("bob", "pw12345678"); err != nil {
+		t.Fatal(err)
+	}
+	legacyUsers := credentials.UserCredList{
+		"carol": {LegacyHash: legacyHash},
+		"dave":  {LegacyHash: legacyHash, Disabled: true},
+	}
+	if _, err = credsManager.ImportUsers(legacyUsers, false); err != nil {
+		t.Fatal(err)
+	}
+
+	identityFile := filepath.Join(dir, "identity.json")
+	if _, err = identity.AddKey(identityFile); err != nil {
+		t.Fatal(err)
+	}
+	identityKeys, err := identity.LoadKeyRing(identityFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv, err := NewServer(append([]Option{
+		WithCredentialManager(credsManager),
+		WithIdentityKeys(identityKeys),
+		WithLegacyLogin(true),
+	}, opts...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return srv
+}
+
+func send(t *testing.T, ts *httptest.Server, method string, path string, contentType string, body string) (int, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, ts.URL+defaultBasePath+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var apiErr struct {
+		Code string `json:"code"`
+	}
+	json.NewDecoder(resp.Body).Decode(&apiErr)
+	return resp.StatusCode, apiErr.Code
+}
+
+func expect(t *testing.T, status int, code string, wantStatus int, wantCode string) {
+	t.Helper()
+	if status != wantStatus || code != wantCode {
+		t.Errorf("got %d %q, want %d %q", status, code, wantStatus, wantCode)
+	}
+}
+
+func TestWrongMethod(t *testing.T) {
+	ts := newTestServer(t)
+	for path := range postRoutes {
+		t.Run(path, func(t *testing.T) {
+			status, code := send(t, ts, http.MethodGet, path, "", "")
+			expect(t, status, code, http.StatusMethodNotAllowed, CodeMethodNotAllowed)
+		})
+	}
+	t.Run("/identity", func(t *testing.T) {
+		status, code := send(t, ts, http.MethodPost, "/identity", "application/json", "{}")
+		expect(t, status, code, http.StatusMethodNotAllowed, CodeMethodNotAllowed)
+	})
+}
+
+func TestUnknownRoute(t *testing.T) {
+	ts := newTestServer(t)
+	status, code := send(t, ts, http.MethodPost, "/nope", "application/json", "{}")
+	expect(t, status, code, http.StatusNotFound, CodeNotFound)
+}
+
+func TestWrongContentType(t *testing.T) {
+	ts := newTestServer(t)
+	for path, body := range postRoutes {
+		t.Run(path, func(t *testing.T) {
+			for _, contentType := range []string{"", "text/plain", "application/x-www-form-urlencoded"} {
+				status, code := send(t, ts, http.MethodPost, path, contentType, body)
+				expect(t, status, code, http.StatusUnsupportedMediaType, CodeUnsupportedMedia)
+			}
+		})
+	}
+}
+
+func TestOversizeBody(t *testing.T) {
+	ts := newTestServer(t)
+	for path := range postRoutes {
+		t.Run(path, func(t *testing.T) {
+			body := `{"username":"` + strings.Repeat("a", maxRequestBytes) + `"}`
+			status, code := send(t, ts, http.MethodPost, path, "application/json", body)
+			expect(t, status, code, http.StatusRequestEntityTooLarge, CodeRequestTooLarge)
+		})
+	}
+}
+
+func TestMalformedBody(t *testing.T) {
+	ts := newTestServer(t)
+	for path, body := range postRoutes {
+		t.Run(path, func(t *testing.T) {
+			for _, malformed := range []string{
+				strings.Replace(body, "{", `{"extra":1,`, 1),
+				body + body,
+				"[]",
+				"",
+			} {
+				status, code := send(t, ts, http.MethodPost, path, "application/json", malformed)
+				expect(t, status, code, http.StatusBadRequest, CodeBadRequest)
+			}
+		})
+	}
+}
+
+func TestBadUsername(t *testing.T) {
+	ts := newTestServer(t)
+	for _, path := range []string{"/handshake", "/verify", "/legacy-login"} {
+		t.Run(path, func(t *testing.T) {
+			for _, username := range []string{"", "a:b", "tab\there", strings.Repeat("a", credentials.MaxUsernameLength+1), "nul\x00"} {
+				name, _ := json.Marshal(username)
+				body := strings.Replace(postRoutes[path], `"bob"`, string(name), 1)
+				status, code := send(t, ts, http.MethodPost, path, "application/json", body)
+				expect(t, status, code, http.StatusBadRequest, CodeBadRequest)
+			}
+		})
+	}
+}
+
+func TestClientPublicTooLong(t *testing.T) {
+	ts := newTestServer(t)
+	body, _ := json.Marshal(HandshakeRequest{Username: "bob", ClientPublic: bytes.Repeat([]byte{1}, srp.GROUP_2048.NByteLen()+1)})
+	status, code := send(t, ts, http.MethodPost, "/handshake", "application/json", string(body))
+	expect(t, status, code, http.StatusBadRequest, CodeInvalidClientPublic)
+}
+
+// A proof of the wrong length must not use up the handshake, the same hid
+// is then still accepted for a proof of the right length.
+func TestProofLengthCheckedBeforeConsume(t *testing.T) {
+	ts := newTestServer(t)
+	for _, username := range []string{"bob", "ghost"} {
+		t.Run(username, func(t *testing.T) {
+			body, _ := json.Marshal(HandshakeRequest{Username: username, ClientPublic: []byte{5}})
+			resp, err := ts.Client().Post(ts.URL+defaultBasePath+"/handshake", "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var handshake HandshakeResponse
+			json.NewDecoder(resp.Body).Decode(&handshake)
+			resp.Body.Close()
+			if handshake.Hid == "" {
+				t.Fatalf("handshake failed with %d", resp.StatusCode)
+			}
+
+			for _, size := range []int{srp.SHA512.Size(), srp.SHA1.Size(), 0, 7} {
+				body, _ = json.Marshal(VerifyRequest{Username: username, Hid: handshake.Hid, ClientProof: make([]byte, size)})
+				status, code := send(t, ts, http.MethodPost, "/verify", "application/json", string(body))
+				expect(t, status, code, http.StatusBadRequest, CodeBadRequest)
+			}
+
+			body, _ = json.Marshal(VerifyRequest{Username: username, Hid: handshake.Hid, ClientProof: make([]byte, srp.SHA256.Size())})
+			status, code := send(t, ts, http.MethodPost, "/verify", "application/json", string(body))
+			expect(t, status, code, http.StatusUnauthorized, CodeInvalidCredentials)
+
+			status, code = send(t, ts, http.MethodPost, "/verify", "application/json", string(body))
+			expect(t, status, code, http.StatusBadRequest, CodeInvalidHandshake)
+		})
+	}
+}
+
+func legacyLogin(t *testing.T, ts *httptest.Server, username string, password string) (int, string) {
+	t.Helper()
+	body, _ := json.Marshal(LegacyLoginRequest{Username: username, Password: password})
+	return send(t, ts, http.MethodPost, "/legacy-login", "application/json", string(body))
+}
+
+// Every reject gets the same answer, whether the user is unknown, disabled,
+// already migrated or gave the wrong password.
+func TestLegacyLoginRejects(t *testing.T) {
+	ts := newTestServer(t)
+	for _, test := range []struct{ name, username, password string }{
+		{"unknown", "ghost", "legacy-pw"},
+		{"disabled", "dave", "legacy-pw"},
+		{"not legacy", "bob", "pw12345678"},
+		{"wrong password", "carol", "wrong"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			status, code := legacyLogin(t, ts, test.username, test.password)
+			expect(t, status, code, http.StatusUnauthorized, CodeInvalidCredentials)
+		})
+	}
+
+	status, _ := legacyLogin(t, ts, "carol", "legacy-pw")
+	if status != http.StatusOK {
+		t.Fatalf("upgrade failed with %d", status)
+	}
+	status, code := legacyLogin(t, ts, "carol", "legacy-pw")
+	expect(t, status, code, http.StatusUnauthorized, CodeInvalidCredentials)
+}
+
+func TestLegacyLoginLimits(t *testing.T) {
+	ts := newTestServer(t, WithLegacyLoginLimits(2, 3, time.Minute))
+
+	for range 2 {
+		status, code := legacyLogin(t, ts, "carol", "wrong")
+		expect(t, status, code, http.StatusUnauthorized, CodeInvalidCredentials)
+	}
+	// The right password does not get past the limit either
+	status, code := legacyLogin(t, ts, "carol", "legacy-pw")
+	expect(t, status, code, http.StatusTooManyRequests, CodeTooManyAttempts)
+
+	// Refused attempts are not counted, another username gets the address's
+	// third and last one
+	status, code = legacyLogin(t, ts, "ghost", "wrong")
+	expect(t, status, code, http.StatusUnauthorized, CodeInvalidCredentials)
+	status, code = legacyLogin(t, ts, "erin", "wrong")
+	expect(t, status, code, http.StatusTooManyRequests, CodeTooManyAttempts)
+
+	body, _ := json.Marshal(LegacyLoginRequest{Username: "ghost", Password: "wrong"})
+	resp, err := ts.Client().Post(ts.URL+defaultBasePath+"/legacy-login", "application/json", strings.NewReader(string(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Errorf("Retry-After %q", retryAfter)
+	}
+}
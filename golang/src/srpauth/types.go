@@ -0,0 +1,88 @@
+package srpauth
+
+import "sharpstorm/srp-auth/auth/identity"
+
+type HandshakeRequest struct {
+	Username     string `json:"username"`
+	ClientPublic []byte `json:"clientpublic"`
+	// Channel binding types the client can compute, e.g. "tls-exporter"
+	ChannelBindings []string `json:"channelbindings,omitempty"`
+}
+
+type HandshakeResponse struct {
+	Salt      []byte `json:"salt"`
+	PublicKey []byte `json:"publickey"`
+	Hid       string `json:"hid"`
+	Group     string `json:"group"`
+	Hash      string `json:"hash"`
+	KDF       string `json:"kdf,omitempty"` // Set when x is not derived the default way, e.g. "rfc5054"
+	// Set when M1 and M2 must include the channel binding value of the
+	// connection verify is sent on, e.g. "tls-exporter"
+	ChannelBinding string `json:"channelbinding,omitempty"`
+	// Set when the server has identity keys: the Ed25519 signature of the
+	// response, see VerifyHandshake, and the id of the key that made it
+	KeyId     string `json:"kid,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+}
+
+type VerifyRequest struct {
+	Username    string `json:"username"`
+	Hid         string `json:"hid"`
+	ClientProof []byte `json:"clientproof"`
+}
+
+type VerifyResponse struct {
+	Result      bool   `json:"result"`
+	ServerProof []byte `json:"serverproof"`
+	SessionId   string `json:"sessionid"`
+}
+
+// ReauthRequest proves the password again for a session, with a handshake
+// started for the session's user. It is answered with a VerifyResponse
+// whose session id replaces the one sent.
+type ReauthRequest struct {
+	SessionId   string `json:"sessionid"`
+	Hid         string `json:"hid"`
+	ClientProof []byte `json:"clientproof"`
+}
+
+type WhoAmIRequest struct {
+	SessionId string `json:"sessionid"`
+}
+
+type WhoAmIResponse struct {
+	Proof []byte `json:"proof"`
+}
+
+type LogoutRequest struct {
+	SessionId string `json:"sessionid"`
+}
+
+// LegacyLoginRequest carries the plain password, it must only be sent over
+// TLS.
+type LegacyLoginRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// LegacyLoginResponse reports whether the user was upgraded. The client
+// then logs in through the SRP handshake with the same password.
+type LegacyLoginResponse struct {
+	Result bool `json:"result"`
+}
+
+// IdentityResponse lists the server's identity keys for clients to pin.
+// Keys that are not primary are either about to sign or still trusted
+// after a rotation.
+type IdentityResponse struct {
+	Keys []identity.PublicKey `json:"keys"`
+}
+
+// ErrorResponse is the body of every failed request, sent with a 4xx or 5xx
+// status. Code is one of the Code constants.
+type ErrorResponse struct {
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+	Retryable  bool   `json:"retryable"`            // Whether repeating the login or request may succeed
+	RetryAfter int    `json:"retryAfter,omitempty"` // Seconds to wait, also sent as Retry-After
+}
@@ -0,0 +1,412 @@
+package srpclient
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sharpstorm/srp-auth/auth/identity"
+	"sharpstorm/srp-auth/auth/srp"
+	"sharpstorm/srp-auth/envelope"
+	"sharpstorm/srp-auth/srpauth"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxResponseBytes = 1 << 20
+
+var (
+	ErrNotLoggedIn      = errors.New("not logged in")
+	ErrServerProof      = errors.New("server proof does not match, the server does not know the verifier")
+	ErrWrongGroup       = errors.New("user's credentials use another SRP group")
+	ErrNoChannelBinding = errors.New("server did not bind the login to the TLS connection")
+	ErrServerIdentity   = errors.New("handshake response is not signed by a pinned server key")
+	errNotTLS           = errors.New("proof was not sent over a TLS connection")
+)
+
+// Error is a failed request answered with an srpauth.ErrorResponse. Those
+// are never sealed, even on routes that take envelopes.
+type Error struct {
+	Status int
+	srpauth.ErrorResponse
+}
+
+func (err *Error) Error() string {
+	return fmt.Sprintf("srp-auth: %d %s: %s", err.Status, err.Code, err.Message)
+}
+
+// Client logs in to an srpauth.Server and talks to routes wrapped in
+// RequireEnvelope. It holds one session at a time and is safe for
+// concurrent use once logged in.
+type Client struct {
+	// Group A is computed in, which must be the group of the user's
+	// credentials. GROUP_3072 by default, like the browser client.
+	Group *srp.ConstantGroup
+	// RequireChannelBinding fails the login when the server does not bind
+	// it to the TLS connection
+	RequireChannelBinding bool
+	// PinnedKeys are the server identity keys trusted to sign handshake
+	// responses. When set, Login fails before computing its proof unless
+	// the response is signed by one of them, see FetchIdentity.
+	PinnedKeys []ed25519.PublicKey
+
+	authURL    string
+	httpClient *http.Client
+
+	username  string
+	sessionId string
+	keys      *envelope.SessionKeys
+	seq       atomic.Uint64
+	lock      sync.RWMutex
+}
+
+// New returns a client for the server's auth routes at authURL, e.g.
+// "https://example.com/api/auth". A nil httpClient uses http.DefaultClient.
+func New(authURL string, httpClient *http.Client) *Client {
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	return &Client{
+		Group:      &srp.GROUP_3072,
+		authURL:    strings.TrimSuffix(authURL, "/"),
+		httpClient: httpClient,
+	}
+}
+
+// Login runs the handshake and verify exchange and keeps the session. The
+// server's proof is checked, so a nil error also means the server holds
+// the user's verifier.
+func (client *Client) Login(ctx context.Context, username string, password string) error {
+	srpClient, verify, err := client.authenticate(ctx, username, password, "/verify", func(hid string, clientProof []byte) any {
+		return srpauth.VerifyRequest{Username: username, Hid: hid, ClientProof: clientProof}
+	})
+	if err != nil {
+		return err
+	}
+
+	keys, err := envelope.NewSessionKeys(verify.SessionId, srpClient.GetSessionSecret())
+	if err != nil {
+		return err
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.username = username
+	client.sessionId = verify.SessionId
+	client.keys = keys
+	client.seq.Store(0)
+	return nil
+}
+
+// Reauthenticate proves the password again for the current session, for
+// routes behind srpauth.RequireRecentAuth. The session switches to the
+// envelope keys of the new handshake and its id may change.
+func (client *Client) Reauthenticate(ctx context.Context, password string) error {
+	client.lock.RLock()
+	username, sessionId := client.username, client.sessionId
+	client.lock.RUnlock()
+	if sessionId == "" {
+		return ErrNotLoggedIn
+	}
+
+	srpClient, verify, err := client.authenticate(ctx, username, password, "/reauth", func(hid string, clientProof []byte) any {
+		return srpauth.ReauthRequest{SessionId: sessionId, Hid: hid, ClientProof: clientProof}
+	})
+	if err != nil {
+		return err
+	}
+
+	keys, err := envelope.NewSessionKeys(verify.SessionId, srpClient.GetSessionSecret())
+	if err != nil {
+		return err
+	}
+
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	if client.sessionId == sessionId {
+		// Stateful sessions keep their id, so the server would take
+		// sequence numbers counted again from 1 as replays.
+		client.sessionId = verify.SessionId
+		client.keys = keys
+	}
+	return nil
+}
+
+// authenticate runs a handshake and sends the proof to route in the body
+// made by proofRequest, then checks the server's proof.
+func (client *Client) authenticate(ctx context.Context, username string, password string, route string, proofRequest func(hid string, clientProof []byte) any) (srp.SRPClient, *srpauth.VerifyResponse, error) {
+	srpClient, err := srp.NewSRPClient(client.Group, username, password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var handshake srpauth.HandshakeResponse
+	err = client.post(ctx, "/handshake", srpauth.HandshakeRequest{
+		Username:        username,
+		ClientPublic:    srpClient.PublicKey(),
+		ChannelBindings: []string{srpauth.ChannelBindingTLSExporter},
+	}, &handshake)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if !client.isPinnedServer(username, srpClient.PublicKey(), &handshake) {
+		return nil, nil, ErrServerIdentity
+	}
+	if handshake.Group != client.Group.Name {
+		return nil, nil, ErrWrongGroup
+	}
+	if handshake.ChannelBinding == "" && client.RequireChannelBinding {
+		return nil, nil, ErrNoChannelBinding
+	}
+	hashType, err := srp.HashTypeByName(handshake.Hash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err = srpClient.SetServerPublicKey(hashType, handshake.KDF, handshake.Salt, handshake.PublicKey); err != nil {
+		return nil, nil, err
+	}
+
+	// The tls-exporter value belongs to the connection the proof is sent
+	// on, which is only known once the transport picked it, so the body is
+	// built when the transport first reads it.
+	var binding []byte
+	var bindErr error
+	trace := &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			if handshake.ChannelBinding != "" {
+				binding, bindErr = exportChannelBinding(info.Conn)
+			}
+		},
+	}
+	body := &lazyBody{build: func() ([]byte, error) {
+		if handshake.ChannelBinding != "" {
+			if binding == nil && bindErr == nil {
+				bindErr = errNotTLS
+			}
+			if bindErr != nil {
+				return nil, bindErr
+			}
+			srpClient.BindChannel(binding)
+		}
+		return json.Marshal(proofRequest(handshake.Hid, srpClient.GetClientProof()))
+	}}
+
+	var verify srpauth.VerifyResponse
+	if err = client.send(httptrace.WithClientTrace(ctx, trace), route, body, &verify); err != nil {
+		return nil, nil, err
+	}
+	if !verify.Result || !srpClient.IsServerProofValid(verify.ServerProof) {
+		return nil, nil, ErrServerProof
+	}
+	return srpClient, &verify, nil
+}
+
+// Logout ends the session on the server and forgets it.
+func (client *Client) Logout(ctx context.Context) error {
+	sessionId, _ := client.session()
+	if sessionId == "" {
+		return ErrNotLoggedIn
+	}
+
+	client.lock.Lock()
+	client.sessionId = ""
+	client.keys = nil
+	client.lock.Unlock()
+
+	return client.post(ctx, "/logout", srpauth.LogoutRequest{SessionId: sessionId}, nil)
+}
+
+// FetchIdentity returns the server's identity keys, for pinning them on
+// first use. Fetching them over the connection that is to be verified
+// only helps if that first connection is trusted.
+func (client *Client) FetchIdentity(ctx context.Context) ([]identity.PublicKey, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, client.authURL+"/identity", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return nil, readError(resp)
+	}
+	var identityResp srpauth.IdentityResponse
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&identityResp); err != nil {
+		return nil, err
+	}
+	return identityResp.Keys, nil
+}
+
+func (client *Client) isPinnedServer(username string, clientPublic []byte, handshake *srpauth.HandshakeResponse) bool {
+	if len(client.PinnedKeys) == 0 {
+		return true
+	}
+	for _, publicKey := range client.PinnedKeys {
+		if srpauth.VerifyHandshake(publicKey, username, clientPublic, handshake) {
+			return true
+		}
+	}
+	return false
+}
+
+func (client *Client) SessionId() string {
+	sessionId, _ := client.session()
+	return sessionId
+}
+
+func (client *Client) session() (string, *envelope.SessionKeys) {
+	client.lock.RLock()
+	defer client.lock.RUnlock()
+	return client.sessionId, client.keys
+}
+
+// Do sends req to a route wrapped in RequireEnvelope. The body is sealed
+// with the session key and the response body is replaced by the opened
+// plaintext, with its Content-Type restored. Errors the server answers
+// before the route's handler runs come back as *Error.
+func (client *Client) Do(req *http.Request) (*http.Response, error) {
+	sessionId, keys := client.session()
+	if keys == nil {
+		return nil, ErrNotLoggedIn
+	}
+
+	var plaintext []byte
+	if req.Body != nil {
+		var err error
+		plaintext, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	seq := client.seq.Add(1)
+	path := req.URL.RequestURI()
+	sealed, err := keys.SealRequest(seq, time.Now(), req.Method, path, req.Header.Get("Content-Type"), plaintext)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	outer := req.Clone(req.Context())
+	outer.Body = io.NopCloser(bytes.NewReader(body))
+	outer.ContentLength = int64(len(body))
+	outer.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	outer.Header.Set("Content-Type", envelope.ContentType)
+	outer.Header.Set(srpauth.SessionHeader, sessionId)
+
+	resp, err := client.httpClient.Do(outer)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != envelope.ContentType {
+		return nil, readError(resp)
+	}
+
+	var env envelope.Envelope
+	if err = json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&env); err != nil {
+		return nil, err
+	}
+	if env.Seq != seq {
+		return nil, envelope.ErrDecrypt
+	}
+	opened, err := keys.OpenResponse(&env, req.Method, path, resp.StatusCode)
+	if err != nil {
+		return nil, err
+	}
+
+	resp.Header.Set("Content-Type", env.Type)
+	resp.Header.Del("Content-Length")
+	resp.Body = io.NopCloser(bytes.NewReader(opened))
+	resp.ContentLength = int64(len(opened))
+	return resp, nil
+}
+
+func (client *Client) post(ctx context.Context, route string, reqBody any, respBody any) error {
+	dat, err := json.Marshal(reqBody)
+	if err != nil {
+		return err
+	}
+	return client.send(ctx, route, bytes.NewReader(dat), respBody)
+}
+
+func (client *Client) send(ctx context.Context, route string, body io.Reader, respBody any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, client.authURL+route, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return readError(resp)
+	}
+	if respBody == nil {
+		return nil
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(respBody)
+}
+
+func readError(resp *http.Response) error {
+	apiErr := &Error{Status: resp.StatusCode}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&apiErr.ErrorResponse); err != nil || apiErr.Code == "" {
+		apiErr.Code = srpauth.CodeInternal
+		apiErr.Message = "unexpected response: " + resp.Status
+	}
+	return apiErr
+}
+
+func exportChannelBinding(conn net.Conn) ([]byte, error) {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return nil, errNotTLS
+	}
+	state := tlsConn.ConnectionState()
+	return srpauth.TLSExporter(&state)
+}
+
+// lazyBody builds its content on the first Read. Transports that read the
+// body before they have a connection cannot send bound logins.
+type lazyBody struct {
+	build  func() ([]byte, error)
+	reader *bytes.Reader
+}
+
+func (body *lazyBody) Read(dat []byte) (int, error) {
+	if body.reader == nil {
+		content, err := body.build()
+		if err != nil {
+			return 0, err
+		}
+		body.reader = bytes.NewReader(content)
+	}
+	return body.reader.Read(dat)
+}
@@ -0,0 +1,115 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"log/slog"
+	"os"
+	"sharpstorm/srp-auth/auth/fileutil"
+	"sharpstorm/srp-auth/logging"
+	"sync"
+	"time"
+)
+
+const certCheckPeriod = time.Second
+
+// CertSource hands out the server certificate for each TLS handshake.
+type CertSource interface {
+	GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+// fileCertSource reloads the certificate and key when either file changes,
+// so that renewed certificates are served without a restart. Write the key
+// before the certificate; a pair that does not match is not loaded and the
+// old one is kept.
+type fileCertSource struct {
+	certFile      string
+	keyFile       string
+	cert          *tls.Certificate
+	loadedModTime [2]time.Time
+	lastChecked   time.Time
+	logger        *slog.Logger
+	lock          sync.Mutex
+}
+
+func LoadCertFiles(certFile string, keyFile string) (CertSource, error) {
+	source := &fileCertSource{
+		certFile: certFile,
+		keyFile:  keyFile,
+		logger:   logging.For(nil, "server"),
+	}
+	if err := source.reload(); err != nil {
+		return nil, err
+	}
+	return source, nil
+}
+
+func (source *fileCertSource) GetCertificate(_ *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	source.lock.Lock()
+	defer source.lock.Unlock()
+
+	source.refreshIfChanged()
+	return source.cert, nil
+}
+
+func (source *fileCertSource) refreshIfChanged() {
+	if time.Since(source.lastChecked) < certCheckPeriod {
+		return
+	}
+	source.lastChecked = time.Now()
+
+	modTimes, err := source.modTimes()
+	if err != nil || modTimes == source.loadedModTime {
+		return
+	}
+
+	if err = source.reload(); err != nil {
+		source.logger.Warn("Keeping old certificate, reload failed", "file", source.certFile, "err", err)
+	}
+}
+
+func (source *fileCertSource) modTimes() ([2]time.Time, error) {
+	certModTime, err := fileutil.ModTime(source.certFile)
+	if err != nil {
+		return [2]time.Time{}, err
+	}
+	keyModTime, err := fileutil.ModTime(source.keyFile)
+	if err != nil {
+		return [2]time.Time{}, err
+	}
+	return [2]time.Time{certModTime, keyModTime}, nil
+}
+
+func (source *fileCertSource) reload() error {
+	modTimes, err := source.modTimes()
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(source.certFile, source.keyFile)
+	if err != nil {
+		return err
+	}
+
+	source.cert = &cert
+	source.loadedModTime = modTimes
+	source.lastChecked = time.Now()
+	source.logger.Info("Loaded certificate", "file", source.certFile, "subject", cert.Leaf.Subject.String(), "not_after", cert.Leaf.NotAfter)
+	return nil
+}
+
+// LoadCertPool reads the PEM certificates in filePath, e.g. the CAs client
+// certificates must be signed by.
+func LoadCertPool(filePath string) (*x509.CertPool, error) {
+	dat, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(dat) {
+		return nil, errors.New("no PEM certificates found")
+	}
+	return pool, nil
+}
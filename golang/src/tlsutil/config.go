@@ -0,0 +1,34 @@
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+)
+
+// ServerConfig serves certificates from source with TLS 1.2 or later and,
+// for TLS 1.2, only forward secret AEAD cipher suites. TLS 1.3 suites are
+// not configurable in Go and are all acceptable.
+func ServerConfig(source CertSource) *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: source.GetCertificate,
+		CipherSuites: []uint16{
+			tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+			tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+			tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+			tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+		},
+		CurvePreferences: []tls.CurveID{tls.X25519MLKEM768, tls.X25519, tls.CurveP256},
+	}
+}
+
+// MutualConfig is ServerConfig that also requires a client certificate
+// signed by one of clientCAs.
+func MutualConfig(source CertSource, clientCAs *x509.CertPool) *tls.Config {
+	config := ServerConfig(source)
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	config.ClientCAs = clientCAs
+	return config
+}